@@ -7,11 +7,23 @@ type AstBase struct {
 	Length int
 }
 
-// later we'll add types
+// A type annotation, like 'num', 'Point', 'fn(num, str) -> bool' or 'str?'.
+type TypeAnnotation struct {
+	Name token.Token // the type's name, or the 'fn' keyword for function types
+
+	IsFn   bool
+	Params []TypeAnnotation // function types only
+	Return *TypeAnnotation  // function types only, 'nil' if it returns void
+
+	Nullable bool
+}
+
 type Parameter struct {
 	Name token.Token
+	Type *TypeAnnotation // optional
 }
 
 type Field struct {
 	Name token.Token
+	Type *TypeAnnotation // optional
 }
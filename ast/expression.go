@@ -21,6 +21,11 @@ type StringExpression struct {
 	Literal string
 }
 
+// The parts are the string segments and the interpolated expressions, in order.
+type InterpolationExpression struct {
+	Parts []Expression
+}
+
 type BoolExpression struct {
 	Literal bool
 }
@@ -33,6 +38,15 @@ type RangeExpression struct {
     Inclusive bool
 }
 
+type ListExpression struct {
+	Elements []Expression
+}
+
+type MapExpression struct {
+	Keys []Expression
+	Values []Expression
+}
+
 type NilExpression struct {}
 
 type VoidExpression struct {
@@ -102,11 +116,25 @@ type SetPropertyExpression struct {
 	Value Expression
 }
 
+type IndexExpression struct {
+	Left Expression
+	Index Expression
+}
+
+type SetIndexExpression struct {
+	Left Expression
+	Index Expression
+	Value Expression
+}
+
 // ---
 
 func (x NumberExpression) expr()     {}
 func (x StringExpression) expr()     {}
+func (x InterpolationExpression) expr() {}
 func (x BoolExpression) expr()       {}
+func (x ListExpression) expr()       {}
+func (x MapExpression) expr()        {}
 func (x NilExpression) expr()        {}
 func (x VoidExpression) expr()        {}
 func (x RangeExpression) expr() {}
@@ -122,3 +150,5 @@ func (x FnExpression) expr() {}
 func (x IfExpression) expr() {}
 func (x GetPropertyExpression) expr() {}
 func (x SetPropertyExpression) expr() {}
+func (x IndexExpression) expr() {}
+func (x SetIndexExpression) expr() {}
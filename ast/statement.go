@@ -14,6 +14,7 @@ type StmtData interface {
 type FnStatement struct {
 	Name token.Token
 	Parameters []Parameter
+	ReturnType *TypeAnnotation // optional
 	Body BlockStatement
 }
 
@@ -29,6 +30,7 @@ type ReturnStatement struct {
 
 type VarStatement struct {
 	Name token.Token
+	Type *TypeAnnotation // optional
 	Init Expression
 }
 
@@ -72,6 +74,21 @@ type ContinueStatement struct {
 	Token token.Token
 }
 
+type TryStatement struct {
+	Body     BlockStatement
+	Variable token.Token // optional, absent if the error isn't used
+	Catch    BlockStatement
+}
+
+// import "path";
+// import "path" as name;
+// import a, b from "path";
+type ImportStatement struct {
+	Path  token.Token // string
+	Alias token.Token // optional, absent if the file name is used
+	Names []token.Token // the selected names, empty if the whole module is imported
+}
+
 type ExprStatement struct {
 	Expr Expression
 }
@@ -88,6 +105,8 @@ func (x WhileStatement) stmt() {}
 func (x ForStatement) stmt() {}
 func (x ForVarStatement) stmt() {}
 func (x LoopStatement) stmt() {}
+func (x TryStatement) stmt()  {}
+func (x ImportStatement) stmt()  {}
 func (x ExprStatement) stmt()  {}
 func (x BreakStatement) stmt()  {}
 func (x ContinueStatement) stmt()  {}
@@ -0,0 +1,473 @@
+package bytecode
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"vm-go/token"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/verifier"
+)
+
+// The format of the '.vmc' files, all the integers are little endian:
+//
+//	magic     "VMC\x00"
+//	version   u16
+//	registry  u32 count, then the names of its globals, which must match the registry the file runs with
+//	globals   u32, how many globals the program has, the registry's included
+//	files     u32 count, then the name, the path and the CRC-32 of the source of each file
+//	chunk     the top-level chunk
+//
+// A chunk is its code (u32 length and the bytes), its constants (u32 count and each constant, tagged by
+// its kind), its metadata (one entry per byte of code: line, column, length and file index, -1 if none)
+// and the amount of inline caches of its property accesses (u32), which start empty.
+// Strings are a u32 length and the bytes.
+//
+// The sources aren't part of the file. When it's loaded, the lines of a source are only shown in the errors
+// if the file at its path is the same one it was built from.
+
+var magic = []byte{ 'V', 'M', 'C', 0 }
+
+// Changes whenever the format or the instruction set does.
+const Version uint16 = 3
+
+const (
+	constNumber byte = iota
+	constString
+	constFunction
+	constRecord
+)
+
+var ErrNotBytecode = errors.New("not a bytecode file")
+
+func IsBytecode(data []byte) bool {
+	return len(data) >= len(magic) && string(data[:len(magic)]) == string(magic)
+}
+
+// ---
+
+type writer struct {
+	w     *bufio.Writer
+	files map[*util.FileData]int32
+	err   error
+}
+
+func Write(w io.Writer, chunk value.Chunk, fileData *util.FileData, registry *value.Registry) error {
+	wr := &writer{
+		w: bufio.NewWriter(w),
+		files: map[*util.FileData]int32{},
+	}
+
+	wr.bytes(magic)
+	wr.u16(Version)
+
+	wr.u32(uint32(len(registry.Names())))
+
+	for _, name := range registry.Names() {
+		wr.string(name)
+	}
+
+	wr.u32(uint32(chunk.Globals))
+
+	// The file being built comes first, then the imported ones, in the order they appear.
+	files := []*util.FileData{ fileData }
+	wr.files[fileData] = 0
+	collectFiles(chunk, wr.files, &files)
+
+	wr.u32(uint32(len(files)))
+
+	for _, file := range files {
+		wr.string(file.Name)
+		wr.string(file.Path)
+		wr.u32(crc32.ChecksumIEEE([]byte(strings.Join(file.Lines, "\n"))))
+	}
+
+	wr.chunk(chunk)
+
+	if wr.err != nil {
+		return wr.err
+	}
+
+	return wr.w.Flush()
+}
+
+func collectFiles(chunk value.Chunk, indices map[*util.FileData]int32, files *[]*util.FileData) {
+	for _, meta := range chunk.Metadata {
+		if meta.File == nil {
+			continue
+		}
+
+		if _, ok := indices[meta.File]; !ok {
+			indices[meta.File] = int32(len(*files))
+			*files = append(*files, meta.File)
+		}
+	}
+
+	for _, constant := range chunk.Constants {
+		if fn, ok := constant.(value.ValueFunction); ok {
+			collectFiles(fn.Chunk, indices, files)
+		}
+	}
+}
+
+func (wr *writer) chunk(chunk value.Chunk) {
+	wr.u32(uint32(len(chunk.Code)))
+	wr.bytes(chunk.Code)
+
+	wr.u32(uint32(len(chunk.Constants)))
+
+	for _, constant := range chunk.Constants {
+		wr.constant(constant)
+	}
+
+	wr.u32(uint32(len(chunk.Metadata)))
+
+	for _, meta := range chunk.Metadata {
+		file := int32(-1)
+
+		if meta.File != nil {
+			file = wr.files[meta.File]
+		}
+
+		wr.i32(int32(meta.Position.Line))
+		wr.i32(int32(meta.Position.Col))
+		wr.i32(int32(meta.Length))
+		wr.i32(file)
+	}
+
+	wr.u32(uint32(len(chunk.Caches)))
+}
+
+func (wr *writer) constant(constant value.Value) {
+	switch c := constant.(type) {
+		case value.ValueNumber: {
+			wr.bytes([]byte{ constNumber })
+			wr.u64(math.Float64bits(c.Value))
+		}
+
+		case value.ValueString: {
+			wr.bytes([]byte{ constString })
+			wr.string(c.Value)
+		}
+
+		case value.ValueFunction: {
+			wr.bytes([]byte{ constFunction })
+			wr.u32(uint32(c.Arity))
+
+			if c.Name == nil {
+				wr.bytes([]byte{ 0 })
+			} else {
+				wr.bytes([]byte{ 1 })
+				wr.string(*c.Name)
+			}
+
+			wr.chunk(c.Chunk)
+		}
+
+		// The methods are added at runtime, by OP_APPEND_METHODS.
+		case value.ValueRecord: {
+			wr.bytes([]byte{ constRecord })
+			wr.string(c.Name)
+			wr.u32(uint32(len(c.FieldNames)))
+
+			for _, field := range c.FieldNames {
+				wr.string(field)
+			}
+		}
+
+		default: {
+			if wr.err == nil {
+				wr.err = fmt.Errorf("cannot serialize a constant of type '%s'", constant.Type())
+			}
+		}
+	}
+}
+
+func (wr *writer) bytes(b []byte) {
+	if wr.err == nil {
+		_, wr.err = wr.w.Write(b)
+	}
+}
+
+func (wr *writer) u16(n uint16) {
+	wr.bytes(binary.LittleEndian.AppendUint16(nil, n))
+}
+
+func (wr *writer) u32(n uint32) {
+	wr.bytes(binary.LittleEndian.AppendUint32(nil, n))
+}
+
+func (wr *writer) i32(n int32) {
+	wr.u32(uint32(n))
+}
+
+func (wr *writer) u64(n uint64) {
+	wr.bytes(binary.LittleEndian.AppendUint64(nil, n))
+}
+
+func (wr *writer) string(s string) {
+	wr.u32(uint32(len(s)))
+	wr.bytes([]byte(s))
+}
+
+// ---
+
+type reader struct {
+	data  []byte
+	pos   int
+	files []*util.FileData
+	err   error
+}
+
+// Loads a chunk written by 'Write', and verifies it. The registry must have the same globals as the one it was built with.
+// Returns the file data of the file it was built from too, for the errors without a position.
+func Read(data []byte, registry *value.Registry) (value.Chunk, *util.FileData, error) {
+	if !IsBytecode(data) {
+		return value.Chunk{}, nil, ErrNotBytecode
+	}
+
+	r := &reader{ data: data, pos: len(magic) }
+
+	if version := r.u16(); version != Version && r.err == nil {
+		return value.Chunk{}, nil, fmt.Errorf("the file was built for version %d of the bytecode, but this is version %d", version, Version)
+	}
+
+	names := make([]string, r.count())
+
+	for i := range names {
+		names[i] = r.string()
+	}
+
+	if r.err == nil && strings.Join(names, ",") != strings.Join(registry.Names(), ",") {
+		return value.Chunk{}, nil, errors.New("the file was built with different native functions")
+	}
+
+	globals := int(r.u32())
+
+	if r.err == nil && globals < len(names) {
+		return value.Chunk{}, nil, fmt.Errorf("the file has %d globals, but the registry alone has %d", globals, len(names))
+	}
+
+	r.files = make([]*util.FileData, r.count())
+
+	for i := range r.files {
+		name := r.string()
+		path := r.string()
+		checksum := r.u32()
+
+		r.files[i] = loadFile(name, path, checksum)
+	}
+
+	chunk := r.chunk()
+	chunk.Globals = globals
+
+	if r.err != nil {
+		return value.Chunk{}, nil, r.err
+	}
+
+	if r.pos != len(r.data) {
+		return value.Chunk{}, nil, errors.New("unexpected data after the chunk")
+	}
+
+	if len(r.files) == 0 {
+		return value.Chunk{}, nil, errors.New("the file being built is missing")
+	}
+
+	if err := verifier.Verify(chunk); err != nil {
+		return value.Chunk{}, nil, err
+	}
+
+	return chunk, r.files[0], nil
+}
+
+// The lines of the source are only used if it hasn't changed since the file was built.
+func loadFile(name, path string, checksum uint32) *util.FileData {
+	fileData := &util.FileData{
+		Name: name,
+		Path: path,
+		Lines: []string{},
+	}
+
+	source, err := os.ReadFile(path)
+
+	if err == nil && crc32.ChecksumIEEE(source) == checksum {
+		fileData.Lines = strings.Split(string(source), "\n")
+	}
+
+	return fileData
+}
+
+func (r *reader) chunk() value.Chunk {
+	chunk := value.Chunk{}
+	chunk.Code = r.bytes(r.count())
+	chunk.Constants = make([]value.Value, r.count())
+
+	for i := range chunk.Constants {
+		chunk.Constants[i] = r.constant()
+	}
+
+	chunk.Metadata = make([]value.ChunkMetadata, r.count())
+
+	for i := range chunk.Metadata {
+		line := r.i32()
+		col := r.i32()
+		length := r.i32()
+		file := r.i32()
+
+		meta := value.ChunkMetadata{
+			Position: token.Position{ Line: int(line), Col: int(col) },
+			Length: int(length),
+		}
+
+		if file >= 0 && int(file) < len(r.files) {
+			meta.File = r.files[file]
+		} else if file != -1 {
+			r.fail("invalid file index %d", file)
+		}
+
+		chunk.Metadata[i] = meta
+	}
+
+	if r.err == nil && len(chunk.Metadata) != len(chunk.Code) {
+		r.fail("the chunk has %d bytes of code, but %d positions", len(chunk.Code), len(chunk.Metadata))
+	}
+
+	// They take no space in the file, but each one belongs to an instruction.
+	caches := int(r.u32())
+
+	if caches > len(chunk.Code) {
+		r.fail("invalid amount of caches %d", caches)
+		caches = 0
+	}
+
+	chunk.Caches = make([]value.PropertyCache, caches)
+
+	return chunk
+}
+
+func (r *reader) constant() value.Value {
+	switch kind := r.byte(); kind {
+		case constNumber:
+			return value.ValueNumber{ Value: math.Float64frombits(r.u64()) }
+
+		case constString:
+			return value.ValueString{ Value: r.string() }
+
+		case constFunction: {
+			fn := value.ValueFunction{ Arity: int(r.u32()) }
+
+			if r.byte() == 1 {
+				name := r.string()
+				fn.Name = &name
+			}
+
+			fn.Chunk = r.chunk()
+			return fn
+		}
+
+		case constRecord: {
+			name := r.string()
+			fieldNames := make([]string, r.count())
+
+			for i := range fieldNames {
+				fieldNames[i] = r.string()
+			}
+
+			return value.NewRecord(name, fieldNames)
+		}
+
+		default: {
+			r.fail("unknown constant kind %d", kind)
+			return value.ValueNil{}
+		}
+	}
+}
+
+func (r *reader) fail(format string, args ...any) {
+	if r.err == nil {
+		r.err = fmt.Errorf("corrupted bytecode at byte %d: %s", r.pos, fmt.Sprintf(format, args...))
+	}
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return []byte{}
+	}
+
+	if n < 0 || r.pos + n > len(r.data) {
+		r.fail("unexpected end of the file")
+		return []byte{}
+	}
+
+	b := r.data[r.pos:r.pos + n]
+	r.pos += n
+
+	return b
+}
+
+func (r *reader) byte() byte {
+	b := r.bytes(1)
+
+	if len(b) == 0 {
+		return 0
+	}
+
+	return b[0]
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+
+	if len(b) < 2 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (r *reader) u32() uint32 {
+	b := r.bytes(4)
+
+	if len(b) < 4 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *reader) i32() int32 {
+	return int32(r.u32())
+}
+
+func (r *reader) u64() uint64 {
+	b := r.bytes(8)
+
+	if len(b) < 8 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(b)
+}
+
+// A length or an amount of elements, which can't be more than the bytes left, to not allocate huge slices.
+func (r *reader) count() int {
+	n := int(r.u32())
+
+	if n > len(r.data) - r.pos {
+		r.fail("invalid length %d", n)
+		return 0
+	}
+
+	return n
+}
+
+func (r *reader) string() string {
+	return string(r.bytes(r.count()))
+}
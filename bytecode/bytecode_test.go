@@ -0,0 +1,131 @@
+package bytecode
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"vm-go/checker"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+// Every program of 'tests/' that compiles must load back to the same chunk.
+func TestRoundTrip(t *testing.T) {
+	files, _ := filepath.Glob("../tests/*.vm")
+	streams := util.NewStreams(io.Discard, io.Discard, strings.NewReader(""))
+
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fileData := util.FileData{ Name: filepath.Base(file), Path: file, Lines: strings.Split(string(source), "\n") }
+		registry := vm.DefaultRegistry(streams)
+		chunk, ok := compile(string(source), &fileData, registry, streams)
+
+		if !ok {
+			continue
+		}
+
+		buffer := bytes.Buffer{}
+
+		if err := Write(&buffer, chunk, &fileData, registry); err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+
+		loaded, loadedFile, err := Read(buffer.Bytes(), registry)
+
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+
+		if loadedFile.Name != fileData.Name || !reflect.DeepEqual(loadedFile.Lines, fileData.Lines) {
+			t.Errorf("%s: the source wasn't loaded back", file)
+		}
+
+		compareChunks(t, file, chunk, loaded)
+	}
+}
+
+func TestInvalidFiles(t *testing.T) {
+	registry := vm.DefaultRegistry(util.DefaultStreams())
+
+	if _, _, err := Read([]byte("fn main() {}"), registry); err != ErrNotBytecode {
+		t.Errorf("expected ErrNotBytecode, got %v", err)
+	}
+
+	if _, _, err := Read(append(append([]byte{}, magic...), 1, 0, 255, 255), registry); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
+
+func compareChunks(t *testing.T, file string, expected, got value.Chunk) {
+	if !bytes.Equal(expected.Code, got.Code) {
+		t.Errorf("%s: the code differs", file)
+	}
+
+	if expected.Globals != got.Globals {
+		t.Errorf("%s: expected %d globals, got %d", file, expected.Globals, got.Globals)
+	}
+
+	if len(expected.Caches) != len(got.Caches) {
+		t.Errorf("%s: expected %d caches, got %d", file, len(expected.Caches), len(got.Caches))
+	}
+
+	if len(expected.Metadata) != len(got.Metadata) {
+		t.Fatalf("%s: expected %d positions, got %d", file, len(expected.Metadata), len(got.Metadata))
+	}
+
+	for i := range expected.Metadata {
+		e, g := expected.Metadata[i], got.Metadata[i]
+
+		if e.Position != g.Position || e.Length != g.Length || (e.File == nil) != (g.File == nil) {
+			t.Fatalf("%s: position %d differs: expected %+v, got %+v", file, i, e, g)
+		}
+	}
+
+	if len(expected.Constants) != len(got.Constants) {
+		t.Fatalf("%s: expected %d constants, got %d", file, len(expected.Constants), len(got.Constants))
+	}
+
+	for i := range expected.Constants {
+		if fn, ok := expected.Constants[i].(value.ValueFunction); ok {
+			gotFn := got.Constants[i].(value.ValueFunction)
+
+			if fn.Arity != gotFn.Arity || !reflect.DeepEqual(fn.Name, gotFn.Name) {
+				t.Errorf("%s: function %d differs", file, i)
+			}
+
+			compareChunks(t, file, fn.Chunk, gotFn.Chunk)
+		} else if !reflect.DeepEqual(expected.Constants[i], got.Constants[i]) {
+			t.Errorf("%s: constant %d differs: expected %v, got %v", file, i, expected.Constants[i], got.Constants[i])
+		}
+	}
+}
+
+func compile(source string, fileData *util.FileData, registry *value.Registry, streams *util.Streams) (value.Chunk, bool) {
+	tokens, hadError := lexer.NewLexer(source, fileData, streams).Lex()
+
+	if hadError {
+		return value.Chunk{}, false
+	}
+
+	ast, hadError := parser.NewParser(tokens, fileData, streams).Parse()
+
+	if hadError || checker.NewChecker(ast, fileData, registry, streams).Check() {
+		return value.Chunk{}, false
+	}
+
+	chunk, hadError := compiler.NewCompiler(ast, fileData, registry, streams).Compile()
+	return chunk, !hadError
+}
@@ -0,0 +1,294 @@
+package checker
+
+import (
+	"fmt"
+	"vm-go/ast"
+	"vm-go/token"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The checker runs between the parser and the compiler, and reports the type mismatches
+// that can be found from the annotations, before the program runs.
+type Checker struct {
+	ast []ast.Statement
+
+	// The variables of each scope, the first one is the global scope.
+	scopes []map[string]Type
+	records map[string]*RecordType
+
+	// The names that are only known by another module, so their types can't be checked.
+	imported map[string]bool
+
+	// The return type of each enclosing function, and the record of the enclosing method, if any.
+	returnTypes []Type
+	self *RecordType
+
+	hadError bool
+	fileData *util.FileData
+	diagnostics *util.Diagnostics
+}
+
+func NewChecker(ast []ast.Statement, fileData *util.FileData, registry *value.Registry, streams *util.Streams) *Checker {
+	return &Checker{
+		ast: ast,
+
+		scopes: []map[string]Type{ builtins(registry) },
+		records: map[string]*RecordType{},
+		imported: map[string]bool{},
+
+		returnTypes: []Type{},
+		self: nil,
+
+		hadError: false,
+		fileData: fileData,
+		diagnostics: util.NewDiagnostics(streams.Stderr, 0),
+	}
+}
+
+// The errors are counted with the ones of the other stages of the compilation.
+func (c *Checker) SetDiagnostics(diagnostics *util.Diagnostics) {
+	c.diagnostics = diagnostics
+}
+
+func (c *Checker) Check() bool {
+	c.declareRecords()
+	c.hoistTopLevel()
+
+	for _, stmt := range c.ast {
+		c.statement(stmt)
+	}
+
+	return c.hadError
+}
+
+// Checks an input of the REPL, the globals and records of the previous inputs are still declared.
+// Its statements run inside a function, so they can return anything.
+func (c *Checker) CheckInput(stmts []ast.Statement, fileData *util.FileData) bool {
+	c.ast = stmts
+	c.fileData = fileData
+	c.hadError = false
+	c.returnTypes = []Type{ anyType }
+
+	return c.Check()
+}
+
+// ---
+
+// The signatures of the default native functions.
+var nativeSignatures = map[string]FnType{
+	"print": { Params: []Type{ anyType }, Return: voidType },
+	"println": { Params: []Type{ anyType }, Return: voidType },
+	"input": { Params: []Type{ strType }, Return: strType },
+	"time": { Params: []Type{}, Return: numType },
+	"readFile": { Params: []Type{ strType }, Return: strType },
+	"writeFile": { Params: []Type{ strType, strType }, Return: voidType },
+	"env": { Params: []Type{ strType }, Return: anyType },
+	"random": { Params: []Type{}, Return: numType },
+	"str": { Params: []Type{ anyType }, Return: strType },
+	"num": { Params: []Type{ strType }, Return: numType },
+	"type": { Params: []Type{ anyType }, Return: strType },
+}
+
+// The globals of the registry, the natives added by the host only have their arity checked.
+func builtins(registry *value.Registry) map[string]Type {
+	types := map[string]Type{}
+
+	for i, name := range registry.Names() {
+		native, ok := registry.Values()[i].(value.ValueNativeFn)
+
+		if !ok {
+			types[name] = anyType
+		} else if signature, ok := nativeSignatures[name]; ok && len(signature.Params) == native.Arity {
+			types[name] = signature
+		} else {
+			params := make([]Type, native.Arity)
+
+			for i := range params {
+				params[i] = anyType
+			}
+
+			types[name] = FnType{ Params: params, Return: anyType }
+		}
+	}
+
+	return types
+}
+
+// Records are declared first, so they can be used in any annotation of the file,
+// and their fields are resolved afterwards, because they may refer to each other.
+func (c *Checker) declareRecords() {
+	for _, decl := range c.ast {
+		switch s := decl.Data.(type) {
+			case ast.RecordStatement:
+				c.records[s.Name.Lexeme] = &RecordType{
+					Name: s.Name.Lexeme,
+					Fields: []FieldType{},
+					Methods: map[string]FnType{},
+				}
+
+			case ast.ImportStatement:
+				for _, name := range s.Names {
+					c.imported[name.Lexeme] = true
+				}
+		}
+	}
+
+	for _, decl := range c.ast {
+		if s, ok := decl.Data.(ast.RecordStatement); ok {
+			record := c.records[s.Name.Lexeme]
+
+			for _, field := range s.Fields {
+				record.Fields = append(record.Fields, FieldType{
+					Name: field.Name.Lexeme,
+					Type: c.resolveType(field.Type),
+				})
+			}
+
+			for _, method := range s.Methods {
+				record.Methods[method.Name.Lexeme] = c.fnType(method.Parameters, method.ReturnType)
+			}
+		}
+	}
+}
+
+// Like the compiler, globals can be used before their declaration, inside functions.
+func (c *Checker) hoistTopLevel() {
+	for _, decl := range c.ast {
+		switch s := decl.Data.(type) {
+			case ast.VarStatement:
+				c.declare(s.Name.Lexeme, c.resolveType(s.Type))
+
+			case ast.FnStatement:
+				c.declare(s.Name.Lexeme, c.fnType(s.Parameters, s.ReturnType))
+
+			case ast.RecordStatement:
+				c.declare(s.Name.Lexeme, c.constructorType(c.records[s.Name.Lexeme]))
+
+			case ast.ImportStatement:
+				// Modules and imported names aren't checked.
+				for _, name := range s.Names {
+					c.declare(name.Lexeme, anyType)
+				}
+		}
+	}
+}
+
+// Calling a record creates an instance, with the fields as the arguments.
+func (c *Checker) constructorType(record *RecordType) FnType {
+	params := []Type{}
+
+	for _, field := range record.Fields {
+		params = append(params, field.Type)
+	}
+
+	return FnType{
+		Params: params,
+		Return: record,
+	}
+}
+
+func (c *Checker) fnType(parameters []ast.Parameter, returnType *ast.TypeAnnotation) FnType {
+	params := []Type{}
+
+	for _, param := range parameters {
+		params = append(params, c.resolveType(param.Type))
+	}
+
+	return FnType{
+		Params: params,
+		Return: c.resolveType(returnType),
+	}
+}
+
+// Missing annotations resolve to 'any'.
+func (c *Checker) resolveType(annotation *ast.TypeAnnotation) Type {
+	if annotation == nil {
+		return anyType
+	}
+
+	var t Type
+
+	if annotation.IsFn {
+		params := []Type{}
+
+		for _, param := range annotation.Params {
+			params = append(params, c.resolveType(&param))
+		}
+
+		var return_ Type = voidType
+
+		if annotation.Return != nil {
+			return_ = c.resolveType(annotation.Return)
+		}
+
+		t = FnType{
+			Params: params,
+			Return: return_,
+		}
+	} else if basic, ok := basicTypes[annotation.Name.Lexeme]; ok {
+		t = basic
+	} else if record, ok := c.records[annotation.Name.Lexeme]; ok {
+		t = record
+	} else if c.imported[annotation.Name.Lexeme] {
+		t = anyType
+	} else {
+		c.error(annotation.Name.Pos, len(annotation.Name.Lexeme), fmt.Sprintf("Unknown type '%s'.", annotation.Name.Lexeme))
+		t = anyType
+	}
+
+	if annotation.Nullable && !isAny(t) {
+		return NullableType{ Inner: t }
+	}
+
+	return t
+}
+
+// ---
+
+func (c *Checker) beginScope() {
+	c.scopes = append(c.scopes, map[string]Type{})
+}
+
+func (c *Checker) endScope() {
+	c.scopes = c.scopes[:len(c.scopes) - 1]
+}
+
+func (c *Checker) declare(name string, t Type) {
+	c.scopes[len(c.scopes) - 1][name] = t
+}
+
+// Unknown variables are reported by the compiler, so they're just 'any' here.
+func (c *Checker) lookup(name string) Type {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			if narrowed, ok := t.(narrowedType); ok {
+				return narrowed.Type
+			}
+
+			return t
+		}
+	}
+
+	return anyType
+}
+
+// The type the variable was declared with, which is what assignments must respect.
+func (c *Checker) declaredType(name string) Type {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			if narrowed, ok := t.(narrowedType); ok {
+				return narrowed.declared
+			}
+
+			return t
+		}
+	}
+
+	return anyType
+}
+
+func (c *Checker) error(pos token.Position, length int, message string) {
+	c.diagnostics.Error(pos, length, message, c.fileData)
+	c.hadError = true
+}
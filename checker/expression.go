@@ -0,0 +1,268 @@
+package checker
+
+import (
+	"fmt"
+	"vm-go/ast"
+	"vm-go/token"
+)
+
+// Returns the type of the expression, 'any' if it can't be known.
+func (c *Checker) expression(expr ast.Expression) Type {
+	switch e := expr.Data.(type) {
+		case ast.NumberExpression:
+			return numType
+
+		case ast.StringExpression:
+			return strType
+
+		case ast.BoolExpression:
+			return boolType
+
+		case ast.NilExpression:
+			return nilType
+
+		case ast.VoidExpression: {
+			if e.Expr != nil {
+				c.expression(*e.Expr)
+			}
+
+			return voidType
+		}
+
+		case ast.InterpolationExpression: {
+			for _, part := range e.Parts {
+				c.expression(part)
+			}
+
+			return strType
+		}
+
+		case ast.ListExpression: {
+			for _, element := range e.Elements {
+				c.expression(element)
+			}
+
+			return basicTypes["list"]
+		}
+
+		case ast.MapExpression: {
+			for i := range e.Keys {
+				c.expression(e.Keys[i])
+				c.expression(e.Values[i])
+			}
+
+			return basicTypes["map"]
+		}
+
+		case ast.RangeExpression: {
+			c.expression(e.Start)
+			c.expression(e.End)
+
+			if e.Step != nil {
+				c.expression(*e.Step)
+			}
+
+			return basicTypes["range"]
+		}
+
+		case ast.GroupExpression:
+			return c.expression(e.Expr)
+
+		case ast.IdentifierExpression:
+			return c.lookup(e.Token.Lexeme)
+
+		case ast.SelfExpression: {
+			if c.self == nil {
+				return anyType
+			}
+
+			return c.self
+		}
+
+		case ast.UnaryExpression: {
+			c.expression(e.Operand)
+
+			if e.Operator.Kind == token.TokenNotKw {
+				return boolType
+			}
+
+			return numType
+		}
+
+		case ast.BinaryExpression:
+			return c.binary(e.Left, e.Right, e.Operator)
+
+		case ast.LogicalExpression:
+			return c.binary(e.Left, e.Right, e.Operator)
+
+		case ast.IfExpression: {
+			c.expression(e.Condition)
+
+			then := c.expression(e.Then)
+			else_ := c.expression(e.Else)
+
+			if isAssignable(then, else_) && isAssignable(else_, then) && !isAny(then) {
+				return then
+			}
+
+			return anyType
+		}
+
+		case ast.FnExpression: {
+			type_ := c.fnType(e.Parameters, nil)
+			c.function(e.Parameters, type_, e.Body)
+
+			return type_
+		}
+
+		case ast.CallExpression:
+			return c.call(expr, e)
+
+		case ast.GetPropertyExpression:
+			return c.property(c.expression(e.Left), e.Property)
+
+		case ast.SetPropertyExpression: {
+			expected := c.property(c.expression(e.Left), e.Property)
+			got := c.expression(e.Value)
+
+			if !isAssignable(expected, got) {
+				c.error(e.Value.Base.Pos, e.Value.Base.Length, fmt.Sprintf("Cannot assign a value of type '%s' to '%s', of type '%s'.", got, e.Property.Lexeme, expected))
+			}
+
+			return got
+		}
+
+		case ast.IndexExpression: {
+			c.expression(e.Left)
+			c.expression(e.Index)
+
+			return anyType
+		}
+
+		case ast.SetIndexExpression: {
+			c.expression(e.Left)
+			c.expression(e.Index)
+
+			return c.expression(e.Value)
+		}
+
+		case ast.IdentifierAssignmentExpression: {
+			expected := c.declaredType(e.Name.Lexeme)
+			got := c.expression(e.Expr)
+
+			if !isAssignable(expected, got) {
+				c.error(e.Expr.Base.Pos, e.Expr.Base.Length, fmt.Sprintf("Cannot assign a value of type '%s' to '%s', of type '%s'.", got, e.Name.Lexeme, expected))
+			}
+
+			c.assign(e.Name.Lexeme, got)
+			return got
+		}
+
+		default:
+			return anyType
+	}
+}
+
+// Operators are checked at runtime, like in unannotated programs, the checker only infers their result.
+// Only the nullable operands are reported, as they're known to fail when they're nil.
+func (c *Checker) binary(leftExpr, rightExpr ast.Expression, operator token.Token) Type {
+	left := c.expression(leftExpr)
+	right := c.expression(rightExpr)
+
+	switch operator.Kind {
+		case token.TokenPlus, token.TokenMinus, token.TokenStar, token.TokenSlash, token.TokenPercent,
+			token.TokenGreater, token.TokenGreaterEqual, token.TokenLess, token.TokenLessEqual:
+			c.checkNotNullable(leftExpr, left, operator)
+			c.checkNotNullable(rightExpr, right, operator)
+	}
+
+	switch operator.Kind {
+		// Adds numbers or concatenates strings.
+		case token.TokenPlus: {
+			if (is(left, numType) || is(left, strType)) && left == right {
+				return left
+			}
+
+			return anyType
+		}
+
+		case token.TokenMinus, token.TokenStar, token.TokenSlash, token.TokenPercent:
+			return numType
+
+		case token.TokenGreater, token.TokenGreaterEqual, token.TokenLess, token.TokenLessEqual,
+			token.TokenAndKw, token.TokenOrKw, token.TokenDoubleEqual, token.TokenBangEqual:
+			return boolType
+
+		default:
+			return anyType
+	}
+}
+
+func (c *Checker) checkNotNullable(expr ast.Expression, t Type, operator token.Token) {
+	if _, ok := t.(NullableType); ok {
+		c.error(expr.Base.Pos, expr.Base.Length, fmt.Sprintf("Cannot use '%s' on a value of type '%s', because it may be nil.", operator.Lexeme, t))
+	}
+}
+
+func (c *Checker) call(expr ast.Expression, e ast.CallExpression) Type {
+	callee := c.expression(e.Callee)
+	arguments := []Type{}
+
+	for _, arg := range e.Arguments {
+		arguments = append(arguments, c.expression(arg))
+	}
+
+	if isAny(callee) {
+		return anyType
+	}
+
+	fn, ok := callee.(FnType)
+
+	if !ok {
+		c.error(expr.Base.Pos, expr.Base.Length, fmt.Sprintf("Cannot call a value of type '%s'.", callee))
+		return anyType
+	}
+
+	if len(fn.Params) != len(arguments) {
+		c.error(expr.Base.Pos, expr.Base.Length, fmt.Sprintf("Expected %d argument(s), but got %d.", len(fn.Params), len(arguments)))
+		return fn.Return
+	}
+
+	for i, arg := range e.Arguments {
+		if !isAssignable(fn.Params[i], arguments[i]) {
+			c.error(arg.Base.Pos, arg.Base.Length, fmt.Sprintf("Argument %d must be of type '%s', but got '%s'.", i + 1, fn.Params[i], arguments[i]))
+		}
+	}
+
+	return fn.Return
+}
+
+// Only the properties of records are known.
+func (c *Checker) property(left Type, property token.Token) Type {
+	if nullable, ok := left.(NullableType); ok {
+		if _, ok := nullable.Inner.(*RecordType); ok {
+			c.error(property.Pos, len(property.Lexeme), fmt.Sprintf("Cannot access '%s', because the value of type '%s' may be nil.", property.Lexeme, left))
+		}
+
+		return anyType
+	}
+
+	record, ok := left.(*RecordType)
+
+	if !ok {
+		return anyType
+	}
+
+	for _, field := range record.Fields {
+		if field.Name == property.Lexeme {
+			return field.Type
+		}
+	}
+
+	if method, ok := record.Methods[property.Lexeme]; ok {
+		return method
+	}
+
+	c.error(property.Pos, len(property.Lexeme), fmt.Sprintf("'%s' has no property named '%s'.", record.Name, property.Lexeme))
+	return anyType
+}
@@ -0,0 +1,412 @@
+package checker
+
+import (
+	"fmt"
+	"vm-go/ast"
+	"vm-go/token"
+)
+
+func (c *Checker) statement(stmt ast.Statement) {
+	switch s := stmt.Data.(type) {
+		case ast.RecordStatement: {
+			record := c.records[s.Name.Lexeme]
+
+			// Records declared in inner scopes aren't hoisted.
+			if record == nil {
+				record = &RecordType{
+					Name: s.Name.Lexeme,
+					Fields: []FieldType{},
+					Methods: map[string]FnType{},
+				}
+
+				c.records[s.Name.Lexeme] = record
+
+				for _, field := range s.Fields {
+					record.Fields = append(record.Fields, FieldType{
+						Name: field.Name.Lexeme,
+						Type: c.resolveType(field.Type),
+					})
+				}
+
+				for _, method := range s.Methods {
+					record.Methods[method.Name.Lexeme] = c.fnType(method.Parameters, method.ReturnType)
+				}
+
+				c.declare(s.Name.Lexeme, c.constructorType(record))
+			}
+
+			enclosingSelf := c.self
+			c.self = record
+
+			for _, method := range s.Methods {
+				c.function(method.Parameters, record.Methods[method.Name.Lexeme], method.Body)
+				c.checkReturns(method.Name, record.Methods[method.Name.Lexeme], method.Body)
+			}
+
+			c.self = enclosingSelf
+		}
+
+		case ast.FnStatement: {
+			type_ := c.fnType(s.Parameters, s.ReturnType)
+
+			// Declared before the body, so it can call itself.
+			c.declare(s.Name.Lexeme, type_)
+			c.function(s.Parameters, type_, s.Body)
+			c.checkReturns(s.Name, type_, s.Body)
+		}
+
+		case ast.VarStatement: {
+			initType := c.expression(s.Init)
+			type_ := c.resolveType(s.Type)
+
+			if !isAssignable(type_, initType) {
+				c.error(s.Init.Base.Pos, s.Init.Base.Length, fmt.Sprintf("Cannot initialize '%s', of type '%s', with a value of type '%s'.", s.Name.Lexeme, type_, initType))
+			}
+
+			c.declare(s.Name.Lexeme, type_)
+		}
+
+		case ast.IfStatement: {
+			c.expression(s.Condition)
+
+			c.beginScope()
+			c.narrow(s.Condition, true)
+			c.block(s.Then)
+			then := branch{ narrowed: c.narrowings(len(c.scopes) - 1), returns: alwaysReturns(s.Then) }
+			c.endScope()
+
+			c.beginScope()
+			c.narrow(s.Condition, false)
+			else_ := branch{ narrowed: c.narrowings(len(c.scopes) - 1) }
+
+			if s.Else != nil {
+				c.block(*s.Else)
+				else_ = branch{ narrowed: c.narrowings(len(c.scopes) - 1), returns: alwaysReturns(*s.Else) }
+			}
+
+			c.endScope()
+			c.join(then, else_)
+		}
+
+		case ast.WhileStatement: {
+			c.expression(s.Condition)
+
+			c.beginScope()
+			c.block(s.Block)
+			c.endScope()
+		}
+
+		case ast.ForStatement: {
+			iterable := c.expression(s.Iterable)
+
+			c.beginScope()
+
+			// Ranges are the only iterables whose elements are known.
+			if is(iterable, basicTypes["range"]) {
+				c.declare(s.Variable.Lexeme, numType)
+			} else if is(iterable, strType) {
+				c.declare(s.Variable.Lexeme, strType)
+			} else {
+				c.declare(s.Variable.Lexeme, anyType)
+			}
+
+			c.block(s.Block)
+			c.endScope()
+		}
+
+		case ast.ForVarStatement: {
+			c.beginScope()
+			c.statement(s.Declaration)
+			c.expression(s.Condition)
+
+			if s.Increment != nil {
+				c.expression(*s.Increment)
+			}
+
+			c.beginScope()
+			c.block(s.Block)
+			c.endScope()
+
+			c.endScope()
+		}
+
+		case ast.LoopStatement: {
+			c.beginScope()
+			c.block(s.Block)
+			c.endScope()
+		}
+
+		case ast.ReturnStatement: {
+			expected := c.returnTypes[len(c.returnTypes) - 1]
+
+			if s.Expression == nil {
+				if !isAssignable(expected, voidType) {
+					c.error(stmt.Base.Pos, stmt.Base.Length, fmt.Sprintf("Expected a return value of type '%s'.", expected))
+				}
+
+				return
+			}
+
+			got := c.expression(*s.Expression)
+
+			// Functions that return void can still return 'void' expressions, like calls to other void functions.
+			if !isAssignable(expected, got) {
+				c.error(s.Expression.Base.Pos, s.Expression.Base.Length, fmt.Sprintf("Expected a return value of type '%s', but got '%s'.", expected, got))
+			}
+		}
+
+		case ast.BlockStatement: {
+			c.beginScope()
+			c.block(s)
+			c.endScope()
+		}
+
+		case ast.TryStatement: {
+			c.beginScope()
+			c.block(s.Body)
+			c.endScope()
+
+			c.beginScope()
+
+			if !s.Variable.IsAbsent() {
+				c.declare(s.Variable.Lexeme, basicTypes["error"])
+			}
+
+			c.block(s.Catch)
+			c.endScope()
+		}
+
+		case ast.ExprStatement:
+			c.expression(s.Expr)
+
+		// Nothing to check.
+		case ast.BreakStatement, ast.ContinueStatement, ast.ImportStatement:
+	}
+}
+
+func (c *Checker) block(block ast.BlockStatement) {
+	for _, stmt := range block.Stmts {
+		c.statement(stmt)
+	}
+}
+
+func (c *Checker) function(parameters []ast.Parameter, type_ FnType, body ast.BlockStatement) {
+	c.beginScope()
+
+	if c.self != nil {
+		c.declare("self", c.self)
+	}
+
+	for i, param := range parameters {
+		c.declare(param.Name.Lexeme, type_.Params[i])
+	}
+
+	c.returnTypes = append(c.returnTypes, type_.Return)
+	c.block(body)
+	c.returnTypes = c.returnTypes[:len(c.returnTypes) - 1]
+
+	c.endScope()
+}
+
+// A function that must return a value can't reach the end of its body, where it would return nil.
+func (c *Checker) checkReturns(name token.Token, type_ FnType, body ast.BlockStatement) {
+	if isAssignable(type_.Return, voidType) || alwaysReturns(body) {
+		return
+	}
+
+	c.error(name.Pos, len(name.Lexeme), fmt.Sprintf("'%s' must return a value of type '%s', but it can reach the end of its body.", name.Lexeme, type_.Return))
+}
+
+// Whether the block can't reach its end: it returns on every path, or loops forever.
+func alwaysReturns(block ast.BlockStatement) bool {
+	for _, stmt := range block.Stmts {
+		switch s := stmt.Data.(type) {
+			case ast.ReturnStatement:
+				return true
+
+			case ast.BlockStatement:
+				if alwaysReturns(s) {
+					return true
+				}
+
+			case ast.IfStatement:
+				if s.Else != nil && alwaysReturns(s.Then) && alwaysReturns(*s.Else) {
+					return true
+				}
+
+			case ast.TryStatement:
+				if alwaysReturns(s.Body) && alwaysReturns(s.Catch) {
+					return true
+				}
+
+			case ast.LoopStatement:
+				if !breaks(s.Block) {
+					return true
+				}
+		}
+	}
+
+	return false
+}
+
+// Whether the block has a 'break' out of the loop it's the body of, the nested loops have their own.
+func breaks(block ast.BlockStatement) bool {
+	for _, stmt := range block.Stmts {
+		switch s := stmt.Data.(type) {
+			case ast.BreakStatement:
+				return true
+
+			case ast.BlockStatement:
+				if breaks(s) {
+					return true
+				}
+
+			case ast.IfStatement:
+				if breaks(s.Then) || (s.Else != nil && breaks(*s.Else)) {
+					return true
+				}
+
+			case ast.TryStatement:
+				if breaks(s.Body) || breaks(s.Catch) {
+					return true
+				}
+		}
+	}
+
+	return false
+}
+
+// Inside 'if x != nil { ... }', and in the 'else' of 'if x == nil { ... }', a nullable 'x' is known not to be nil.
+// 'holds' is whether the condition is true in the branch.
+func (c *Checker) narrow(condition ast.Expression, holds bool) {
+	binary, ok := condition.Data.(ast.BinaryExpression)
+
+	if !ok || (binary.Operator.Lexeme != "!=" || !holds) && (binary.Operator.Lexeme != "==" || holds) {
+		return
+	}
+
+	variable := binary.Left
+
+	if _, ok := binary.Left.Data.(ast.NilExpression); ok {
+		variable = binary.Right
+	} else if _, ok := binary.Right.Data.(ast.NilExpression); !ok {
+		return
+	}
+
+	ident, ok := variable.Data.(ast.IdentifierExpression)
+
+	if !ok {
+		return
+	}
+
+	if nullable, ok := c.lookup(ident.Token.Lexeme).(NullableType); ok {
+		c.declare(ident.Token.Lexeme, narrowedType{ Type: nullable.Inner, declared: nullable, scope: c.declaredIn(ident.Token.Lexeme) })
+	}
+}
+
+// Assigning a value that isn't nil narrows the variable, and any other value gives it back its declared type.
+// Either way only until the end of the scope, where 'join' decides what it is after the 'if' the scope is in.
+func (c *Checker) assign(name string, t Type) {
+	declared := c.declaredType(name)
+	nullable, ok := declared.(NullableType)
+
+	if !ok {
+		return
+	}
+
+	if _, isNullable := t.(NullableType); isNullable || isAny(t) || is(t, nilType) {
+		c.declare(name, declared)
+		return
+	}
+
+	c.declare(name, narrowedType{ Type: nullable.Inner, declared: nullable, scope: c.declaredIn(name) })
+}
+
+// The scope the variable is declared in, the narrowings of the inner scopes don't count.
+func (c *Checker) declaredIn(name string) int {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if t, ok := c.scopes[i][name]; ok {
+			if narrowed, ok := t.(narrowedType); ok {
+				return narrowed.scope
+			}
+
+			return i
+		}
+	}
+
+	return 0
+}
+
+// The variables narrowed at the end of a branch of an 'if', and whether the code after the 'if' can come from it.
+type branch struct {
+	narrowed map[string]narrowedType
+	returns  bool
+}
+
+// The variables known not to be nil where the checker is, only the ones declared before the scope 'outside',
+// as the others don't exist after it.
+func (c *Checker) narrowings(outside int) map[string]narrowedType {
+	narrowed := map[string]narrowedType{}
+	seen := map[string]bool{}
+
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		for name, t := range c.scopes[i] {
+			if seen[name] {
+				continue
+			}
+
+			seen[name] = true
+
+			if n, ok := t.(narrowedType); ok && n.scope < outside {
+				narrowed[name] = n
+			}
+		}
+	}
+
+	return narrowed
+}
+
+// After an 'if', a variable is known not to be nil if it's so at the end of every branch that gets there.
+func (c *Checker) join(branches ...branch) {
+	before := c.narrowings(len(c.scopes))
+	names := map[string]narrowedType{}
+
+	for name, n := range before {
+		names[name] = n
+	}
+
+	reaching := []branch{}
+
+	for _, b := range branches {
+		if b.returns {
+			continue
+		}
+
+		reaching = append(reaching, b)
+
+		for name, n := range b.narrowed {
+			names[name] = n
+		}
+	}
+
+	// The code after the 'if' never runs.
+	if len(reaching) == 0 {
+		return
+	}
+
+	for name, n := range names {
+		narrowed := true
+
+		for _, b := range reaching {
+			if _, ok := b.narrowed[name]; !ok {
+				narrowed = false
+			}
+		}
+
+		if _, wasNarrowed := before[name]; narrowed && !wasNarrowed {
+			c.declare(name, n)
+		} else if !narrowed && wasNarrowed {
+			c.declare(name, n.declared)
+		}
+	}
+}
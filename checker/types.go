@@ -0,0 +1,144 @@
+package checker
+
+import "strings"
+
+// Unannotated values have the type 'any', which is compatible with every type,
+// so the checker only reports the mismatches it can prove.
+type Type interface {
+	String() string
+}
+
+type AnyType struct {}
+
+// num, str, bool, nil, void, list, map, range and error.
+type BasicType struct {
+	Name string
+}
+
+type RecordType struct {
+	Name string
+	Fields []FieldType
+	Methods map[string]FnType
+}
+
+type FieldType struct {
+	Name string
+	Type Type
+}
+
+type FnType struct {
+	Params []Type
+	Return Type
+}
+
+type NullableType struct {
+	Inner Type
+}
+
+// A nullable variable known not to be nil, inside 'if x != nil { ... }'. 'scope' is the one it's declared in.
+type narrowedType struct {
+	Type
+	declared Type
+	scope    int
+}
+
+var (
+	anyType = AnyType{}
+	numType = BasicType{ Name: "num" }
+	strType = BasicType{ Name: "str" }
+	boolType = BasicType{ Name: "bool" }
+	nilType = BasicType{ Name: "nil" }
+	voidType = BasicType{ Name: "void" }
+)
+
+var basicTypes = map[string]Type{
+	"any": anyType,
+	"num": numType,
+	"str": strType,
+	"bool": boolType,
+	"nil": nilType,
+	"void": voidType,
+	"list": BasicType{ Name: "list" },
+	"map": BasicType{ Name: "map" },
+	"range": BasicType{ Name: "range" },
+	"error": BasicType{ Name: "error" },
+}
+
+// ---
+
+func (x AnyType) String() string { return "any" }
+func (x BasicType) String() string { return x.Name }
+func (x *RecordType) String() string { return x.Name }
+func (x NullableType) String() string { return x.Inner.String() + "?" }
+
+func (x FnType) String() string {
+	params := []string{}
+
+	for _, param := range x.Params {
+		params = append(params, param.String())
+	}
+
+	if isVoid(x.Return) {
+		return "fn(" + strings.Join(params, ", ") + ")"
+	}
+
+	return "fn(" + strings.Join(params, ", ") + ") -> " + x.Return.String()
+}
+
+// ---
+
+// Reports whether a value of type 'from' can be stored where 'to' is expected.
+func isAssignable(to, from Type) bool {
+	if isAny(to) || isAny(from) {
+		return true
+	}
+
+	if nullable, ok := to.(NullableType); ok {
+		if from == nilType {
+			return true
+		}
+
+		if fromNullable, ok := from.(NullableType); ok {
+			return isAssignable(nullable.Inner, fromNullable.Inner)
+		}
+
+		return isAssignable(nullable.Inner, from)
+	}
+
+	switch t := to.(type) {
+		case FnType: {
+			f, ok := from.(FnType)
+
+			if !ok || len(f.Params) != len(t.Params) {
+				return false
+			}
+
+			// The parameters go the other way: the function receives what the caller passes to 't'.
+			for i := range t.Params {
+				if !isAssignable(f.Params[i], t.Params[i]) {
+					return false
+				}
+			}
+
+			return isVoid(t.Return) || isAssignable(t.Return, f.Return)
+		}
+
+		default:
+			// Basic types are compared by value, and records by pointer.
+			return to == from
+	}
+}
+
+func isAny(t Type) bool {
+	_, ok := t.(AnyType)
+	return ok
+}
+
+func isVoid(t Type) bool {
+	return t == voidType
+}
+
+// Reports whether the type is known to be 'expected', so 'any' isn't.
+func is(t Type, expected Type) bool {
+	return t == expected
+}
@@ -1,6 +1,8 @@
 package compiler
 
 import (
+	"fmt"
+	"path/filepath"
 	"vm-go/ast"
 	"vm-go/token"
 	"vm-go/util"
@@ -15,6 +17,7 @@ const (
 	OP_APPEND_METHODS
 
 	OP_ADD
+	OP_CONCAT
 	OP_SUB
 	OP_MUL
 	OP_DIV
@@ -34,6 +37,9 @@ const (
 	OP_GET_PROPERTY
 	OP_SET_PROPERTY
 
+	OP_GET_INDEX
+	OP_SET_INDEX
+
 	OP_POP
 	OP_POP_LOCAL
 	OP_POPN_LOCAL
@@ -65,6 +71,9 @@ const (
 	OP_CALL_PROPERTY
 	OP_RETURN
 
+	OP_PUSH_HANDLER
+	OP_POP_HANDLER
+
 	OP_PUSH_TRUE
 	OP_PUSH_FALSE
 	
@@ -74,6 +83,8 @@ const (
     OP_MAKE_RANGE
     OP_MAKE_INCL_RANGE
     OP_MAKE_ITERATOR
+    OP_MAKE_LIST
+    OP_MAKE_MAP
 
     OP_GET_NEXT
     OP_ADVANCE
@@ -91,11 +102,13 @@ type Local struct {
 type Global struct {
 	name token.Token
 	initialized bool // to check redeclaration
+	module int // the index of the module that declared it
 }
 
 type Upvalue struct {
 	index int
 	isLocal bool
+	name string
 }
 
 type Compiler struct {
@@ -109,14 +122,43 @@ type Compiler struct {
 	scopeDepth int
 	loopFlowPos []int
 
+	// The amount of enclosing 'try' blocks, and that amount when each loop started,
+	// so 'break' and 'continue' can discard the handlers of the 'try' blocks they jump out of.
+	handlerCount int
+	loopHandlerCount []int
+
 	hadError bool
 	panicMode bool
 
+	// See 'warnings.go', nil for no warnings.
+	warnings *warnings
+	warningsAsErrors bool
+
+	// See 'optimize.go'. 'lastPop' is the offset of the last instruction that popped locals, and 'lastLabel'
+	// the last offset a jump was patched to land at, so the pops aren't merged across a jump target.
+	optimize bool
+	lastPop int
+	lastLabel int
+
+	// The module being compiled, and all the modules, shared with the function compilers.
+	module int
+	modules *[]*Module
+
+	// The globals defined by the host, before the ones of the program.
+	registry *value.Registry
+
 	fileData *util.FileData
+	streams *util.Streams
+	diagnostics *util.Diagnostics
 	enclosing *Compiler
+
+	// The upvalues of the function the debugger is paused in, and the globals the program has defined,
+	// see 'CompileExpression'.
+	debugUpvalues []string
+	debugGlobals int
 }
 
-func NewCompiler(ast []ast.Statement, fileData *util.FileData) *Compiler {
+func NewCompiler(ast []ast.Statement, fileData *util.FileData, registry *value.Registry, streams *util.Streams) *Compiler {
 	return &Compiler{
 		ast: ast,
 		
@@ -128,10 +170,26 @@ func NewCompiler(ast []ast.Statement, fileData *util.FileData) *Compiler {
 		scopeDepth: 0,
 		loopFlowPos: []int{},
 
+		handlerCount: 0,
+		loopHandlerCount: []int{},
+
 		hadError: false,
 		panicMode: false,
 
+		warnings: newWarnings(),
+		warningsAsErrors: false,
+
+		optimize: true,
+		lastPop: -1,
+		lastLabel: -1,
+
+		module: 0,
+		modules: &[]*Module{},
+		registry: registry,
+
 		fileData: fileData,
+		streams: streams,
+		diagnostics: util.NewDiagnostics(streams.Stderr, 0),
 		enclosing: nil,
 	}
 }
@@ -148,33 +206,100 @@ func newFnCompiler(ast []ast.Statement, enclosing *Compiler) *Compiler {
 		scopeDepth: enclosing.scopeDepth + 1,
 		loopFlowPos: []int{},
 
+		handlerCount: 0,
+		loopHandlerCount: []int{},
+
 		hadError: false,
 		panicMode: false,
 
+		warnings: enclosing.warnings,
+		warningsAsErrors: enclosing.warningsAsErrors,
+
+		optimize: enclosing.optimize,
+		lastPop: -1,
+		lastLabel: -1,
+
+		module: enclosing.module,
+		modules: enclosing.modules,
+		registry: enclosing.registry,
+
 		fileData: enclosing.fileData,
+		streams: enclosing.streams,
+		diagnostics: enclosing.diagnostics,
 		enclosing: enclosing,
 	}
 }
 
 func (c *Compiler) Compile() (value.Chunk, bool) {
-	c.addNativeFunctions()
-	c.hoistTopLevel()
-
-	c.statements(c.ast)
+	c.compileEntry()
 	c.callMain()
+	c.reportWarnings()
+
+	return c.chunk, c.hadError
+}
+
+// Like 'Compile', but the program doesn't need a main function, and it isn't called.
+// The host calls the functions of the program after running it.
+func (c *Compiler) CompileLibrary() (value.Chunk, bool) {
+	c.compileEntry()
+	c.reportWarnings()
 
 	return c.chunk, c.hadError
 }
 
+// Returns the index of a global of the compiled file, or of the registry, or -1 if it doesn't exist.
+func (c *Compiler) GlobalIndex(name string) int {
+	index := c.findGlobal(0, name)
+
+	if index == -1 {
+		index = c.findGlobal(builtinModule, name)
+	}
+
+	return index
+}
+
+func (c *Compiler) compileEntry() {
+	c.addBuiltins()
+
+	// The file being compiled is the first module, so importing it back is a cycle.
+	path, _ := filepath.Abs(c.fileData.Path)
+
+	*c.modules = append(*c.modules, &Module{
+		path: path,
+		fileData: c.fileData,
+		imports: map[string]int{},
+		loaded: false,
+	})
+
+	c.compileModule()
+	(*c.modules)[0].loaded = true
+
+	c.chunk.Globals = len(c.globals)
+}
+
 // ---
 
+// An error only skips the rest of its statement, the next ones are compiled to find more errors.
 func (c *Compiler) statements(stmts []ast.Statement){
-	for _, stmt := range stmts {
+	for i, stmt := range stmts {
+		if c.diagnostics.TooManyErrors() {
+			return
+		}
+
 		if c.panicMode {
 			c.panicMode = false
 		}
 
 		c.statement(stmt)
+
+		if endsFlow(stmt) && i + 1 < len(stmts) {
+			c.unreachable(stmt, stmts[i + 1])
+		}
+
+		if c.optimize && endsFlow(stmt) && i + 1 < len(stmts) {
+			c.discard(func() { c.statements(stmts[i + 1:]) })
+			return
+		}
 	}
 }
 
@@ -185,78 +310,49 @@ func (c *Compiler) hoistTopLevel() {
 	for _, decl := range c.ast {
 		switch s := decl.Data.(type) {
 			case ast.VarStatement: {
-				c.globals = append(c.globals, Global{
-					name: s.Name,
-					initialized: false,
-				})
+				c.hoistGlobal(s.Name)
 			}
 			
 			case ast.FnStatement: {
-				c.globals = append(c.globals, Global{
-					name: s.Name,
-					initialized: false,
-				})
+				c.hoistGlobal(s.Name)
 			}
 
 			case ast.RecordStatement: {
-				c.globals = append(c.globals, Global{
-					name: s.Name,
-					initialized: false,
-				})
+				c.hoistGlobal(s.Name)
 			}
 		}
 	}
 }
 
-func (c *Compiler) addNativeFunctions() {
-	// they will be set to initialized to prevent shadowing in the global scope
-
-	// fn print() -> void
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "print" },
-		initialized: true,
-	})
-
-	// fn println() -> void
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "println" },
-		initialized: true,
-	})
-
-	// fn input(prompt: str) -> str
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "input" },
-		initialized: true,
-	})
-
-	// fn time() -> num
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "time" },
-		initialized: true,
-	})
-
-	// fn str(n: any) -> str
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "str" },
-		initialized: true,
-	})
+func (c *Compiler) hoistGlobal(name token.Token) {
+	if _, ok := c.currentModule().imports[name.Lexeme]; ok {
+		c.error(name.Pos, len(name.Lexeme), fmt.Sprintf("'%s' is already the name of an imported module.", name.Lexeme))
+		return
+	}
 
-	// fn num(n: str) -> num?
 	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "num" },
-		initialized: true,
+		name: name,
+		initialized: false,
+		module: c.module,
 	})
+}
 
-	// fn type(value: any) -> str
-	c.globals = append(c.globals, Global{
-		name: token.Token{ Lexeme: "type" },
-		initialized: true,
-	})
+// The globals of the registry, like the native functions, are visible from every module.
+func (c *Compiler) addBuiltins() {
+	for _, name := range c.registry.Names() {
+		// they are initialized to prevent shadowing in the global scope
+		c.globals = append(c.globals, Global{
+			name: token.Token{ Lexeme: name },
+			initialized: true,
+			module: builtinModule,
+		})
+	}
 }
 
 func (c *Compiler) callMain() {
 	for i, global := range c.globals {
-		if global.name.Lexeme == "main" {
+		// Only the main function of the file being run, not the imported ones.
+		if global.module == 0 && global.name.Lexeme == "main" {
 			// check if it's a function
 			// in the meanwhile, this error will be caught at runtime
 
@@ -278,5 +374,8 @@ func (c *Compiler) callMain() {
 		}
 	}
 
-	c.errorNoBody("A main function wasn't found.")
+	// It may be missing because of the other errors, like an import of it that failed.
+	if !c.hadError {
+		c.errorNoBody("A main function wasn't found.")
+	}
 }
@@ -0,0 +1,47 @@
+package compiler
+
+import (
+	"vm-go/ast"
+	"vm-go/token"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// What an expression of the debugger can see: the names of the slots of the function the program is paused in,
+// "" for the ones without a name, and the file of that function, whose globals are visible. Only the first
+// 'Globals' globals are defined, the program hasn't reached the declarations of the others yet.
+type Scope struct {
+	Locals   []string
+	Upvalues []string
+	Globals  int
+	File     *util.FileData
+}
+
+// Compiles an expression of the debugger, with the compiler that compiled the program. Its chunk runs inside
+// the paused function, with the same locals and upvalues, and leaves the value of the expression on the stack.
+func (c *Compiler) CompileExpression(expr ast.Expression, fileData *util.FileData, scope Scope) (value.Chunk, bool) {
+	fnCompiler := newFnCompiler([]ast.Statement{}, c)
+	fnCompiler.fileData = fileData
+	fnCompiler.debugUpvalues = append([]string{}, scope.Upvalues...)
+	fnCompiler.debugGlobals = scope.Globals
+	fnCompiler.warnings = nil
+
+	// Each expression is compiled on its own, so its errors aren't counted with the program's.
+	fnCompiler.diagnostics = util.NewDiagnostics(c.streams.Stderr, 0)
+
+	for i, module := range *c.modules {
+		if module.fileData == scope.File {
+			fnCompiler.module = i
+		}
+	}
+
+	for _, name := range scope.Locals {
+		fnCompiler.locals = append(fnCompiler.locals, Local{
+			name: token.Token{ Lexeme: name },
+			depth: fnCompiler.scopeDepth,
+		})
+	}
+
+	fnCompiler.expression(expr)
+	return fnCompiler.chunk, fnCompiler.hadError
+}
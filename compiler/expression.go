@@ -9,10 +9,14 @@ import (
 )
 
 func (c *Compiler) expression(expr ast.Expression) {
-	if c.hadError {
+	if c.panicMode {
 		return
 	}
 
+	if c.optimize {
+		expr = fold(expr)
+	}
+
 	switch e := expr.Data.(type) {
 		case ast.NumberExpression: {
 			index := c.addConstant(value.ValueNumber{ Value: e.Literal })
@@ -31,6 +35,19 @@ func (c *Compiler) expression(expr ast.Expression) {
 			c.writeBytes(util.IntToBytes(index))
 		}
 
+		// Every part is converted to a string when concatenated.
+		case ast.InterpolationExpression: {
+			for _, part := range e.Parts {
+				c.expression(part)
+			}
+
+			c.writeBytePos(OP_CONCAT, value.ChunkMetadata{
+				Position: expr.Base.Pos,
+				Length: expr.Base.Length,
+			})
+			c.writeBytes(util.IntToBytes(len(e.Parts)))
+		}
+
 		case ast.BoolExpression: {
 			if e.Literal {
 				c.writeBytePos(OP_PUSH_TRUE, value.ChunkMetadata{
@@ -45,6 +62,32 @@ func (c *Compiler) expression(expr ast.Expression) {
 			}
 		}
 
+		case ast.ListExpression: {
+			for _, element := range e.Elements {
+				c.expression(element)
+			}
+
+			c.writeBytePos(OP_MAKE_LIST, value.ChunkMetadata{
+				Position: expr.Base.Pos,
+				Length: expr.Base.Length,
+			})
+			c.writeBytes(util.IntToBytes(len(e.Elements)))
+		}
+
+		// The keys and values are interleaved on the stack.
+		case ast.MapExpression: {
+			for i := range e.Keys {
+				c.expression(e.Keys[i])
+				c.expression(e.Values[i])
+			}
+
+			c.writeBytePos(OP_MAKE_MAP, value.ChunkMetadata{
+				Position: expr.Base.Pos,
+				Length: expr.Base.Length,
+			})
+			c.writeBytes(util.IntToBytes(len(e.Keys)))
+		}
+
 		case ast.NilExpression: {
 			c.writeBytePos(OP_PUSH_NIL, value.ChunkMetadata{
 				Position: expr.Base.Pos,
@@ -53,8 +96,14 @@ func (c *Compiler) expression(expr ast.Expression) {
 		}
 
 		case ast.VoidExpression: {
-			if e.Expr != nil {
-				c.expression(*e.Expr)
+			operand := e.Expr
+
+			if c.optimize && operand != nil {
+				operand = reduceToSideEffect(*operand)
+			}
+
+			if operand != nil {
+				c.expression(*operand)
 
 				c.writeBytePos(OP_POP, value.ChunkMetadata{
 					Position: expr.Base.Pos,
@@ -128,6 +177,7 @@ func (c *Compiler) expression(expr ast.Expression) {
 				c.chunk.Metadata = append(c.chunk.Metadata, value.ChunkMetadata{
 					Position: e.Operator.Pos,
 					Length: len(e.Operator.Lexeme),
+					File: c.fileData,
 				})
 
 				switch e.Operator.Kind {
@@ -149,6 +199,7 @@ func (c *Compiler) expression(expr ast.Expression) {
 			c.chunk.Metadata = append(c.chunk.Metadata, value.ChunkMetadata{
 				Position: e.Operator.Pos,
 				Length: len(e.Operator.Lexeme),
+				File: c.fileData,
 			})
 
 			switch e.Operator.Kind {
@@ -195,6 +246,7 @@ func (c *Compiler) expression(expr ast.Expression) {
 			c.chunk.Metadata = append(c.chunk.Metadata, value.ChunkMetadata{
 				Position: e.Operator.Pos,
 				Length: len(e.Operator.Lexeme),
+				File: c.fileData,
 			})
 
 			switch e.Operator.Kind {
@@ -213,6 +265,31 @@ func (c *Compiler) expression(expr ast.Expression) {
 			switch e.Callee.Data.(type) {
 				case ast.GetPropertyExpression: {
 					callee := e.Callee.Data.(ast.GetPropertyExpression)
+
+					// Functions of imported modules are just globals.
+					if index, opcode, ok := c.resolveModuleMember(callee.Left, callee.Property, false); ok {
+						if index < 0 {
+							return
+						}
+
+						c.writeBytePos(byte(opcode), value.ChunkMetadata{
+							Position: callee.Property.Pos,
+							Length: len(callee.Property.Lexeme),
+						})
+						c.writeBytes(util.IntToBytes(index))
+
+						for _, arg := range e.Arguments {
+							c.expression(arg)
+						}
+
+						c.writeBytePos(OP_CALL, value.ChunkMetadata{
+							Position: expr.Base.Pos,
+							Length: expr.Base.Length,
+						})
+						c.writeBytes(util.IntToBytes(len(e.Arguments)))
+						return
+					}
+
 					c.expression(callee.Left)
 
 					for _, arg := range e.Arguments {
@@ -228,6 +305,7 @@ func (c *Compiler) expression(expr ast.Expression) {
 					})
 					c.writeBytes(util.IntToBytes(index))
 					c.writeBytes(util.IntToBytes(len(e.Arguments)))
+					c.writeBytes(util.IntToBytes(c.addCache()))
 				}
 
 				default: {
@@ -269,6 +347,18 @@ func (c *Compiler) expression(expr ast.Expression) {
 			c.compileFunction(e.Parameters, e.Body, nil, expr.Base.Pos)
 		
 		case ast.IfExpression: {
+			if condition, ok := c.constantCondition(e.Condition); ok {
+				if condition {
+					c.expression(e.Then)
+					c.discard(func() { c.expression(e.Else) })
+				} else {
+					c.discard(func() { c.expression(e.Then) })
+					c.expression(e.Else)
+				}
+
+				return
+			}
+
 			elseFn := func() {
 				c.expression(e.Else)
 			}
@@ -301,6 +391,19 @@ func (c *Compiler) expression(expr ast.Expression) {
         }
 
 		case ast.GetPropertyExpression: {
+			if index, opcode, ok := c.resolveModuleMember(e.Left, e.Property, false); ok {
+				if index < 0 {
+					return
+				}
+
+				c.writeBytePos(byte(opcode), value.ChunkMetadata{
+					Position: e.Property.Pos,
+					Length: len(e.Property.Lexeme),
+				})
+				c.writeBytes(util.IntToBytes(index))
+				return
+			}
+
 			c.expression(e.Left)
 
 			// Store the name as a string in the constant table and retrieve it later.
@@ -311,9 +414,25 @@ func (c *Compiler) expression(expr ast.Expression) {
 				Length: len(e.Property.Lexeme),
 			})
 			c.writeBytes(util.IntToBytes(index))
+			c.writeBytes(util.IntToBytes(c.addCache()))
 		}
 
 		case ast.SetPropertyExpression: {
+			if index, opcode, ok := c.resolveModuleMember(e.Left, e.Property, true); ok {
+				if index < 0 {
+					return
+				}
+
+				c.expression(e.Value)
+
+				c.writeBytePos(byte(opcode), value.ChunkMetadata{
+					Position: e.Property.Pos,
+					Length: len(e.Property.Lexeme),
+				})
+				c.writeBytes(util.IntToBytes(index))
+				return
+			}
+
 			c.expression(e.Left)
 			c.expression(e.Value)
 
@@ -326,6 +445,30 @@ func (c *Compiler) expression(expr ast.Expression) {
 				Length: len(e.Property.Lexeme),
 			})
 			c.writeBytes(util.IntToBytes(index))
+			c.writeBytes(util.IntToBytes(c.addCache()))
+		}
+
+		case ast.IndexExpression: {
+			c.expression(e.Left)
+			c.expression(e.Index)
+
+			c.writeBytePos(OP_GET_INDEX, value.ChunkMetadata{
+				Position: expr.Base.Pos,
+				Length: expr.Base.Length,
+			})
+		}
+
+		case ast.SetIndexExpression: {
+			c.expression(e.Left)
+			c.expression(e.Index)
+
+			// The value to be assigned will be on top of the index and the object.
+			c.expression(e.Value)
+
+			c.writeBytePos(OP_SET_INDEX, value.ChunkMetadata{
+				Position: expr.Base.Pos,
+				Length: expr.Base.Length,
+			})
 		}
 	}
 }
@@ -0,0 +1,120 @@
+package compiler
+
+import (
+	"maps"
+	"path/filepath"
+	"vm-go/ast"
+	"vm-go/token"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The compiler of the inputs of the REPL. The builtins are its first globals, before any checkpoint is taken,
+// so a failed input can't roll them back. The imports are relative to the working directory.
+func NewInputCompiler(fileData *util.FileData, registry *value.Registry, streams *util.Streams) *Compiler {
+	c := NewCompiler([]ast.Statement{}, fileData, registry, streams)
+	c.addBuiltins()
+
+	path, _ := filepath.Abs(fileData.Path)
+
+	*c.modules = append(*c.modules, &Module{
+		path: path,
+		fileData: fileData,
+		imports: map[string]int{},
+		loaded: true,
+	})
+
+	return c
+}
+
+// The REPL compiles every input with the same compiler, into its own chunk, which the VM runs with the same globals.
+// The declarations of an input become globals, like in a file, and every other statement runs inside a function
+// that's called right away, so it can have locals. The chunk leaves the value of the input on the stack:
+// the value of its last statement if it's an expression, or void otherwise.
+func (c *Compiler) CompileInput(stmts []ast.Statement, fileData *util.FileData) (value.Chunk, bool) {
+	c.ast = stmts
+	c.fileData = fileData
+	(*c.modules)[0].fileData = fileData
+
+	c.chunk = value.Chunk{}
+	c.hadError = false
+	c.panicMode = false
+	c.warnings = newWarnings()
+
+	c.imports()
+	c.hoistTopLevel()
+
+	echo := false
+
+	for i, stmt := range stmts {
+		c.panicMode = false
+
+		switch s := stmt.Data.(type) {
+			case ast.VarStatement, ast.FnStatement, ast.RecordStatement, ast.ImportStatement:
+				c.statement(stmt)
+
+			case ast.ExprStatement: {
+				if i == len(stmts) - 1 {
+					echo = true
+					c.inputStatement(ast.Statement{ Base: stmt.Base, Data: ast.ReturnStatement{ Expression: &s.Expr } }, true)
+				} else {
+					c.inputStatement(stmt, false)
+				}
+			}
+
+			default:
+				c.inputStatement(stmt, false)
+		}
+	}
+
+	if !echo {
+		c.writeBytePos(OP_PUSH_VOID, value.NewMetaLen1(token.Position{}))
+	}
+
+	c.reportWarnings()
+	return c.chunk, c.hadError
+}
+
+// Calls a function with the statement as its body, keeping the result only if it's echoed.
+func (c *Compiler) inputStatement(stmt ast.Statement, keepResult bool) {
+	body := ast.BlockStatement{ Stmts: []ast.Statement{ stmt } }
+	c.compileFunction([]ast.Parameter{}, body, nil, stmt.Base.Pos)
+
+	c.writeBytePos(OP_CALL, value.ChunkMetadata{
+		Position: stmt.Base.Pos,
+		Length: stmt.Base.Length,
+	})
+	c.writeBytes(util.IntToBytes(0))
+
+	if !keepResult {
+		c.writeBytePos(OP_POP, value.NewMetaLen1(stmt.Base.Pos))
+	}
+}
+
+// ---
+
+// What the compiler knew before an input, to forget its declarations if it fails.
+type Checkpoint struct {
+	globals int
+	modules int
+	imports map[string]int
+}
+
+func (c *Compiler) Checkpoint() Checkpoint {
+	return Checkpoint{
+		globals: len(c.globals),
+		modules: len(*c.modules),
+		imports: maps.Clone((*c.modules)[0].imports),
+	}
+}
+
+// The VM must forget the globals after 'GlobalCount' too, so both agree on the index of each global.
+func (c *Compiler) Rollback(checkpoint Checkpoint) {
+	c.globals = c.globals[:checkpoint.globals]
+	*c.modules = (*c.modules)[:checkpoint.modules]
+	(*c.modules)[0].imports = checkpoint.imports
+}
+
+func (c Checkpoint) GlobalCount() int {
+	return c.globals
+}
@@ -0,0 +1,270 @@
+package compiler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"vm-go/ast"
+	"vm-go/lexer"
+	"vm-go/token"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The module of the native functions, which are visible from every module.
+const builtinModule = -1
+
+// Every module is compiled once, into the same chunk, right before the first module that imports it.
+// Their globals live in the same list, and each global belongs to the module that declared it,
+// so two modules can declare the same name. 'alias.name' is resolved at compile time to the global
+// of the imported module.
+type Module struct {
+	path string // absolute, to identify the module no matter how it's imported
+	fileData *util.FileData
+	imports map[string]int // alias -> module index
+	loaded bool // false while it's being compiled, to detect cycles
+}
+
+func (c *Compiler) currentModule() *Module {
+	return (*c.modules)[c.module]
+}
+
+// Compiles the imports of the current module, before hoisting its globals,
+// so the globals of the imported modules are defined first.
+func (c *Compiler) imports() {
+	for _, decl := range c.ast {
+		if s, ok := decl.Data.(ast.ImportStatement); ok {
+			c.importStatement(s)
+		}
+	}
+}
+
+func (c *Compiler) importStatement(s ast.ImportStatement) {
+	path := s.Path.Lexeme
+	pathLen := len(path) + 2 // the quotes
+
+	if filepath.Ext(path) == "" {
+		path += ".vm"
+	}
+
+	// Paths are relative to the file that imports them.
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(c.fileData.Path), path)
+	}
+
+	index := c.loadModule(path, s.Path.Pos, pathLen)
+
+	if index < 0 {
+		return
+	}
+
+	if len(s.Names) > 0 {
+		for _, name := range s.Names {
+			c.importName(index, name)
+		}
+
+		return
+	}
+
+	alias := s.Alias
+
+	if alias.IsAbsent() {
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+		if !isIdentifier(name) {
+			c.error(s.Path.Pos, pathLen, fmt.Sprintf("'%s' isn't a valid module name, use 'as' to give it one.", name))
+			return
+		}
+
+		alias = token.Token{ Lexeme: name, Pos: s.Path.Pos }
+	}
+
+	if _, ok := c.currentModule().imports[alias.Lexeme]; ok {
+		c.error(alias.Pos, len(alias.Lexeme), fmt.Sprintf("A module named '%s' has already been imported.", alias.Lexeme))
+		return
+	}
+
+	c.currentModule().imports[alias.Lexeme] = index
+}
+
+// Defines a global in the current module, holding a copy of the imported one.
+func (c *Compiler) importName(module int, name token.Token) {
+	index := c.findGlobal(module, name.Lexeme)
+
+	if index < 0 {
+		c.error(name.Pos, len(name.Lexeme), fmt.Sprintf("'%s' doesn't exist in '%s'.", name.Lexeme, (*c.modules)[module].fileData.Name))
+		return
+	}
+
+	if c.findGlobal(c.module, name.Lexeme) >= 0 || c.findGlobal(builtinModule, name.Lexeme) >= 0 {
+		c.error(name.Pos, len(name.Lexeme), fmt.Sprintf("'%s' has already been declared in this scope.", name.Lexeme))
+		return
+	}
+
+	c.globals = append(c.globals, Global{
+		name: name,
+		initialized: true,
+		module: c.module,
+	})
+
+	c.writeBytePos(OP_GET_GLOBAL, value.ChunkMetadata{
+		Position: name.Pos,
+		Length: len(name.Lexeme),
+	})
+	c.writeBytes(util.IntToBytes(index))
+	c.addDeclarationInstruction(name.Pos)
+}
+
+// Returns the index of the module, compiling it if it's the first time it's imported, or -1 on errors.
+func (c *Compiler) loadModule(path string, pos token.Position, length int) int {
+	absPath, err := filepath.Abs(path)
+
+	if err != nil {
+		c.error(pos, length, fmt.Sprintf("Cannot resolve the path '%s'.", path))
+		return -1
+	}
+
+	for i, module := range *c.modules {
+		if module.path != absPath {
+			continue
+		}
+
+		if !module.loaded {
+			c.error(pos, length, fmt.Sprintf("Import cycle: %s.", c.importChain(i)))
+			return -1
+		}
+
+		return i
+	}
+
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		c.error(pos, length, fmt.Sprintf("Cannot read the module '%s'.", path))
+		return -1
+	}
+
+	fileData := util.FileData{
+		Name: util.GetFileName(path),
+		Path: path,
+		Lines: strings.Split(string(source), "\n"),
+	}
+
+	// Like the program, see 'CompileSource'.
+	stmts, hadError := c.parse(string(source), &fileData, false)
+
+	if hadError {
+		c.hadError = true
+		return -1
+	}
+
+	if c.check(stmts, &fileData) {
+		c.hadError = true
+	}
+
+	*c.modules = append(*c.modules, &Module{
+		path: absPath,
+		fileData: &fileData,
+		imports: map[string]int{},
+		loaded: false,
+	})
+
+	index := len(*c.modules) - 1
+
+	// Compile the module in place, with its own file and globals.
+	enclosingAst, enclosingFile, enclosingModule := c.ast, c.fileData, c.module
+	c.ast, c.fileData, c.module = stmts, &fileData, index
+
+	c.compileModule()
+
+	c.ast, c.fileData, c.module = enclosingAst, enclosingFile, enclosingModule
+	(*c.modules)[index].loaded = true
+
+	return index
+}
+
+func (c *Compiler) compileModule() {
+	c.imports()
+	c.hoistTopLevel()
+	c.statements(c.ast)
+}
+
+// The modules being compiled, from the one that starts the cycle, like 'a.vm -> b.vm -> a.vm'.
+// The ones that aren't loaded are exactly the ones being compiled, in import order.
+func (c *Compiler) importChain(start int) string {
+	names := []string{}
+
+	for _, module := range (*c.modules)[start:] {
+		if !module.loaded {
+			names = append(names, module.fileData.Name)
+		}
+	}
+
+	names = append(names, (*c.modules)[start].fileData.Name)
+	return strings.Join(names, " -> ")
+}
+
+// Resolves 'alias.name' to the global of the imported module.
+// Returns false if 'left' isn't the name of an imported module.
+func (c *Compiler) resolveModuleMember(left ast.Expression, property token.Token, set bool) (int, Opcode, bool) {
+	ident, ok := left.Data.(ast.IdentifierExpression)
+
+	if !ok {
+		return -1, OP_GET_GLOBAL, false
+	}
+
+	// Variables shadow the modules.
+	if index, _ := c.resolveLocal(ident.Token, false); index != -1 {
+		return -1, OP_GET_GLOBAL, false
+	}
+
+	if index, _ := c.resolveUpvalue(ident.Token, false); index != -1 {
+		return -1, OP_GET_GLOBAL, false
+	}
+
+	module, ok := c.currentModule().imports[ident.Token.Lexeme]
+
+	if !ok {
+		return -1, OP_GET_GLOBAL, false
+	}
+
+	index := c.findGlobal(module, property.Lexeme)
+
+	if index < 0 {
+		c.error(property.Pos, len(property.Lexeme), fmt.Sprintf("'%s' doesn't exist in the module '%s'.", property.Lexeme, ident.Token.Lexeme))
+		return -1, OP_GET_GLOBAL, true
+	}
+
+	c.checkDefined(index, property)
+
+	if set {
+		return index, OP_SET_GLOBAL, true
+	}
+
+	return index, OP_GET_GLOBAL, true
+}
+
+func (c *Compiler) findGlobal(module int, name string) int {
+	for i := len(c.globals) - 1; i >= 0; i-- {
+		if c.globals[i].module == module && c.globals[i].name.Lexeme == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func isIdentifier(name string) bool {
+	if name == "" || lexer.KeywordKind(name) != token.TokenIdentifier {
+		return false
+	}
+
+	for i, r := range name {
+		if r != '_' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && (i == 0 || !('0' <= r && r <= '9')) {
+			return false
+		}
+	}
+
+	return true
+}
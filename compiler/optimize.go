@@ -0,0 +1,337 @@
+package compiler
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"vm-go/ast"
+	"vm-go/token"
+	"vm-go/value"
+)
+
+// The optimizations are on by default, and '-O0' turns them off:
+//
+//	- the operations whose operands are constants are folded, like '2 + 3' into '5'
+//	- the branches of 'if' and 'while' with a constant condition that never run are removed
+//	- the statements after a 'return', 'break' or 'continue' are removed
+//	- the expression statements are reduced to their side effects, with 'reduceToSideEffect'
+//	- the locals popped one after the other are popped by a single OP_POPN_LOCAL
+//
+// The removed code is still compiled, so its errors are reported, and then thrown away.
+// Operations that fail at runtime, like '1 / 0' or '1 + "a"', aren't folded, so the error stays where it was.
+
+func (c *Compiler) SetOptimize(optimize bool) {
+	c.optimize = optimize
+}
+
+// Folds the operations whose operands are constants. Returns the expression unchanged, or with its operands
+// folded, if it can't be folded.
+func fold(expr ast.Expression) ast.Expression {
+	switch e := expr.Data.(type) {
+		case ast.GroupExpression: {
+			inner := fold(e.Expr)
+
+			if isConstant(inner) {
+				return withBase(inner, expr.Base)
+			}
+
+			return ast.Expression{ Base: expr.Base, Data: ast.GroupExpression{ Expr: inner } }
+		}
+
+		case ast.UnaryExpression: {
+			e.Operand = fold(e.Operand)
+
+			switch operand := e.Operand.Data.(type) {
+				case ast.BoolExpression: {
+					if e.Operator.Kind == token.TokenNotKw {
+						return ast.Expression{ Base: expr.Base, Data: ast.BoolExpression{ Literal: !operand.Literal } }
+					}
+				}
+
+				case ast.NumberExpression: {
+					if e.Operator.Kind == token.TokenMinus {
+						if folded, ok := number(expr.Base, -operand.Literal); ok {
+							return folded
+						}
+					}
+				}
+			}
+
+			return ast.Expression{ Base: expr.Base, Data: e }
+		}
+
+		case ast.BinaryExpression: {
+			e.Left = fold(e.Left)
+			e.Right = fold(e.Right)
+
+			if folded, ok := foldBinary(e.Left, e.Right, e.Operator.Kind, expr.Base); ok {
+				return folded
+			}
+
+			return ast.Expression{ Base: expr.Base, Data: e }
+		}
+
+		// Both sides are constants, so short-circuiting doesn't matter.
+		case ast.LogicalExpression: {
+			e.Left = fold(e.Left)
+			e.Right = fold(e.Right)
+
+			if folded, ok := foldBinary(e.Left, e.Right, e.Operator.Kind, expr.Base); ok {
+				return folded
+			}
+
+			return ast.Expression{ Base: expr.Base, Data: e }
+		}
+
+		// The parts are converted to strings the same way OP_CONCAT does.
+		case ast.InterpolationExpression: {
+			parts := make([]ast.Expression, len(e.Parts))
+			builder := strings.Builder{}
+			constant := true
+
+			for i, part := range e.Parts {
+				parts[i] = fold(part)
+				v, ok := constantValue(parts[i])
+
+				if ok {
+					builder.WriteString(v.String())
+				} else {
+					constant = false
+				}
+			}
+
+			if constant {
+				return ast.Expression{ Base: expr.Base, Data: ast.StringExpression{ Literal: builder.String() } }
+			}
+
+			return ast.Expression{ Base: expr.Base, Data: ast.InterpolationExpression{ Parts: parts } }
+		}
+
+		default:
+			return expr
+	}
+}
+
+func foldBinary(left, right ast.Expression, operator token.TokenKind, base ast.AstBase) (ast.Expression, bool) {
+	boolean := func(b bool) (ast.Expression, bool) {
+		return ast.Expression{ Base: base, Data: ast.BoolExpression{ Literal: b } }, true
+	}
+
+	switch l := left.Data.(type) {
+		case ast.NumberExpression: {
+			r, ok := right.Data.(ast.NumberExpression)
+
+			if !ok {
+				return ast.Expression{}, false
+			}
+
+			switch operator {
+				case token.TokenPlus: return number(base, l.Literal + r.Literal)
+				case token.TokenMinus: return number(base, l.Literal - r.Literal)
+				case token.TokenStar: return number(base, l.Literal * r.Literal)
+
+				// Dividing by zero is a runtime error.
+				case token.TokenSlash: {
+					if r.Literal != 0 {
+						return number(base, l.Literal / r.Literal)
+					}
+				}
+
+				case token.TokenPercent: {
+					if r.Literal != 0 {
+						return number(base, math.Mod(l.Literal, r.Literal))
+					}
+				}
+
+				case token.TokenDoubleEqual: return boolean(l.Literal == r.Literal)
+				case token.TokenBangEqual: return boolean(l.Literal != r.Literal)
+				case token.TokenGreater: return boolean(l.Literal > r.Literal)
+				case token.TokenGreaterEqual: return boolean(l.Literal >= r.Literal)
+				case token.TokenLess: return boolean(l.Literal < r.Literal)
+				case token.TokenLessEqual: return boolean(l.Literal <= r.Literal)
+			}
+		}
+
+		case ast.StringExpression: {
+			r, ok := right.Data.(ast.StringExpression)
+
+			if !ok {
+				return ast.Expression{}, false
+			}
+
+			switch operator {
+				case token.TokenPlus: return ast.Expression{ Base: base, Data: ast.StringExpression{ Literal: l.Literal + r.Literal } }, true
+				case token.TokenDoubleEqual: return boolean(l.Literal == r.Literal)
+				case token.TokenBangEqual: return boolean(l.Literal != r.Literal)
+			}
+		}
+
+		case ast.BoolExpression: {
+			r, ok := right.Data.(ast.BoolExpression)
+
+			if !ok {
+				return ast.Expression{}, false
+			}
+
+			switch operator {
+				case token.TokenAndKw: return boolean(l.Literal && r.Literal)
+				case token.TokenOrKw: return boolean(l.Literal || r.Literal)
+				case token.TokenDoubleEqual: return boolean(l.Literal == r.Literal)
+				case token.TokenBangEqual: return boolean(l.Literal != r.Literal)
+			}
+		}
+
+		case ast.NilExpression: {
+			if _, ok := right.Data.(ast.NilExpression); !ok {
+				return ast.Expression{}, false
+			}
+
+			switch operator {
+				case token.TokenDoubleEqual: return boolean(true)
+				case token.TokenBangEqual: return boolean(false)
+			}
+		}
+	}
+
+	return ast.Expression{}, false
+}
+
+// The constants are deduplicated with 'reflect.DeepEqual', which doesn't tell '-0' from '0', so '-0' isn't folded.
+func number(base ast.AstBase, n float64) (ast.Expression, bool) {
+	if n == 0 && math.Signbit(n) {
+		return ast.Expression{}, false
+	}
+
+	return ast.Expression{ Base: base, Data: ast.NumberExpression{ Literal: n } }, true
+}
+
+func isConstant(expr ast.Expression) bool {
+	_, ok := constantValue(expr)
+	return ok
+}
+
+func constantValue(expr ast.Expression) (value.Value, bool) {
+	switch e := expr.Data.(type) {
+		case ast.NumberExpression:
+			return value.ValueNumber{ Value: e.Literal }, true
+
+		case ast.StringExpression:
+			return value.ValueString{ Value: e.Literal }, true
+
+		case ast.BoolExpression:
+			return value.ValueBool{ Value: e.Literal }, true
+
+		case ast.NilExpression:
+			return value.ValueNil{}, true
+
+		default:
+			return nil, false
+	}
+}
+
+// Returns the value of a condition, if it's known at compile time.
+func (c *Compiler) constantCondition(condition ast.Expression) (bool, bool) {
+	if !c.optimize {
+		return false, false
+	}
+
+	b, ok := fold(condition).Data.(ast.BoolExpression)
+	return b.Literal, ok
+}
+
+func withBase(expr ast.Expression, base ast.AstBase) ast.Expression {
+	expr.Base = base
+	return expr
+}
+
+// Whether the statements after this one, in the same block, never run.
+func endsFlow(stmt ast.Statement) bool {
+	switch stmt.Data.(type) {
+		case ast.ReturnStatement, ast.BreakStatement, ast.ContinueStatement:
+			return true
+
+		default:
+			return false
+	}
+}
+
+// What 'discard' restores in a compiler.
+type snapshot struct {
+	compiler  *Compiler
+	code      int
+	constants int
+	caches    int
+	locals    []Local // with the flags of the captured ones
+	names     int
+	upvalues  int
+}
+
+// Compiles code that never runs, only to report its errors, and throws it away. The enclosing compilers
+// are restored too, as the variables the code reads from them become upvalues of each one in between.
+func (c *Compiler) discard(compile func()) {
+	snapshots := []snapshot{}
+
+	for compiler := c; compiler != nil; compiler = compiler.enclosing {
+		snapshots = append(snapshots, snapshot{
+			compiler: compiler,
+			code: len(compiler.chunk.Code),
+			constants: len(compiler.chunk.Constants),
+			caches: len(compiler.chunk.Caches),
+			locals: slices.Clone(compiler.locals),
+			names: len(compiler.chunk.Locals),
+			upvalues: len(compiler.upvalues),
+		})
+	}
+
+	compile()
+
+	for _, s := range snapshots {
+		s.compiler.chunk.Code = s.compiler.chunk.Code[:s.code]
+		s.compiler.chunk.Metadata = s.compiler.chunk.Metadata[:s.code]
+		s.compiler.chunk.Constants = s.compiler.chunk.Constants[:s.constants]
+		s.compiler.chunk.Caches = s.compiler.chunk.Caches[:s.caches]
+		s.compiler.locals = s.locals
+		s.compiler.chunk.Locals = s.compiler.chunk.Locals[:s.names]
+		s.compiler.upvalues = s.compiler.upvalues[:s.upvalues]
+	}
+
+	c.lastPop = -1
+}
+
+// The parts of the expression that 'reduceToSideEffect' leaves out, which don't have side effects.
+// Only they are compiled for their errors, so the part that's kept isn't compiled twice.
+func leftOut(expr ast.Expression) []ast.Expression {
+	if reduceToSideEffect(expr) == nil {
+		return []ast.Expression{ expr }
+	}
+
+	switch e := expr.Data.(type) {
+		case ast.UnaryExpression:
+			return leftOut(e.Operand)
+
+		case ast.GroupExpression:
+			return leftOut(e.Expr)
+
+		case ast.GetPropertyExpression:
+			return leftOut(e.Left)
+
+		case ast.BinaryExpression:
+			return leftOutOperands(e.Left, e.Right)
+
+		case ast.LogicalExpression:
+			return leftOutOperands(e.Left, e.Right)
+
+		// Kept whole.
+		default:
+			return nil
+	}
+}
+
+func leftOutOperands(left, right ast.Expression) []ast.Expression {
+	// Both have side effects, so the expression is kept whole.
+	if reduceToSideEffect(left) != nil && reduceToSideEffect(right) != nil {
+		return nil
+	}
+
+	return append(leftOut(left), leftOut(right)...)
+}
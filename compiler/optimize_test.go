@@ -0,0 +1,77 @@
+package compiler
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The code removed by the optimizations doesn't leave anything behind, like the upvalues it captured.
+func TestDiscardedCodeCapturesNothing(t *testing.T) {
+	chunk := compile(t, `fn main() {
+    var x = 1;
+    var f = () -> {
+        if false {
+            return x;
+        }
+
+        return 2;
+    };
+    f();
+}`)
+
+	main := function(t, chunk, "main")
+	closure := function(t, main.Chunk, "")
+
+	if len(closure.Chunk.Upvalues) != 0 {
+		t.Errorf("expected no upvalues, got %v", closure.Chunk.Upvalues)
+	}
+
+	// 'x' isn't captured, so it's popped, not closed.
+	for _, opcode := range main.Chunk.Code {
+		if opcode == OP_CLOSE_UPVALUE {
+			t.Errorf("expected 'x' not to be captured")
+		}
+	}
+}
+
+func compile(t *testing.T, source string) value.Chunk {
+	streams := util.NewStreams(io.Discard, io.Discard, strings.NewReader(""))
+	fileData := util.FileData{ Name: "test.vm", Path: "test.vm", Lines: strings.Split(source, "\n") }
+
+	tokens, hadError := lexer.NewLexer(source, &fileData, streams).Lex()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	ast, hadError := parser.NewParser(tokens, &fileData, streams).Parse()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	chunk, hadError := NewCompiler(ast, &fileData, value.NewRegistry(), streams).Compile()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	return chunk
+}
+
+// The function constant with the name, "" for an anonymous one.
+func function(t *testing.T, chunk value.Chunk, name string) value.ValueFunction {
+	for _, constant := range chunk.Constants {
+		if fn, ok := constant.(value.ValueFunction); ok && ((fn.Name == nil && name == "") || (fn.Name != nil && *fn.Name == name)) {
+			return fn
+		}
+	}
+
+	t.Fatalf("expected the function '%s'", name)
+	return value.ValueFunction{}
+}
@@ -0,0 +1,85 @@
+package compiler
+
+import (
+	"vm-go/ast"
+	"vm-go/checker"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// What the source is compiled as.
+type Mode int
+
+const (
+	ModeProgram Mode = iota // the top-level code, then 'main'
+	ModeLibrary             // only the top-level code, the host calls the functions after
+	ModeInput               // an input of the REPL, see 'CompileInput'
+)
+
+// Runs every stage of the compilation over the source, and they all report to the diagnostics of the compiler.
+// A stage still runs after the errors of the one before when it can find more: the parser skips what the lexer
+// didn't know, and the compiler resolves the names even if the types are wrong. The chunk is only good without errors.
+// The inputs of the REPL are checked with its checker, which remembers the previous ones, and it's nil otherwise.
+func (c *Compiler) CompileSource(source string, fileData *util.FileData, inputChecker *checker.Checker, mode Mode) (value.Chunk, bool) {
+	stmts, hadError := c.parse(source, fileData, mode == ModeInput)
+
+	if hadError {
+		return value.Chunk{}, true
+	}
+
+	var typeError bool
+
+	if mode == ModeInput {
+		typeError = inputChecker.CheckInput(stmts, fileData)
+	} else {
+		typeError = c.check(stmts, fileData)
+	}
+
+	var chunk value.Chunk
+
+	switch mode {
+		case ModeProgram: {
+			c.ast, c.fileData = stmts, fileData
+			chunk, hadError = c.Compile()
+		}
+
+		case ModeLibrary: {
+			c.ast, c.fileData = stmts, fileData
+			chunk, hadError = c.CompileLibrary()
+		}
+
+		case ModeInput:
+			chunk, hadError = c.CompileInput(stmts, fileData)
+	}
+
+	return chunk, typeError || hadError
+}
+
+func (c *Compiler) parse(source string, fileData *util.FileData, input bool) ([]ast.Statement, bool) {
+	lexer := lexer.NewLexer(source, fileData, c.streams)
+	lexer.SetDiagnostics(c.diagnostics)
+	tokens, lexError := lexer.Lex()
+
+	parser := parser.NewParser(tokens, fileData, c.streams)
+	parser.SetDiagnostics(c.diagnostics)
+
+	var stmts []ast.Statement
+	var hadError bool
+
+	if input {
+		stmts, hadError = parser.ParseInput()
+	} else {
+		stmts, hadError = parser.Parse()
+	}
+
+	return stmts, lexError || hadError
+}
+
+func (c *Compiler) check(stmts []ast.Statement, fileData *util.FileData) bool {
+	checker := checker.NewChecker(stmts, fileData, c.registry, c.streams)
+	checker.SetDiagnostics(c.diagnostics)
+
+	return checker.Check()
+}
@@ -15,11 +15,8 @@ func (c *Compiler) statement(stmt ast.Statement) {
 				fieldNames = append(fieldNames, field.Name.Lexeme)
 			}
 
-			index := c.addConstant(value.ValueRecord{
-				Name: s.Name.Lexeme,
-				FieldNames: fieldNames,
-				Methods: []value.ValueClosure{}, // empty for now
-			})
+			// The methods are added at runtime, by OP_APPEND_METHODS.
+			index := c.addConstant(value.NewRecord(s.Name.Lexeme, fieldNames))
 
 			c.writeBytePos(OP_PUSH_CONST, value.NewMetaLen1(stmt.Base.Pos))
 			c.writeBytes(util.IntToBytes(index))
@@ -48,16 +45,32 @@ func (c *Compiler) statement(stmt ast.Statement) {
 		case ast.VarStatement: {
 			c.expression(s.Init)
 
-			if c.hadError {
-				return
-			}
-
+			// Even if it has errors, so its uses aren't errors too.
 			c.addVariable(s.Name, s.Name.Pos)
 			c.addDeclarationInstruction(stmt.Base.Pos)
 		}
 
 		// Control flow graph in the compileIf function.
 		case ast.IfStatement: {
+			if condition, ok := c.constantCondition(s.Condition); ok {
+				then := func() { c.block(s.Then.Stmts, stmt.Base.Pos) }
+				else_ := func() {
+					if s.Else != nil {
+						c.block(s.Else.Stmts, stmt.Base.Pos)
+					}
+				}
+
+				if condition {
+					then()
+					c.discard(else_)
+				} else {
+					c.discard(then)
+					else_()
+				}
+
+				return
+			}
+
 			var else_ *func() = nil
 
 			if s.Else != nil {
@@ -89,10 +102,25 @@ func (c *Compiler) statement(stmt ast.Statement) {
             continues...
 		*/
 		case ast.WhileStatement: {
+			// The loop never runs.
+			if condition, ok := c.constantCondition(s.Condition); ok && !condition {
+				c.discard(func() {
+					c.loopFlowPos = append(c.loopFlowPos, len(c.chunk.Code))
+					c.loopHandlerCount = append(c.loopHandlerCount, c.handlerCount)
+					c.block(s.Block.Stmts, stmt.Base.Pos)
+
+					util.PopList(&c.loopFlowPos)
+					util.PopList(&c.loopHandlerCount)
+				})
+
+				return
+			}
+
 			conditionPos := len(c.chunk.Code)
 			c.expression(s.Condition)
 
 			c.loopFlowPos = append(c.loopFlowPos, len(c.chunk.Code))
+			c.loopHandlerCount = append(c.loopHandlerCount, c.handlerCount)
 			c.writeBytePos(OP_JUMP_FALSE, value.NewMetaLen1(stmt.Base.Pos))
 			jumpOffsetIndex := len(c.chunk.Code)
 			c.writeBytes(util.IntToBytes(0)) // dummy
@@ -101,6 +129,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.block(s.Block.Stmts, stmt.Base.Pos)
 
 			util.PopList(&c.loopFlowPos)
+			util.PopList(&c.loopHandlerCount)
 			
 			c.writeBytePos(OP_LOOP, value.NewMetaLen1(stmt.Base.Pos))
 			c.writeBytes(util.IntToBytes(len(c.chunk.Code) - conditionPos + 4)) // index
@@ -117,32 +146,32 @@ func (c *Compiler) statement(stmt ast.Statement) {
 
                 [ iterable ]
                 OP_MAKE_ITERATOR
-                OP_GET_NEXT
 
-                OP_DEF_LOCAL
-            
             +-- OP_JUMP_HAS_NO_NEXT
+            |   OP_GET_NEXT
+            |   OP_DEF_LOCAL
             |
             |   OP_JUMP ------+
-            +-- OP_JUMP_FALSE | <- break/continue point
-            |   OP_POP        |
-			|   OP_JUMP ------+---+
-            |                 |   |
-            |   [ body ] <----+---+----+
-            |                     |    |
-            |   - end scope - <---+    |
-            |   - begin scope -        |
-			|                          |
-            |   OP_ADVANCE             |
-            +-- OP_JUMP_HAS_NO_NEXT    |
-            |   OP_GET_NEXT            |
-            |   OP_DEF_LOCAL           |
-            |                          |
-            |   OP_LOOP ---------------+
+            |   OP_JUMP_FALSE | ----------+ <- break/continue point
+            |   OP_POP        |           |
+            |   OP_JUMP ------+---+       |
+            |                 |   |       |
+            |   [ body ] <----+   | <--+  |
+            |                     |    |  |
+            |   - end scope - <---+    |  |
+            |   - begin scope -        |  |
+            |                          |  |
+            |   OP_ADVANCE             |  |
+            +-- OP_JUMP_HAS_NO_NEXT    |  |
+            |   OP_GET_NEXT            |  |
+            |   OP_DEF_LOCAL           |  |
+            |                          |  |
+            |   OP_LOOP ---------------+  |
+            |   OP_POP <------------------+
+            |   - end scope -
+            |
             +-> OP_POP
 
-				- end scope -
-
             continues...
 		*/
         case ast.ForStatement: {
@@ -150,20 +179,23 @@ func (c *Compiler) statement(stmt ast.Statement) {
             
             c.expression(s.Iterable)
 			c.writeBytePos(OP_MAKE_ITERATOR, value.NewMetaLen1(stmt.Base.Pos))
+
+			// Check before getting the first element, because the iterable may be empty.
+			c.writeBytePos(OP_JUMP_HAS_NO_NEXT, value.NewMetaLen1(stmt.Base.Pos))
+			jumpNoNextOffsetIndex := len(c.chunk.Code)
+			c.writeBytes(util.IntToBytes(0)) // dummy
+
 			c.writeBytePos(OP_GET_NEXT, value.NewMetaLen1(stmt.Base.Pos))
 			
             c.addVariable(s.Variable, s.Variable.Pos)
             c.addDeclarationInstruction(s.Variable.Pos)
             
-			c.writeBytePos(OP_JUMP_HAS_NO_NEXT, value.NewMetaLen1(stmt.Base.Pos))
-			jumpNoNextOffsetIndex := len(c.chunk.Code)
-			c.writeBytes(util.IntToBytes(0)) // dummy
-			
             c.writeBytePos(OP_JUMP, value.NewMetaLen1(stmt.Base.Pos))
 			jump1OffsetIndex := len(c.chunk.Code)
 			c.writeBytes(util.IntToBytes(0)) // dummy
 			
             c.loopFlowPos = append(c.loopFlowPos, len(c.chunk.Code))
+            c.loopHandlerCount = append(c.loopHandlerCount, c.handlerCount)
 
 			c.writeBytePos(OP_JUMP_FALSE, value.NewMetaLen1(stmt.Base.Pos))
 			jumpFalseOffsetIndex := len(c.chunk.Code)
@@ -180,6 +212,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
             c.block(s.Block.Stmts, stmt.Base.Pos)
             
             util.PopList(&c.loopFlowPos)
+            util.PopList(&c.loopHandlerCount)
             
 			c.backpatch(jump2OffsetIndex, util.IntToBytes(len(c.chunk.Code) - jump2OffsetIndex - 4)) // index
 			
@@ -202,12 +235,18 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.writeBytePos(OP_LOOP, value.NewMetaLen1(stmt.Base.Pos))
 			c.writeBytes(util.IntToBytes(len(c.chunk.Code) - bodyPos + 4)) // index
             
+			// 'break' lands here with the loop variable still defined, so it's discarded
+			// along with the boolean. When the iterator runs out, the variable has already
+			// been discarded (or never defined), so both jumps skip this part.
 			c.backpatch(jumpFalseOffsetIndex, util.IntToBytes(len(c.chunk.Code) - jumpFalseOffsetIndex - 4)) // index
+            c.writeBytePos(OP_POP, value.NewMetaLen1(stmt.Base.Pos))
+			c.endScope(stmt.Base.Pos)
+
 			c.backpatch(jumpNoNextOffsetIndex, util.IntToBytes(len(c.chunk.Code) - jumpNoNextOffsetIndex - 4)) // index
 			c.backpatch(jumpNoNext2OffsetIndex, util.IntToBytes(len(c.chunk.Code) - jumpNoNext2OffsetIndex - 4)) // index
-			
+
+			// Discard the iterator.
             c.writeBytePos(OP_POP, value.NewMetaLen1(stmt.Base.Pos))
-			c.endScope(stmt.Base.Pos)
         }
 
 		/*
@@ -249,6 +288,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.expression(s.Condition)
 
 			c.loopFlowPos = append(c.loopFlowPos, len(c.chunk.Code))
+			c.loopHandlerCount = append(c.loopHandlerCount, c.handlerCount)
 
 			c.writeBytePos(OP_JUMP_FALSE, value.NewMetaLen1(stmt.Base.Pos))
 			jumpFalseOffsetIndex := len(c.chunk.Code)
@@ -259,6 +299,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.block(s.Block.Stmts, stmt.Base.Pos)
 
 			util.PopList(&c.loopFlowPos)
+			util.PopList(&c.loopHandlerCount)
 
 			// Push the old value to the stack to save it for the next iteration.
 			c.identifier(s.Declaration.Data.(ast.VarStatement).Name, s.Declaration.Data.(ast.VarStatement).Init)
@@ -306,6 +347,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.writeBytes(util.IntToBytes(0)) // dummy
 
 			c.loopFlowPos = append(c.loopFlowPos, len(c.chunk.Code))
+			c.loopHandlerCount = append(c.loopHandlerCount, c.handlerCount)
 			c.writeBytePos(OP_JUMP_FALSE, value.NewMetaLen1(stmt.Base.Pos))
 			jumpEndOffsetIndex := len(c.chunk.Code)
 			c.writeBytes(util.IntToBytes(0)) // dummy
@@ -320,6 +362,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 			c.writeBytes(util.IntToBytes(len(c.chunk.Code) - loopPos + 4)) // index
 
 			util.PopList(&c.loopFlowPos)
+			util.PopList(&c.loopHandlerCount)
 			c.backpatch(jumpEndOffsetIndex, util.IntToBytes(len(c.chunk.Code) - jumpEndOffsetIndex - 4)) // index
 			c.writeBytePos(OP_POP, value.NewMetaLen1(stmt.Base.Pos))
 		}
@@ -334,6 +377,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 				return
 			}
 
+			c.emitPopHandlers(stmt.Base.Pos)
 			c.writeBytePos(OP_PUSH_FALSE, value.NewMetaLen1(stmt.Base.Pos))
 
 			c.writeBytePos(OP_LOOP, value.NewMetaLen1(stmt.Base.Pos))
@@ -348,6 +392,7 @@ func (c *Compiler) statement(stmt ast.Statement) {
 				return
 			}
 
+			c.emitPopHandlers(stmt.Base.Pos)
 			c.writeBytePos(OP_PUSH_TRUE, value.NewMetaLen1(stmt.Base.Pos))
 
 			c.writeBytePos(OP_LOOP, value.NewMetaLen1(stmt.Base.Pos))
@@ -367,9 +412,75 @@ func (c *Compiler) statement(stmt ast.Statement) {
 		case ast.BlockStatement:
 			c.block(s.Stmts, stmt.Base.Pos)
 
+		/*
+            Try/Catch
+            Control Flow:
+
+            +-- OP_PUSH_HANDLER
+            |
+            |   [ body ]
+            |
+            |   OP_POP_HANDLER
+            |   OP_JUMP ---------+
+            |                    |
+            +-> - begin scope -  | (the VM pushes the error when it's caught)
+                OP_DEF_LOCAL     | (or OP_POP, if the error isn't used)
+                                 |
+                [ catch ]        |
+                                 |
+                - end scope -    |
+                                 |
+            continues... <-------+
+		*/
+		case ast.TryStatement: {
+			c.writeBytePos(OP_PUSH_HANDLER, value.NewMetaLen1(stmt.Base.Pos))
+			handlerOffsetIndex := len(c.chunk.Code)
+			c.writeBytes(util.IntToBytes(0)) // dummy
+
+			c.handlerCount++
+			c.block(s.Body.Stmts, stmt.Base.Pos)
+			c.handlerCount--
+
+			c.writeBytePos(OP_POP_HANDLER, value.NewMetaLen1(stmt.Base.Pos))
+			c.writeBytePos(OP_JUMP, value.NewMetaLen1(stmt.Base.Pos))
+			jumpOffsetIndex := len(c.chunk.Code)
+			c.writeBytes(util.IntToBytes(0)) // dummy
+
+			c.backpatch(handlerOffsetIndex, util.IntToBytes(len(c.chunk.Code) - handlerOffsetIndex - 4)) // index
+			c.beginScope()
+
+			if s.Variable.IsAbsent() {
+				c.writeBytePos(OP_POP, value.NewMetaLen1(stmt.Base.Pos))
+			} else {
+				c.addVariable(s.Variable, s.Variable.Pos)
+				c.addDeclarationInstruction(s.Variable.Pos)
+			}
+
+			c.block(s.Catch.Stmts, stmt.Base.Pos)
+			c.endScope(stmt.Base.Pos)
+
+			c.backpatch(jumpOffsetIndex, util.IntToBytes(len(c.chunk.Code) - jumpOffsetIndex - 4)) // index
+		}
+
+		// Imports are compiled before the rest of the module, in 'imports'.
+		case ast.ImportStatement:
+
 		case ast.ExprStatement: {
+			if reduceToSideEffect(s.Expr) == nil {
+				c.warn(s.Expr.Base.Pos, s.Expr.Base.Length, warnNoEffect, "This expression has no effect.")
+			}
+
 			// Optimization to remove nodes that don't have side effects.
-			reduced := reduceToSideEffect(s.Expr)
+			reduced := &s.Expr
+
+			if c.optimize {
+				reduced = reduceToSideEffect(s.Expr)
+
+				// The parts left out are still compiled, for their errors.
+				for _, part := range leftOut(s.Expr) {
+					c.discard(func() { c.expression(part) })
+				}
+			}
 
 			if reduced != nil {
 				c.expression(*reduced)
@@ -101,6 +101,8 @@ func (c *Compiler) compileFunctionCompiler(fnCompiler *Compiler, parameters []as
 		fnCompiler.addVariable(param.Name, param.Name.Pos)
 	}
 
+	fnCompiler.declaredParameters()
+
 	fnChunk, hadError := fnCompiler.compileFnBody(pos)
 
 	if hadError {
@@ -108,6 +110,10 @@ func (c *Compiler) compileFunctionCompiler(fnCompiler *Compiler, parameters []as
 		return
 	}
 
+	for _, upvalue := range fnCompiler.upvalues {
+		fnChunk.Upvalues = append(fnChunk.Upvalues, upvalue.name)
+	}
+
 	function := value.ValueFunction{
 		Arity: len(parameters),
 		Chunk: fnChunk,
@@ -166,6 +172,7 @@ func (c *Compiler) writeByte(b byte) {
 }
 
 func (c *Compiler) writeBytePos(b byte, meta value.ChunkMetadata) {
+	meta.File = c.fileData
 	c.chunk.Metadata = append(c.chunk.Metadata, meta)
 	c.chunk.Code = append(c.chunk.Code, b)
 }
@@ -183,7 +190,7 @@ func (c *Compiler) backpatch(index int, bytes []byte) {
 	// Ensure the position is valid
 	if index < 0 || index + len(bytes) > len(c.chunk.Code) {
 		// TODO: separate this into a function
-		fmt.Printf("internal: invalid position: %d\n", index)
+		fmt.Fprintf(c.streams.Stderr, "internal: invalid position: %d\n", index)
 		c.hadError = true
 		return
 	}
@@ -192,6 +199,9 @@ func (c *Compiler) backpatch(index int, bytes []byte) {
 	for i, b := range bytes {
 		c.chunk.Code[index + i] = b
 	}
+
+	// The jumps are always patched to land at the current offset.
+	c.lastLabel = len(c.chunk.Code)
 }
 
 func (c *Compiler) addDeclarationInstruction(pos token.Position) {
@@ -247,6 +257,7 @@ func (c *Compiler) resolveLocal(token token.Token, set bool) (int, Opcode) {
 				opcode = OP_SET_LOCAL
 			} else {
 				opcode = OP_GET_LOCAL
+				c.used(c.locals[i])
 			}
 
 			return i, opcode
@@ -257,10 +268,6 @@ func (c *Compiler) resolveLocal(token token.Token, set bool) (int, Opcode) {
 }
 
 func (c *Compiler) resolveUpvalue(token token.Token, set bool) (int, Opcode) {
-	if c.enclosing == nil {
-		return -1, OP_GET_UPVALUE
-	}
-
 	var opcode Opcode
 
 	if set {
@@ -269,6 +276,21 @@ func (c *Compiler) resolveUpvalue(token token.Token, set bool) (int, Opcode) {
 		opcode = OP_GET_UPVALUE
 	}
 
+	// The expressions of the debugger use the upvalues the paused function already has.
+	if c.debugUpvalues != nil {
+		for i, name := range c.debugUpvalues {
+			if name == token.Lexeme {
+				return i, opcode
+			}
+		}
+
+		return -1, opcode
+	}
+
+	if c.enclosing == nil {
+		return -1, opcode
+	}
+
 	// search it in enclosing's locals.
 	// the opcode is not necessary
 	index, _ := c.enclosing.resolveLocal(token, set)
@@ -279,7 +301,7 @@ func (c *Compiler) resolveUpvalue(token token.Token, set bool) (int, Opcode) {
 		// to close the upvalues that reference it, when it goes out of scope.
 		c.enclosing.locals[index].isCaptured = true
 
-		upIndex := c.addUpvalue(index, true)
+		upIndex := c.addUpvalue(index, true, token.Lexeme)
 		return upIndex, opcode
 	}
 
@@ -288,7 +310,7 @@ func (c *Compiler) resolveUpvalue(token token.Token, set bool) (int, Opcode) {
 
 	// found it. let's capture that upvalue and add it to the list of upvalues of this function.
 	if index != -1 {
-		upIndex := c.addUpvalue(index, false)
+		upIndex := c.addUpvalue(index, false, token.Lexeme)
 		return upIndex, opcode
 	}
 
@@ -296,9 +318,16 @@ func (c *Compiler) resolveUpvalue(token token.Token, set bool) (int, Opcode) {
 	return -1, OP_GET_UPVALUE
 }
 
+// The expressions of the debugger run while the program is paused, maybe before the declaration of the global.
+func (c *Compiler) checkDefined(index int, token token.Token) {
+	if c.debugUpvalues != nil && index >= c.debugGlobals {
+		c.error(token.Pos, len(token.Lexeme), fmt.Sprintf("'%s' isn't defined yet, the program hasn't reached its declaration.", token.Lexeme))
+	}
+}
+
 func (c *Compiler) resolveGlobal(token token.Token, set bool) (int, Opcode) {
 	for i := len(c.globals) - 1; i >= 0; i-- {
-		if c.globals[i].name.Lexeme == token.Lexeme {
+		if c.isVisible(c.globals[i]) && c.globals[i].name.Lexeme == token.Lexeme {
 			// the scope depth is also verified, because if the compiler is in an inner scope, the global is
 			// guaranteed to be initialized, because the program always starts at main(), and it is called after
 			// all globals are initialized.
@@ -306,6 +335,15 @@ func (c *Compiler) resolveGlobal(token token.Token, set bool) (int, Opcode) {
 				c.error(token.Pos, len(token.Lexeme), fmt.Sprintf("'%s' is used before being initialized.", token.Lexeme))
 			}
 
+			c.checkDefined(i, token)
+
+			// Any use of a native the sandbox doesn't grant is an error, even if it's only stored.
+			if c.globals[i].module == builtinModule {
+				if capability, missing := c.registry.MissingCapability(token.Lexeme); missing {
+					c.error(token.Pos, len(token.Lexeme), value.MissingCapability(token.Lexeme, capability))
+				}
+			}
+
 			var opcode Opcode
 
 			if set {
@@ -321,7 +359,12 @@ func (c *Compiler) resolveGlobal(token token.Token, set bool) (int, Opcode) {
 	return -1, OP_GET_GLOBAL
 }
 
-func (c *Compiler) addUpvalue(index int, isLocal bool) int {
+// The globals of other modules are only visible through their module's name.
+func (c *Compiler) isVisible(global Global) bool {
+	return global.module == c.module || global.module == builtinModule
+}
+
+func (c *Compiler) addUpvalue(index int, isLocal bool, name string) int {
 	// check if an upvalue to the same variable already exists
 	// if so, return it
     for i, upvalue := range c.upvalues {
@@ -335,6 +378,7 @@ func (c *Compiler) addUpvalue(index int, isLocal bool) int {
     c.upvalues = append(c.upvalues, Upvalue{
         index:   index,
         isLocal: isLocal,
+        name:    name,
     })
 
     return len(c.upvalues) - 1
@@ -362,7 +406,7 @@ func (c *Compiler) addVariable(token token.Token, pos token.Position) {
 
 			// We just need to check for redeclaration, and mark it as initialized if it's not;
 			for i := len(c.globals) - 1; i >= 0; i-- {
-				if c.globals[i].name.Lexeme == token.Lexeme {
+				if c.isVisible(c.globals[i]) && c.globals[i].name.Lexeme == token.Lexeme {
 					if c.globals[i].initialized {
 						// It's a redeclaration, so we throw an error.
 						// this message is for the global scope.
@@ -376,11 +420,7 @@ func (c *Compiler) addVariable(token token.Token, pos token.Position) {
 			}
 		} else {
 			// It's a local, so we declare it.
-			c.locals = append(c.locals, Local{
-				name:        token,
-				depth:       c.scopeDepth,
-				isCaptured:  false,
-			})
+			c.declareLocal(token)
 		}
 	} else {
 		// We found the variable, it can be in the same scope or not
@@ -393,15 +433,28 @@ func (c *Compiler) addVariable(token token.Token, pos token.Position) {
 			return
 		} else {
 			// The variable is in an enclosing scope, we'll shadow it by declaring it in this scope
-			c.locals = append(c.locals, Local{
-				name:        token,
-				depth:       c.scopeDepth,
-				isCaptured:  false,
-			})
+			c.declareLocal(token)
 		}
 	}
 }
 
+// The chunk keeps the name of its slot too, for the debugger.
+func (c *Compiler) declareLocal(token token.Token) {
+	c.locals = append(c.locals, Local{
+		name:        token,
+		depth:       c.scopeDepth,
+		isCaptured:  false,
+	})
+
+	c.chunk.Locals = append(c.chunk.Locals, value.LocalName{
+		Name: token.Lexeme,
+		Slot: len(c.locals) - 1,
+		Start: len(c.chunk.Code),
+	})
+
+	c.declared(token)
+}
+
 func (c *Compiler) block(stmts []ast.Statement, pos token.Position) {
 	c.beginScope()
 	c.statements(stmts)
@@ -453,6 +506,30 @@ func (c *Compiler) endScope(pos token.Position) {
 
 func (c *Compiler) emitPop(count int, pos token.Position) {
 	// If count <= 0 this function does nothing.
+	if count <= 0 {
+		return
+	}
+
+	// Merge it with the previous instruction, if it popped locals too and nothing jumps in between.
+	if c.optimize && c.lastPop >= 0 && c.lastLabel != len(c.chunk.Code) {
+		previous := 0
+
+		switch {
+			case c.lastPop + 1 == len(c.chunk.Code) && c.chunk.Code[c.lastPop] == OP_POP_LOCAL:
+				previous = 1
+
+			case c.lastPop + 5 == len(c.chunk.Code) && c.chunk.Code[c.lastPop] == OP_POPN_LOCAL:
+				previous, _ = util.BytesToInt(c.chunk.Code[c.lastPop + 1:])
+		}
+
+		if previous > 0 {
+			count += previous
+			c.chunk.Code = c.chunk.Code[:c.lastPop]
+			c.chunk.Metadata = c.chunk.Metadata[:c.lastPop]
+		}
+	}
+
+	c.lastPop = len(c.chunk.Code)
 
 	if count > 1 {
 		c.writeBytePos(OP_POPN_LOCAL, value.NewMetaLen1(pos))
@@ -462,6 +539,13 @@ func (c *Compiler) emitPop(count int, pos token.Position) {
 	}
 }
 
+// Discards the handlers of the 'try' blocks inside the current loop, before jumping out of it.
+func (c *Compiler) emitPopHandlers(pos token.Position) {
+	for range c.handlerCount - c.loopHandlerCount[len(c.loopHandlerCount) - 1] {
+		c.writeBytePos(OP_POP_HANDLER, value.NewMetaLen1(pos))
+	}
+}
+
 func (c *Compiler) addConstant(v value.Value) int {
 	for i, constant := range c.chunk.Constants {
 		if reflect.DeepEqual(constant, v) {
@@ -473,6 +557,12 @@ func (c *Compiler) addConstant(v value.Value) int {
 	return len(c.chunk.Constants) - 1
 }
 
+// Every property access has its own inline cache.
+func (c *Compiler) addCache() int {
+	c.chunk.Caches = append(c.chunk.Caches, value.PropertyCache{})
+	return len(c.chunk.Caches) - 1
+}
+
 // Reduces an Expression to the nearest node that may contain side effects.
 // Returns 'nil' if the supplied Expression doesn't contain side effects.
 func reduceToSideEffect(expr ast.Expression) *ast.Expression {
@@ -548,7 +638,7 @@ func reduceToSideEffect(expr ast.Expression) *ast.Expression {
 
 		// These nodes may have side effects.
 		case ast.CallExpression, ast.IdentifierAssignmentExpression,
-			ast.SetPropertyExpression, ast.IfExpression:
+			ast.SetPropertyExpression, ast.SetIndexExpression, ast.IfExpression:
 			return &expr
 		
 		// In the default case, we return the expression untouched.
@@ -557,25 +647,24 @@ func reduceToSideEffect(expr ast.Expression) *ast.Expression {
 	}
 }
 
+// Only the first error of a statement is reported, the next ones are likely caused by it.
 func (c *Compiler) error(pos token.Position, length int, message string) {
-	if c.hadError {
+	if c.panicMode {
 		return
 	}
 
-	util.Error(pos, length, message, c.fileData)
+	c.diagnostics.Error(pos, length, message, c.fileData)
 
 	c.hadError = true
 	c.panicMode = true
 }
 
 func (c *Compiler) errorNoBody(message string) {
-	if c.hadError {
+	if c.panicMode {
 		return
 	}
 
-	fmt.Printf("[-] Error: %s\n", message)
-	fmt.Printf(" |   [-] %s\n", c.fileData.Name)
-	fmt.Print("[-]\n\n")
+	c.diagnostics.ErrorNoPosition(message, c.fileData)
 
 	c.hadError = true
 	c.panicMode = true
@@ -0,0 +1,245 @@
+package compiler
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"vm-go/ast"
+	"vm-go/token"
+	"vm-go/util"
+)
+
+// The warnings are about code that's valid, but likely a mistake:
+//
+//	- 'unused': the locals and the parameters that are never read, unless their name starts with '_'
+//	- 'unreachable': the statements after a 'return', 'break' or 'continue', which never run
+//	- 'shadow': the locals with the name of a local of an enclosing block or function, or of a global of the module
+//	- 'no-effect': the expression statements without side effects, see 'reduceToSideEffect'
+//
+// They're collected while compiling and reported at the end, in the order of the source, because some code is compiled
+// twice, like the variable of a 'for' loop, and a local is unused only once its scope ends. A '// nowarn' comment hides
+// the warnings of its line, or only the ones it names, like '// nowarn: unused, shadow'.
+// They aren't reported with errors, and with 'SetWarningsAsErrors' they're errors themselves.
+
+const (
+	warnUnused      = "unused"
+	warnUnreachable = "unreachable"
+	warnShadow      = "shadow"
+	warnNoEffect    = "no-effect"
+)
+
+type warning struct {
+	kind    string
+	message string
+	length  int
+	site
+}
+
+// Where a local is declared. Its copies, like the ones of the variable of a 'for' loop, are declared at the same place.
+type site struct {
+	file *util.FileData
+	pos  token.Position
+}
+
+type declaration struct {
+	site
+	name  string
+	param bool
+}
+
+// The warnings of a compilation, shared by the compilers of its functions and modules.
+type warnings struct {
+	list []warning
+	seen map[warning]bool
+
+	declarations []*declaration
+	declared     map[site]*declaration
+	used         map[site]bool
+}
+
+func newWarnings() *warnings {
+	return &warnings{
+		list: []warning{},
+		seen: map[warning]bool{},
+
+		declarations: []*declaration{},
+		declared: map[site]*declaration{},
+		used: map[site]bool{},
+	}
+}
+
+func (c *Compiler) SetWarningsAsErrors(asErrors bool) {
+	c.warningsAsErrors = asErrors
+}
+
+// The errors are counted with the ones of the other stages, and of the modules it imports.
+func (c *Compiler) SetDiagnostics(diagnostics *util.Diagnostics) {
+	c.diagnostics = diagnostics
+}
+
+func (c *Compiler) warn(pos token.Position, length int, kind, message string) {
+	if c.warnings == nil {
+		return
+	}
+
+	w := warning{ kind: kind, message: message, length: max(length, 1), site: site{ file: c.fileData, pos: pos } }
+
+	if !c.warnings.seen[w] {
+		c.warnings.seen[w] = true
+		c.warnings.list = append(c.warnings.list, w)
+	}
+}
+
+// Called for every local declared, to know if it's used and if it shadows another one.
+// The names starting with '_' are the ones meant to be unused, and 'self' is declared by the methods.
+func (c *Compiler) declared(name token.Token) {
+	if c.warnings == nil || name.Lexeme == "self" || strings.HasPrefix(name.Lexeme, "_") {
+		return
+	}
+
+	s := site{ file: c.fileData, pos: name.Pos }
+
+	if _, ok := c.warnings.declared[s]; !ok {
+		d := &declaration{ site: s, name: name.Lexeme }
+		c.warnings.declared[s] = d
+		c.warnings.declarations = append(c.warnings.declarations, d)
+	}
+
+	// The new local is already the last one.
+	for compiler := c; compiler != nil; compiler = compiler.enclosing {
+		for i := len(compiler.locals) - 1; i >= 0; i-- {
+			local := compiler.locals[i]
+
+			if local.name.Lexeme != name.Lexeme || local.name.Pos == name.Pos {
+				continue
+			}
+
+			c.warn(name.Pos, len(name.Lexeme), warnShadow, fmt.Sprintf("'%s' shadows the variable declared at line %d.", name.Lexeme, local.name.Pos.Line + 1))
+			return
+		}
+	}
+
+	// Not the builtins, their names are common ones and they aren't declared anywhere.
+	if i := c.findGlobal(c.module, name.Lexeme); i != -1 {
+		c.warn(name.Pos, len(name.Lexeme), warnShadow, fmt.Sprintf("'%s' shadows the global declared at line %d.", name.Lexeme, c.globals[i].name.Pos.Line + 1))
+	}
+}
+
+// The locals declared so far are the parameters, and 'self'.
+func (c *Compiler) declaredParameters() {
+	if c.warnings == nil {
+		return
+	}
+
+	for _, local := range c.locals {
+		if d, ok := c.warnings.declared[site{ file: c.fileData, pos: local.name.Pos }]; ok {
+			d.param = true
+		}
+	}
+}
+
+// Only reading a local uses it, assigning it doesn't.
+func (c *Compiler) used(local Local) {
+	if c.warnings != nil {
+		c.warnings.used[site{ file: c.fileData, pos: local.name.Pos }] = true
+	}
+}
+
+func (c *Compiler) unreachable(after, next ast.Statement) {
+	keyword := ""
+
+	switch after.Data.(type) {
+		case ast.ReturnStatement: keyword = "return"
+		case ast.BreakStatement: keyword = "break"
+		case ast.ContinueStatement: keyword = "continue"
+	}
+
+	c.warn(next.Base.Pos, next.Base.Length, warnUnreachable, fmt.Sprintf("This code never runs, it's after a '%s'.", keyword))
+}
+
+// Prints the warnings of the compilation, and forgets them. Not if it has errors, as the statements with them
+// aren't compiled whole, so the locals they read would be unused. The errors of the checker count too.
+func (c *Compiler) reportWarnings() {
+	if c.warnings == nil {
+		return
+	}
+
+	if c.hadError || c.diagnostics.HadErrors() {
+		c.warnings = newWarnings()
+		return
+	}
+
+	for _, d := range c.warnings.declarations {
+		if c.warnings.used[d.site] {
+			continue
+		}
+
+		if d.param {
+			c.warnings.list = append(c.warnings.list, warning{ kind: warnUnused, message: fmt.Sprintf("The parameter '%s' is never used.", d.name), length: len(d.name), site: d.site })
+		} else {
+			c.warnings.list = append(c.warnings.list, warning{ kind: warnUnused, message: fmt.Sprintf("'%s' is declared but never used.", d.name), length: len(d.name), site: d.site })
+		}
+	}
+
+	// The files in the order they were first warned about, and each one from top to bottom.
+	files := map[*util.FileData]int{}
+
+	for _, w := range c.warnings.list {
+		if _, ok := files[w.file]; !ok {
+			files[w.file] = len(files)
+		}
+	}
+
+	slices.SortStableFunc(c.warnings.list, func(a, b warning) int {
+		if files[a.file] != files[b.file] {
+			return files[a.file] - files[b.file]
+		}
+
+		if a.pos.Line != b.pos.Line {
+			return a.pos.Line - b.pos.Line
+		}
+
+		return a.pos.Col - b.pos.Col
+	})
+
+	for _, w := range c.warnings.list {
+		if suppressed(w) {
+			continue
+		}
+
+		if c.warningsAsErrors {
+			c.diagnostics.Error(w.pos, w.length, w.message, w.file)
+			c.hadError = true
+		} else {
+			util.Warning(c.streams.Stderr, w.pos, w.length, w.message, w.file)
+		}
+	}
+
+	c.warnings = newWarnings()
+}
+
+// Whether the line of the warning has a '// nowarn' comment for it. The comments are the ones the lexer found,
+// so a '// nowarn' in a string doesn't count.
+func suppressed(w warning) bool {
+	_, comment, found := strings.Cut(w.file.Comments[w.pos.Line], "// nowarn")
+
+	if !found {
+		return false
+	}
+
+	// The comment may go on, like with the expectations of the tests.
+	comment, _, _ = strings.Cut(comment, "//")
+	comment, hasKinds := strings.CutPrefix(strings.TrimSpace(comment), ":")
+
+	if !hasKinds {
+		return true
+	}
+
+	for _, kind := range strings.Split(comment, ",") {
+		if strings.TrimSpace(kind) == w.kind {
+			return true
+		}
+	}
+
+	return false
+}
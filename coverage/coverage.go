@@ -0,0 +1,426 @@
+package coverage
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"vm-go/compiler"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/verifier"
+	"vm-go/vm"
+)
+
+// Records which instructions of a program run, to report which lines of its files ran. A line can be covered
+// if it has instructions, and it's covered if any of them ran, as many times as the one that ran the most.
+// The branches are the conditional jumps: each one is taken, or falls through to the next instruction.
+// It's a hook of the VM, so the program runs slower.
+type Coverage struct {
+	chunks map[*byte]*chunk
+	order  []*chunk // the top-level chunk first, then the ones of its functions
+
+	// The chunk of the last instruction, as looking it up is slower, and the conditional jump that just ran.
+	last    *value.Chunk
+	current *chunk
+	jump    *branch
+}
+
+type Format int
+
+const (
+	FormatNone Format = iota
+	FormatSummary
+	FormatAnnotate
+	FormatLCOV
+)
+
+func ParseFormat(name string) (Format, bool) {
+	switch name {
+		case "summary": return FormatSummary, true
+		case "annotate": return FormatAnnotate, true
+		case "lcov": return FormatLCOV, true
+		default: return FormatNone, false
+	}
+}
+
+type chunk struct {
+	chunk *value.Chunk
+	name  string // of its function, "" for the top-level code
+	line  int    // where its function is declared
+
+	// How many times the instruction at each offset ran, and where the instructions start.
+	counts       []int
+	instructions []int
+	branches     map[int]*branch
+}
+
+type branch struct {
+	chunk  *chunk
+	offset int
+	target int
+
+	taken       int
+	fellThrough int
+}
+
+// The chunk must be the one the VM runs.
+func New(top value.Chunk) *Coverage {
+	c := &Coverage{ chunks: map[*byte]*chunk{}, order: []*chunk{} }
+	c.add(&top, "", 0)
+
+	return c
+}
+
+func (c *Coverage) add(code *value.Chunk, name string, line int) {
+	if len(code.Code) == 0 {
+		return
+	}
+
+	ch := &chunk{
+		chunk: code,
+		name: name,
+		line: line,
+		counts: make([]int, len(code.Code)),
+		instructions: []int{},
+		branches: map[int]*branch{},
+	}
+
+	c.chunks[key(code)] = ch
+	c.order = append(c.order, ch)
+
+	// The functions are declared where their closures are created.
+	declarations := map[int]int{}
+
+	for offset := 0; offset < len(code.Code); {
+		length, ok := verifier.InstructionLength(code.Code, offset)
+
+		// The chunk isn't valid, the VM won't run past this.
+		if !ok {
+			break
+		}
+
+		ch.instructions = append(ch.instructions, offset)
+
+		switch code.Code[offset] {
+			case compiler.OP_JUMP_FALSE, compiler.OP_JUMP_TRUE: {
+				jump, _ := util.BytesToInt(code.Code[offset + 1:offset + 5])
+				ch.branches[offset] = &branch{ chunk: ch, offset: offset, target: offset + 5 + jump }
+			}
+
+			case compiler.OP_PUSH_CLOSURE: {
+				index, _ := util.BytesToInt(code.Code[offset + 1:offset + 5])
+				declarations[index] = code.Metadata[offset].Position.Line
+			}
+		}
+
+		offset += length
+	}
+
+	for i, constant := range code.Constants {
+		if fn, ok := constant.(value.ValueFunction); ok {
+			name := "<anonymous>"
+
+			if fn.Name != nil {
+				name = *fn.Name
+			}
+
+			c.add(&fn.Chunk, name, declarations[i])
+		}
+	}
+}
+
+// The closures created from a function, and the copies of a closure, have the same code.
+func key(code *value.Chunk) *byte {
+	if len(code.Code) == 0 {
+		return nil
+	}
+
+	return &code.Code[0]
+}
+
+func (c *Coverage) Step(v *vm.VM) bool {
+	if v.Chunk() != c.last {
+		c.last = v.Chunk()
+		c.current = c.chunks[key(c.last)]
+	}
+
+	offset := v.Offset()
+
+	if jump := c.jump; jump != nil {
+		c.jump = nil
+
+		if jump.chunk == c.current && offset == jump.target {
+			jump.taken++
+		} else if jump.chunk == c.current && offset == jump.offset + 5 {
+			jump.fellThrough++
+		}
+	}
+
+	// Like the expressions of the debugger.
+	if c.current == nil {
+		return true
+	}
+
+	c.current.counts[offset]++
+	c.jump = c.current.branches[offset]
+
+	return true
+}
+
+// ---
+
+// What ran of a file. 'lines' has the lines with instructions, from 0.
+type file struct {
+	data      *util.FileData
+	lines     map[int]int
+	branches  []*branch
+	functions []function
+}
+
+type function struct {
+	name  string
+	line  int
+	calls int
+}
+
+// The files in the order their code appears, so the program's file comes first.
+func (c *Coverage) files() []*file {
+	files := []*file{}
+	byData := map[*util.FileData]*file{}
+
+	get := func(data *util.FileData) *file {
+		f, ok := byData[data]
+
+		if !ok {
+			f = &file{ data: data, lines: map[int]int{} }
+			byData[data] = f
+			files = append(files, f)
+		}
+
+		return f
+	}
+
+	for _, ch := range c.order {
+		for _, offset := range ch.instructions {
+			meta := ch.chunk.Metadata[offset]
+
+			// Like the code of programs loaded from bytecode without their source.
+			if meta.File == nil {
+				continue
+			}
+
+			f := get(meta.File)
+			f.lines[meta.Position.Line] = max(f.lines[meta.Position.Line], ch.counts[offset])
+
+			if b, ok := ch.branches[offset]; ok {
+				f.branches = append(f.branches, b)
+			}
+		}
+
+		// A function is called as many times as its first instruction runs.
+		if meta := ch.chunk.Metadata[0]; ch.name != "" && meta.File != nil {
+			f := get(meta.File)
+			f.functions = append(f.functions, function{ name: ch.name, line: ch.line, calls: ch.counts[0] })
+		}
+	}
+
+	for _, f := range files {
+		slices.SortStableFunc(f.branches, func(a, b *branch) int {
+			return a.line() - b.line()
+		})
+	}
+
+	return files
+}
+
+func (b *branch) line() int {
+	return b.chunk.chunk.Metadata[b.offset].Position.Line
+}
+
+// Each branch counts as two: taken and not taken.
+func (f *file) counts() (lines, coveredLines, branches, coveredBranches int) {
+	for _, hits := range f.lines {
+		lines++
+
+		if hits > 0 {
+			coveredLines++
+		}
+	}
+
+	for _, b := range f.branches {
+		branches += 2
+
+		if b.taken > 0 {
+			coveredBranches++
+		}
+
+		if b.fellThrough > 0 {
+			coveredBranches++
+		}
+	}
+
+	return
+}
+
+// ---
+
+func (c *Coverage) Write(w io.Writer, format Format) {
+	switch format {
+		case FormatSummary:
+			c.writeSummary(w)
+
+		case FormatAnnotate: {
+			c.writeSummary(w)
+			c.writeAnnotated(w)
+		}
+
+		case FormatLCOV:
+			c.writeLCOV(w)
+	}
+}
+
+func (c *Coverage) writeSummary(w io.Writer) {
+	fmt.Fprintln(w, "Coverage:")
+	totalLines, totalCovered, totalBranches, totalTaken := 0, 0, 0, 0
+
+	for _, f := range c.files() {
+		lines, covered, branches, taken := f.counts()
+		fmt.Fprintf(w, "  %s: %s\n", f.data.Name, describe(lines, covered, branches, taken))
+
+		totalLines += lines
+		totalCovered += covered
+		totalBranches += branches
+		totalTaken += taken
+	}
+
+	fmt.Fprintf(w, "  total: %s\n", describe(totalLines, totalCovered, totalBranches, totalTaken))
+}
+
+func describe(lines, covered, branches, taken int) string {
+	text := fmt.Sprintf("%d of %d lines (%s)", covered, lines, percent(covered, lines))
+
+	if branches > 0 {
+		text += fmt.Sprintf(", %d of %d branches (%s)", taken, branches, percent(taken, branches))
+	}
+
+	return text
+}
+
+func percent(n, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+
+	return fmt.Sprintf("%.1f%%", float64(n) * 100 / float64(total))
+}
+
+// Every line of each file, with how many times it ran, '#####' if it never did, and nothing if it has no code.
+func (c *Coverage) writeAnnotated(w io.Writer) {
+	for _, f := range c.files() {
+		fmt.Fprintf(w, "\n%s:\n", f.data.Name)
+
+		// Programs loaded from bytecode may not have their source.
+		if len(f.data.Lines) == 0 {
+			fmt.Fprintln(w, "  (the source isn't available)")
+			continue
+		}
+
+		for i, source := range f.data.Lines {
+			count := ""
+
+			if hits, ok := f.lines[i]; ok && hits > 0 {
+				count = fmt.Sprint(hits)
+			} else if ok {
+				count = "#####"
+			}
+
+			fmt.Fprintf(w, "%8s | %4d | %s\n", count, i + 1, source)
+		}
+	}
+}
+
+// The tracefile format of 'lcov' and 'genhtml', which most coverage tools read.
+func (c *Coverage) writeLCOV(w io.Writer) {
+	for _, f := range c.files() {
+		fmt.Fprintln(w, "TN:")
+		fmt.Fprintf(w, "SF:%s\n", f.data.Path)
+
+		names := functionNames(f.functions)
+		calledFunctions := 0
+
+		for i, fn := range f.functions {
+			fmt.Fprintf(w, "FN:%d,%s\n", fn.line + 1, names[i])
+		}
+
+		for i, fn := range f.functions {
+			fmt.Fprintf(w, "FNDA:%d,%s\n", fn.calls, names[i])
+
+			if fn.calls > 0 {
+				calledFunctions++
+			}
+		}
+
+		fmt.Fprintf(w, "FNF:%d\nFNH:%d\n", len(f.functions), calledFunctions)
+
+		// The branches of each line are numbered in order, as blocks of two: taken and not taken.
+		for i, b := range f.branches {
+			block := 0
+
+			for j := i - 1; j >= 0 && f.branches[j].line() == b.line(); j-- {
+				block++
+			}
+
+			fmt.Fprintf(w, "BRDA:%d,%d,0,%s\n", b.line() + 1, block, branchHits(b, b.taken))
+			fmt.Fprintf(w, "BRDA:%d,%d,1,%s\n", b.line() + 1, block, branchHits(b, b.fellThrough))
+		}
+
+		lines, covered, branches, taken := f.counts()
+		fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", branches, taken)
+
+		numbers := []int{}
+
+		for line := range f.lines {
+			numbers = append(numbers, line)
+		}
+
+		slices.Sort(numbers)
+
+		for _, line := range numbers {
+			fmt.Fprintf(w, "DA:%d,%d\n", line + 1, f.lines[line])
+		}
+
+		fmt.Fprintf(w, "LF:%d\nLH:%d\n", lines, covered)
+		fmt.Fprintln(w, "end_of_record")
+	}
+}
+
+// The names must be unique in a file, so the anonymous functions, and the methods with the same name
+// in different records, have their line too.
+func functionNames(functions []function) []string {
+	seen := map[string]int{}
+
+	for _, fn := range functions {
+		seen[fn.name]++
+	}
+
+	names := make([]string, len(functions))
+
+	for i, fn := range functions {
+		names[i] = fn.name
+
+		if seen[fn.name] > 1 || fn.name == "<anonymous>" {
+			names[i] = fmt.Sprintf("%s:%d", fn.name, fn.line + 1)
+		}
+	}
+
+	return names
+}
+
+// '-' if the jump itself never ran.
+func branchHits(b *branch, hits int) string {
+	if b.chunk.counts[b.offset] == 0 {
+		return "-"
+	}
+
+	return fmt.Sprint(hits)
+}
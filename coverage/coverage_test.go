@@ -0,0 +1,107 @@
+package coverage
+
+import (
+	"strings"
+	"testing"
+	"vm-go/checker"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/vm"
+)
+
+const program = `fn sign(n) {
+    if n > 0 {
+        return 1;
+    } else if n < 0 {
+        return -1;
+    }
+
+    return 0;
+}
+
+fn unused() {
+    println("never");
+}
+
+fn main() {
+    sign(3);
+    sign(0);
+}`
+
+func TestCoverage(t *testing.T) {
+	cover := run(t, program)
+	files := cover.files()
+
+	if len(files) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(files))
+	}
+
+	f := files[0]
+
+	// The lines are from 0.
+	expected := map[int]int{ 1: 2, 2: 1, 4: 0, 7: 1, 11: 0, 16: 1 }
+
+	for line, hits := range expected {
+		if f.lines[line] != hits {
+			t.Errorf("expected line %d to run %d times, got %d", line + 1, hits, f.lines[line])
+		}
+	}
+
+	if _, ok := f.lines[5]; ok {
+		t.Error("expected line 6 to have no code")
+	}
+
+	// 'n > 0' is taken once and falls through once, 'n < 0' only falls through.
+	if lines, covered, branches, taken := f.counts(); covered != lines - 2 || branches != 4 || taken != 3 {
+		t.Errorf("expected all the lines but 2 and 3 of 4 branches, got %d of %d lines and %d of %d branches", covered, lines, taken, branches)
+	}
+
+	lcov := strings.Builder{}
+	cover.Write(&lcov, FormatLCOV)
+
+	for _, line := range []string{ "SF:sign.vm", "FN:11,unused", "FNDA:0,unused", "FNDA:2,sign", "BRDA:4,0,1,0", "DA:5,0", "end_of_record" } {
+		if !strings.Contains(lcov.String(), line + "\n") {
+			t.Errorf("expected '%s' in the tracefile:\n%s", line, lcov.String())
+		}
+	}
+
+	annotated := strings.Builder{}
+	cover.Write(&annotated, FormatAnnotate)
+
+	if !strings.Contains(annotated.String(), "   ##### |   12 |     println(\"never\");") {
+		t.Errorf("expected the line that never ran to be marked:\n%s", annotated.String())
+	}
+}
+
+func run(t *testing.T, source string) *Coverage {
+	streams := util.NewStreams(&strings.Builder{}, &strings.Builder{}, strings.NewReader(""))
+	fileData := util.FileData{ Name: "sign.vm", Path: "sign.vm", Lines: strings.Split(source, "\n") }
+	registry := vm.DefaultRegistry(streams)
+
+	tokens, hadError := lexer.NewLexer(source, &fileData, streams).Lex()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	ast, hadError := parser.NewParser(tokens, &fileData, streams).Parse()
+
+	if hadError || checker.NewChecker(ast, &fileData, registry, streams).Check() {
+		t.Fatal("the program has errors")
+	}
+
+	chunk, hadError := compiler.NewCompiler(ast, &fileData, registry, streams).Compile()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	cover := New(chunk)
+	v := vm.NewVM(chunk, &fileData, registry, streams)
+	v.AddHook(cover)
+	v.Run()
+
+	return cover
+}
@@ -0,0 +1,464 @@
+package debugger
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"vm-go/ast"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/run"
+	"vm-go/util"
+	"vm-go/vm"
+)
+
+// Runs a program under the control of the user, who can pause it at the lines with a breakpoint, run it
+// line by line, look at the variables of its calls and evaluate expressions where it's paused.
+// The program starts paused at its first line. The debugger is a hook of the VM, which reads the commands
+// while the program waits in the middle of its run.
+//
+// The program is compiled without the optimizations, so every line keeps its code.
+type Debugger struct {
+	compiler *compiler.Compiler
+	vm       *vm.VM
+	fileData *util.FileData
+
+	breakpoints map[breakpoint]bool
+
+	// How the program runs until the next pause, and where the command that resumed it was given.
+	mode mode
+	from location
+
+	// Where the last instruction was, to know when the program gets to another line.
+	last location
+
+	streams *util.Streams
+}
+
+type mode int
+
+const (
+	modeContinue mode = iota
+	modeStep
+	modeNext
+	modeOut
+)
+
+type location struct {
+	file  *util.FileData
+	line  int
+	depth int
+}
+
+type breakpoint struct {
+	file string
+	line int // from 1, as the user sees them
+}
+
+const prompt = "(debug) "
+
+const help = `Commands:
+  break <line>, b          pauses at a line, or at a line of a module with <file>:<line>
+  delete <line>, d         removes a breakpoint
+  breakpoints              lists the breakpoints
+  continue, c              runs until a breakpoint
+  step, s                  runs until the next line, going into the calls
+  next, n                  runs until the next line of this call, over the calls
+  out, o                   runs until this call returns
+  where, w                 shows the calls, the innermost first
+  locals [n], l            shows the locals and upvalues of a call, the innermost one by default
+  print <expression>, p    evaluates an expression where the program is paused
+  list                     shows the lines around the current one
+  quit, q                  stops the program
+  help, h                  shows the commands`
+
+// Returns nil if the program doesn't compile, after printing its errors.
+func New(source, fileName string, options run.Options, streams *util.Streams) *Debugger {
+	fileData := util.FileData{
+		Name: util.GetFileName(fileName),
+		Path: fileName,
+		Lines: strings.Split(source, "\n"),
+	}
+
+	registry := vm.DefaultRegistry(streams)
+	registry.SetSandbox(options.Sandbox)
+	compiler_ := compiler.NewCompiler([]ast.Statement{}, &fileData, registry, streams)
+	compiler_.SetOptimize(false)
+	compiler_.SetWarningsAsErrors(options.WarningsAsErrors)
+	compiler_.SetDiagnostics(util.NewDiagnostics(streams.Stderr, options.MaxErrors))
+	chunk, hadError := compiler_.CompileSource(source, &fileData, nil, compiler.ModeProgram)
+
+	if hadError {
+		return nil
+	}
+
+	d := &Debugger{
+		compiler: compiler_,
+		vm: vm.NewVM(chunk, &fileData, registry, streams),
+		fileData: &fileData,
+
+		breakpoints: map[breakpoint]bool{},
+		mode: modeStep,
+
+		streams: streams,
+	}
+
+	d.vm.SetLimits(options.Limits)
+	d.vm.AddHook(d)
+
+	return d
+}
+
+func (d *Debugger) Start() {
+	fmt.Fprintln(d.streams.Stdout, "Type 'help' for the commands.")
+
+	switch d.vm.Run() {
+		case vm.STATUS_OK:
+			fmt.Fprintln(d.streams.Stdout, "The program finished.")
+
+		case vm.STATUS_STOPPED:
+			fmt.Fprintln(d.streams.Stdout, "The program was stopped.")
+	}
+}
+
+// Called before each instruction. The program pauses when it gets to a new line where the last command
+// said to stop, or that has a breakpoint.
+func (d *Debugger) Step(v *vm.VM) bool {
+	meta := v.Position()
+
+	// The instructions the compiler adds have no position.
+	if meta.File == nil {
+		return true
+	}
+
+	here := location{ file: meta.File, line: meta.Position.Line, depth: v.Depth() }
+
+	if here == d.last {
+		return true
+	}
+
+	// Returning to the line of the call doesn't hit its breakpoint again.
+	returned := here.depth < d.last.depth
+	d.last = here
+	hit := !returned && d.breakpoints[breakpoint{ file: here.file.Name, line: here.line + 1 }]
+
+	if !hit && !d.stepEnds(here) {
+		return true
+	}
+
+	if hit {
+		d.printLocation("Breakpoint at")
+	} else {
+		d.printLocation("Paused at")
+	}
+
+	return d.prompt()
+}
+
+func (d *Debugger) stepEnds(here location) bool {
+	switch d.mode {
+		case modeStep:
+			return true
+
+		// The calls made from the line run until they return to it.
+		case modeNext:
+			return here.depth < d.from.depth || (here.depth == d.from.depth && here != d.from)
+
+		case modeOut:
+			return here.depth < d.from.depth
+
+		default:
+			return false
+	}
+}
+
+// Reads commands until one of them resumes the program. Returns false to stop it, also at the end of the input.
+func (d *Debugger) prompt() bool {
+	for {
+		fmt.Fprint(d.streams.Stdout, prompt)
+		line, err := d.streams.Stdin.ReadString('\n')
+
+		if err != nil && line == "" {
+			fmt.Fprintln(d.streams.Stdout)
+			return false
+		}
+
+		command, argument, _ := strings.Cut(strings.TrimSpace(line), " ")
+		argument = strings.TrimSpace(argument)
+
+		switch command {
+			case "":
+				continue
+
+			case "continue", "c":
+				return d.resume(modeContinue)
+
+			case "step", "s":
+				return d.resume(modeStep)
+
+			case "next", "n":
+				return d.resume(modeNext)
+
+			case "out", "o":
+				return d.resume(modeOut)
+
+			case "break", "b":
+				d.setBreakpoint(argument, true)
+
+			case "delete", "d":
+				d.setBreakpoint(argument, false)
+
+			case "breakpoints":
+				d.printBreakpoints()
+
+			case "where", "w":
+				d.printFrames()
+
+			case "locals", "l":
+				d.printLocals(argument)
+
+			case "print", "p":
+				d.evaluate(argument)
+
+			case "list":
+				d.printSource()
+
+			case "quit", "q":
+				return false
+
+			case "help", "h":
+				fmt.Fprintln(d.streams.Stdout, help)
+
+			default:
+				fmt.Fprintf(d.streams.Stdout, "Unknown command '%s', 'help' lists them.\n", command)
+		}
+	}
+}
+
+func (d *Debugger) resume(mode mode) bool {
+	d.mode = mode
+	d.from = d.last
+
+	return true
+}
+
+// ---
+
+// Breakpoints are given as '<line>', for the program's file, or '<file>:<line>'.
+func (d *Debugger) setBreakpoint(argument string, set bool) {
+	file, lineText, found := strings.Cut(argument, ":")
+
+	if !found {
+		file, lineText = d.fileData.Name, argument
+	}
+
+	line, err := strconv.Atoi(lineText)
+
+	if err != nil || line <= 0 {
+		fmt.Fprintf(d.streams.Stdout, "Invalid line: '%s'.\n", argument)
+		return
+	}
+
+	if file == d.fileData.Name && line > len(d.fileData.Lines) {
+		fmt.Fprintf(d.streams.Stdout, "'%s' only has %d lines.\n", file, len(d.fileData.Lines))
+		return
+	}
+
+	point := breakpoint{ file: file, line: line }
+
+	if !set {
+		if !d.breakpoints[point] {
+			fmt.Fprintf(d.streams.Stdout, "There's no breakpoint at %s:%d.\n", file, line)
+			return
+		}
+
+		delete(d.breakpoints, point)
+		fmt.Fprintf(d.streams.Stdout, "Removed the breakpoint at %s:%d.\n", file, line)
+		return
+	}
+
+	d.breakpoints[point] = true
+	fmt.Fprintf(d.streams.Stdout, "Breakpoint at %s:%d.\n", file, line)
+}
+
+func (d *Debugger) printBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.streams.Stdout, "There are no breakpoints.")
+		return
+	}
+
+	points := []string{}
+
+	for point := range d.breakpoints {
+		points = append(points, fmt.Sprintf("%s:%d", point.file, point.line))
+	}
+
+	slices.Sort(points)
+
+	for _, point := range points {
+		fmt.Fprintf(d.streams.Stdout, "  %s\n", point)
+	}
+}
+
+// ---
+
+func (d *Debugger) printLocation(prefix string) {
+	frames := d.vm.Frames()
+	meta := d.vm.Position()
+
+	fmt.Fprintf(d.streams.Stdout, "%s %s:%d, in %s\n", prefix, meta.File.Name, meta.Position.Line + 1, frames[len(frames) - 1].Name)
+	d.printLine(meta.File, meta.Position.Line, false)
+}
+
+// Shows the lines around the current one, which is marked.
+func (d *Debugger) printSource() {
+	meta := d.vm.Position()
+
+	if meta.File == nil {
+		return
+	}
+
+	for line := max(meta.Position.Line - 3, 0); line <= meta.Position.Line + 3; line++ {
+		d.printLine(meta.File, line, line == meta.Position.Line)
+	}
+}
+
+// Programs that import modules loaded from elsewhere may not have all their lines.
+func (d *Debugger) printLine(file *util.FileData, line int, current bool) {
+	if line < 0 || line >= len(file.Lines) {
+		return
+	}
+
+	marker := "  "
+
+	if current {
+		marker = "->"
+	}
+
+	fmt.Fprintf(d.streams.Stdout, "%s %4d | %s\n", marker, line + 1, file.Lines[line])
+}
+
+// The innermost call is #0.
+func (d *Debugger) printFrames() {
+	frames := d.vm.Frames()
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		frame := frames[i]
+		position := ""
+
+		if frame.Position.File != nil {
+			position = fmt.Sprintf(" at %s:%d", frame.Position.File.Name, frame.Position.Position.Line + 1)
+		}
+
+		fmt.Fprintf(d.streams.Stdout, "#%d %s%s\n", len(frames) - 1 - i, frame.Name, position)
+	}
+}
+
+func (d *Debugger) printLocals(argument string) {
+	frames := d.vm.Frames()
+	index := 0
+
+	if argument != "" {
+		n, err := strconv.Atoi(argument)
+
+		if err != nil || n < 0 || n >= len(frames) {
+			fmt.Fprintf(d.streams.Stdout, "Invalid call: '%s', 'where' lists them.\n", argument)
+			return
+		}
+
+		index = n
+	}
+
+	frame := frames[len(frames) - 1 - index]
+
+	if len(frame.Locals) == 0 && len(frame.Upvalues) == 0 {
+		fmt.Fprintf(d.streams.Stdout, "%s has no locals.\n", frame.Name)
+		return
+	}
+
+	printVariables(d.streams, "Locals", frame.Locals)
+	printVariables(d.streams, "Upvalues", frame.Upvalues)
+}
+
+func printVariables(streams *util.Streams, title string, variables []vm.Variable) {
+	if len(variables) == 0 {
+		return
+	}
+
+	fmt.Fprintf(streams.Stdout, "%s:\n", title)
+
+	for i, variable := range variables {
+		name := variable.Name
+
+		if name == "" {
+			name = fmt.Sprintf("<slot %d>", i)
+		}
+
+		fmt.Fprintf(streams.Stdout, "  %s = %s\n", name, variable.Value.String())
+	}
+}
+
+// ---
+
+// Compiles the expression with the compiler of the program, so it sees its globals, and runs it in the innermost call.
+func (d *Debugger) evaluate(source string) {
+	if source == "" {
+		fmt.Fprintln(d.streams.Stdout, "Expected an expression.")
+		return
+	}
+
+	fileData := util.FileData{ Name: "<debug>", Path: "<debug>", Lines: []string{ source } }
+	tokens, hadError := lexer.NewLexer(source + ";", &fileData, d.streams).Lex()
+
+	if hadError {
+		return
+	}
+
+	stmts, hadError := parser.NewParser(tokens, &fileData, d.streams).ParseInput()
+
+	if hadError {
+		return
+	}
+
+	var expr ast.Expression
+
+	if len(stmts) == 1 {
+		if stmt, ok := stmts[0].Data.(ast.ExprStatement); ok {
+			expr = stmt.Expr
+		}
+	}
+
+	if expr.Data == nil {
+		fmt.Fprintln(d.streams.Stdout, "Expected an expression.")
+		return
+	}
+
+	frames := d.vm.Frames()
+	frame := frames[len(frames) - 1]
+	scope := compiler.Scope{ Locals: []string{}, Upvalues: []string{}, Globals: d.vm.GlobalCount(), File: d.vm.Position().File }
+
+	for _, local := range frame.Locals {
+		scope.Locals = append(scope.Locals, local.Name)
+	}
+
+	for _, upvalue := range frame.Upvalues {
+		scope.Upvalues = append(scope.Upvalues, upvalue.Name)
+	}
+
+	chunk, hadError := d.compiler.CompileExpression(expr, &fileData, scope)
+
+	if hadError {
+		return
+	}
+
+	result, status := d.vm.Evaluate(chunk)
+
+	if status != vm.STATUS_OK {
+		fmt.Fprintf(d.streams.Stdout, "[-] Runtime error: %s\n", d.vm.ErrorMessage())
+		return
+	}
+
+	fmt.Fprintln(d.streams.Stdout, result.String())
+}
@@ -0,0 +1,131 @@
+package debugger
+
+import (
+	"strings"
+	"testing"
+	"vm-go/run"
+	"vm-go/util"
+)
+
+const program = `fn add(a, b) {
+    var sum = a + b;
+    return sum;
+}
+
+fn makeCounter() {
+    var count = 0;
+
+    fn next() {
+        count = count + 1;
+        return count;
+    }
+
+    return next;
+}
+
+fn main() {
+    var x = 1;
+    var y = add(x, 2);
+    var counter = makeCounter();
+    counter();
+    counter();
+    println(y);
+}`
+
+// Each session runs the program with the commands as its input, and its output must have the lines in that order.
+func TestSessions(t *testing.T) {
+	sessions := []struct {
+		name     string
+		commands string
+		expected []string
+	}{
+		{
+			name: "breakpoint",
+			commands: "b 10\nc\nlocals\nwhere\nc\nlocals\nd 10\nc\n",
+			expected: []string{
+				"Paused at main.vm:1, in <top-level>",
+				"Breakpoint at main.vm:10.",
+				"Breakpoint at main.vm:10, in next",
+				"Upvalues:", "  count = 0",
+				"#0 next at main.vm:10", "#1 main at main.vm:21", "#2 <top-level>",
+				"  count = 1",
+				"Removed the breakpoint at main.vm:10.",
+				"3",
+				"The program finished.",
+			},
+		},
+		{
+			name: "stepping",
+			commands: "b 19\nc\ns\nn\nlocals\nout\nn\nlocals\nq\n",
+			expected: []string{
+				"Breakpoint at main.vm:19, in main",
+				"Paused at main.vm:2, in add",
+				"Paused at main.vm:3, in add",
+				"  a = 1", "  b = 2", "  sum = 3",
+				"Paused at main.vm:19, in main",
+				"Paused at main.vm:20, in main",
+				"  x = 1", "  y = 3",
+				"The program was stopped.",
+			},
+		},
+		{
+			name: "evaluate",
+			commands: "b 20\nc\np x + y\np x = 10\np ((z) -> z + x)(5)\np add(x, y)\np missing\np 1 / 0\nc\n",
+			expected: []string{
+				"Breakpoint at main.vm:20, in main",
+				"4",
+				"10",
+				"15",
+				"13",
+				"[-] Runtime error: Cannot divide by zero.",
+				"3",
+				"The program finished.",
+			},
+		},
+		{
+			// Paused before the declarations of the globals.
+			name: "early",
+			commands: "p println\np main\nc\n",
+			expected: []string{
+				"Paused at main.vm:1, in <top-level>",
+				"<native fn>",
+				"The program finished.",
+			},
+		},
+	}
+
+	for _, session := range sessions {
+		stdout := strings.Builder{}
+		stderr := strings.Builder{}
+		streams := util.NewStreams(&stdout, &stderr, strings.NewReader(session.commands))
+
+		d := New(program, "main.vm", run.Options{}, streams)
+
+		if d == nil {
+			t.Fatalf("%s: the program has errors:\n%s", session.name, stderr.String())
+		}
+
+		d.Start()
+		output := stdout.String()
+		rest := output
+
+		for _, line := range session.expected {
+			index := strings.Index(rest, line)
+
+			if index < 0 {
+				t.Errorf("%s: expected '%s' in the output:\n%s", session.name, line, output)
+				break
+			}
+
+			rest = rest[index + len(line):]
+		}
+
+		if session.name == "evaluate" && !strings.Contains(stderr.String(), "'missing' doesn't exist") {
+			t.Errorf("%s: expected a compile error for 'missing', got:\n%s", session.name, stderr.String())
+		}
+
+		if session.name == "early" && !strings.Contains(stderr.String(), "'main' isn't defined yet") {
+			t.Errorf("%s: expected a compile error for 'main', got:\n%s", session.name, stderr.String())
+		}
+	}
+}
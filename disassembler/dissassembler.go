@@ -2,6 +2,8 @@ package disassembler
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strconv"
 	"strings"
 	"vm-go/compiler"
@@ -14,6 +16,7 @@ type Disassembler struct {
 	ip int
 
 	fileData *util.FileData
+	out io.Writer
 }
 
 func NewDisassembler(chunk value.Chunk, fileData *util.FileData) *Disassembler {
@@ -22,9 +25,14 @@ func NewDisassembler(chunk value.Chunk, fileData *util.FileData) *Disassembler {
 		ip: 0,
 
 		fileData: fileData,
+		out: os.Stdout,
 	}
 }
 
+func (d *Disassembler) SetOutput(out io.Writer) {
+	d.out = out
+}
+
 const MAX_INSTRUCTION_LENGTH = 16
 
 func (d *Disassembler) Disassemble() {
@@ -32,17 +40,17 @@ func (d *Disassembler) Disassemble() {
 }
 
 func (d *Disassembler) disassemble(name string) {
-	fmt.Println(util.Center(fmt.Sprintf("- %s -", name), 66, " ")) // 66 = len("--------|-----------|--------|------------------|--------|--------")
+	fmt.Fprintln(d.out, util.Center(fmt.Sprintf("- %s -", name), 66, " ")) // 66 = len("--------|-----------|--------|------------------|--------|--------")
 
 	if len(d.chunk.Code) == 0 {
-		fmt.Println(util.Center("function is empty.", 66, " "))
-		fmt.Println()
+		fmt.Fprintln(d.out, util.Center("function is empty.", 66, " "))
+		fmt.Fprintln(d.out)
 		return
 	}
 
-	fmt.Println("--------|-----------|--------|------------------|--------|--------")
-	fmt.Println(" offset | position  | length | instruction      | index  | result")
-	fmt.Println("--------|-----------|--------|------------------|--------|--------")
+	fmt.Fprintln(d.out, "--------|-----------|--------|------------------|--------|--------")
+	fmt.Fprintln(d.out, " offset | position  | length | instruction      | index  | result")
+	fmt.Fprintln(d.out, "--------|-----------|--------|------------------|--------|--------")
 
 	for i := 0; !d.isAtEnd(); i++ {
 		ip := d.ip
@@ -51,12 +59,13 @@ func (d *Disassembler) disassemble(name string) {
 		d.PrintInstruction(inst, ip, i)
 	}
 
-	fmt.Println()
+	fmt.Fprintln(d.out)
 
 	for _, c := range d.chunk.Constants {
 		switch fn := c.(type) {
 			case value.ValueFunction: {
 				fnDiss := NewDisassembler(fn.Chunk, d.fileData)
+				fnDiss.SetOutput(d.out)
 				fnName := fmt.Sprintf("anonymous function, in %s", name)
 
 				if fn.Name != nil {
@@ -68,8 +77,14 @@ func (d *Disassembler) disassemble(name string) {
 		}
 	}
 }
+// Prints the instruction at the offset, in the same format as the listing of its chunk.
+func (d *Disassembler) PrintInstructionAt(ip int) {
+	d.ip = ip + 1
+	d.PrintInstruction(d.chunk.Code[ip], ip, 0)
+}
+
 func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
-	fmt.Printf(
+	fmt.Fprintf(d.out,
 		" %s | %s %s | %s | %s | ",
 		util.PadLeft(strconv.Itoa(ip), 6, " "),
 
@@ -78,7 +93,7 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 
 		util.PadRight(strconv.Itoa(d.chunk.Metadata[ip].Length), 6, " "),
 
-		util.PadRight(getInstructionName(inst), MAX_INSTRUCTION_LENGTH, " "),
+		util.PadRight(InstructionName(inst), MAX_INSTRUCTION_LENGTH, " "),
 	)
 
 	switch inst {
@@ -95,7 +110,7 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			}
 
 			// TODO: print the type as well
-			fmt.Printf(
+			fmt.Fprintf(d.out,
 				"%s | %s: %s\n",
 				util.PadRight(strconv.Itoa(index), 6, " "),
 				str,
@@ -103,16 +118,21 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			)
 		}
 
+        // inst index cache
         case compiler.OP_GET_PROPERTY, compiler.OP_SET_PROPERTY: {
 			index, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
 
+			cache, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
+			d.ip += 4
+
 			str := d.chunk.Constants[index].String()
 
-			fmt.Printf(
-				"%s | %s\n",
+			fmt.Fprintf(d.out,
+				"%s | %s (cache %d)\n",
 				util.PadRight(strconv.Itoa(index), 6, " "),
 				str,
+				cache,
 			)
         }
 
@@ -125,7 +145,7 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			d.ip += 4
 
 			// TODO: print the type as well
-			fmt.Printf(
+			fmt.Fprintf(d.out,
 				"%s | %s\n",
 				util.PadRight(strconv.Itoa(index), 6, " "),
 				d.chunk.Constants[index].String(),
@@ -146,7 +166,7 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 					text = "upvalue"
 				}
 
-				fmt.Printf(
+				fmt.Fprintf(d.out,
 					" %s | %s %s | %s | |%s | %s | %s\n",
 					util.PadLeft(strconv.Itoa(ip + 5 * (i + 1)), 6, " "),
 			
@@ -168,17 +188,18 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			compiler.OP_GET_LOCAL, compiler.OP_SET_LOCAL,
 			compiler.OP_GET_UPVALUE, compiler.OP_SET_UPVALUE,
 			compiler.OP_GET_GLOBAL, compiler.OP_SET_GLOBAL,
-			compiler.OP_CALL, compiler.OP_APPEND_METHODS: {
+			compiler.OP_CALL, compiler.OP_APPEND_METHODS, compiler.OP_MAKE_LIST, compiler.OP_MAKE_MAP,
+			compiler.OP_CONCAT: {
 			count, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
 
-			fmt.Printf(
+			fmt.Fprintf(d.out,
 				"%s |\n",
 				util.PadRight(strconv.Itoa(count), 6, " "),
 			)
 		}
 
-		// inst [int] [int]
+		// inst [int] [int] cache
 		case compiler.OP_CALL_PROPERTY: {
 			index, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
@@ -186,19 +207,24 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			arity, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
 
-			fmt.Printf(
-				"%s | %s\n",
+			cache, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
+			d.ip += 4
+
+			fmt.Fprintf(d.out,
+				"%s | %s (cache %d)\n",
 				util.PadRight(strconv.Itoa(index), 6, " "),
 				util.PadRight(strconv.Itoa(arity), 6, " "),
+				cache,
 			)
 		}
 
 		// inst amount result (add)
-		case compiler.OP_JUMP, compiler.OP_JUMP_TRUE, compiler.OP_JUMP_FALSE, compiler.OP_JUMP_HAS_NO_NEXT: {
+		case compiler.OP_JUMP, compiler.OP_JUMP_TRUE, compiler.OP_JUMP_FALSE, compiler.OP_JUMP_HAS_NO_NEXT,
+			compiler.OP_PUSH_HANDLER: {
 			count, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
 
-			fmt.Printf(
+			fmt.Fprintf(d.out,
 				"%s | %d\n",
 				util.PadRight(strconv.Itoa(count), 6, " "),
 				d.ip + count,
@@ -210,7 +236,7 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 			count, _ := util.BytesToInt(d.chunk.Code[d.ip : d.ip+4])
 			d.ip += 4
 
-			fmt.Printf(
+			fmt.Fprintf(d.out,
 				"%s | %d\n",
 				util.PadRight(strconv.Itoa(count), 6, " "),
 				d.ip - count,
@@ -220,6 +246,6 @@ func (d *Disassembler) PrintInstruction(inst byte, ip int, i int) {
 		// inst
 		default:
 			// add the separator between index and constant columns
-			fmt.Println("       |")
+			fmt.Fprintln(d.out, "       |")
 	}
 }
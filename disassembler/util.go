@@ -15,7 +15,8 @@ func (d *Disassembler) isAtEnd() bool {
 
 // ---
 
-func getInstructionName(inst byte) string {
+// The name of an opcode, as the disassembler and the tools print it.
+func InstructionName(inst byte) string {
 	switch inst {
 		case compiler.OP_PUSH_CONST:
 			return "PUSH_CONST"
@@ -28,6 +29,8 @@ func getInstructionName(inst byte) string {
 
 		case compiler.OP_ADD:
 			return "ADD"
+		case compiler.OP_CONCAT:
+			return "CONCAT"
 		case compiler.OP_SUB:
 			return "SUB"
 		case compiler.OP_MUL:
@@ -62,6 +65,11 @@ func getInstructionName(inst byte) string {
 		case compiler.OP_SET_PROPERTY:
 			return "SET_PROPERTY"
 
+		case compiler.OP_GET_INDEX:
+			return "GET_INDEX"
+		case compiler.OP_SET_INDEX:
+			return "SET_INDEX"
+
 		case compiler.OP_POP:
 			return "POP"
 		case compiler.OP_POP_LOCAL:
@@ -116,6 +124,11 @@ func getInstructionName(inst byte) string {
 		case compiler.OP_RETURN:
 			return "RETURN"
 
+		case compiler.OP_PUSH_HANDLER:
+			return "PUSH_HANDLER"
+		case compiler.OP_POP_HANDLER:
+			return "POP_HANDLER"
+
 		case compiler.OP_PUSH_TRUE:
 			return "PUSH_TRUE"
 		case compiler.OP_PUSH_FALSE:
@@ -132,6 +145,10 @@ func getInstructionName(inst byte) string {
 			return "MAKE_INCL_RANGE"
 		case compiler.OP_MAKE_ITERATOR:
 			return "MAKE_ITERATOR"
+		case compiler.OP_MAKE_LIST:
+			return "MAKE_LIST"
+		case compiler.OP_MAKE_MAP:
+			return "MAKE_MAP"
 
         case compiler.OP_GET_NEXT:
             return "GET_NEXT"
@@ -0,0 +1,213 @@
+package interpreter
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"vm-go/value"
+)
+
+// Converts a value of the language to Go:
+// num -> float64, str -> string, bool -> bool, nil and void -> nil,
+// list -> []any and map -> map[any]any, converting the elements too.
+// Other values, like functions and instances, are returned unchanged, so they can be passed back.
+func ToGo(v value.Value) any {
+	switch x := v.(type) {
+		case value.ValueNumber:
+			return x.Value
+
+		case value.ValueString:
+			return x.Value
+
+		case value.ValueBool:
+			return x.Value
+
+		case value.ValueNil, value.ValueVoid:
+			return nil
+
+		case value.ValueList: {
+			list := make([]any, 0, len(*x.Elements))
+
+			for _, element := range *x.Elements {
+				list = append(list, ToGo(element))
+			}
+
+			return list
+		}
+
+		case value.ValueMap: {
+			m := make(map[any]any, len(x.Entries.Keys))
+
+			for _, key := range x.Entries.Keys {
+				m[ToGo(key)] = ToGo(x.Entries.Values[key])
+			}
+
+			return m
+		}
+
+		default:
+			return v
+	}
+}
+
+// Converts a Go value to the language, the inverse of 'ToGo'.
+// All the integer and float types are converted to numbers, and slices and maps are converted recursively.
+// The keys of the maps are sorted, so the converted map has the same order every time.
+func FromGo(x any) (value.Value, error) {
+	switch v := x.(type) {
+		case nil:
+			return value.ValueNil{}, nil
+
+		case value.Value:
+			return v, nil
+
+		case bool:
+			return value.ValueBool{ Value: v }, nil
+
+		case string:
+			return value.ValueString{ Value: v }, nil
+
+		case int:
+			return number(v), nil
+		case int8:
+			return number(v), nil
+		case int16:
+			return number(v), nil
+		case int32:
+			return number(v), nil
+		case int64:
+			return number(v), nil
+		case uint:
+			return number(v), nil
+		case uint8:
+			return number(v), nil
+		case uint16:
+			return number(v), nil
+		case uint32:
+			return number(v), nil
+		case uint64:
+			return number(v), nil
+		case float32:
+			return number(v), nil
+		case float64:
+			return number(v), nil
+
+		case []any: {
+			elements := make([]value.Value, 0, len(v))
+
+			for _, element := range v {
+				converted, err := FromGo(element)
+
+				if err != nil {
+					return nil, err
+				}
+
+				elements = append(elements, converted)
+			}
+
+			return value.NewValueList(elements), nil
+		}
+
+		case []string: {
+			elements := make([]value.Value, 0, len(v))
+
+			for _, element := range v {
+				elements = append(elements, value.ValueString{ Value: element })
+			}
+
+			return value.NewValueList(elements), nil
+		}
+
+		case map[string]any: {
+			m := value.NewValueMap()
+			keys := make([]string, 0, len(v))
+
+			for key := range v {
+				keys = append(keys, key)
+			}
+
+			slices.Sort(keys)
+
+			for _, key := range keys {
+				converted, err := FromGo(v[key])
+
+				if err != nil {
+					return nil, err
+				}
+
+				m.Set(value.ValueString{ Value: key }, converted)
+			}
+
+			return m, nil
+		}
+
+		case map[any]any: {
+			m := value.NewValueMap()
+			keys := make([]value.Value, 0, len(v))
+			elements := make(map[value.Value]any, len(v))
+
+			for key, element := range v {
+				convertedKey, err := FromGo(key)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if !value.IsHashable(convertedKey) {
+					return nil, fmt.Errorf("cannot use a value of type '%s' as a map key", convertedKey.Type())
+				}
+
+				keys = append(keys, convertedKey)
+				elements[convertedKey] = element
+			}
+
+			slices.SortFunc(keys, compareKeys)
+
+			for _, key := range keys {
+				converted, err := FromGo(elements[key])
+
+				if err != nil {
+					return nil, err
+				}
+
+				m.Set(key, converted)
+			}
+
+			return m, nil
+		}
+
+		default:
+			return nil, fmt.Errorf("cannot convert a value of type '%T'", x)
+	}
+}
+
+// The booleans come first, then the numbers and then the strings, each in their own order.
+func compareKeys(a, b value.Value) int {
+	if a.Type() != b.Type() {
+		return cmp.Compare(a.Type(), b.Type())
+	}
+
+	switch x := a.(type) {
+		case value.ValueNumber:
+			return cmp.Compare(x.Value, b.(value.ValueNumber).Value)
+
+		case value.ValueString:
+			return cmp.Compare(x.Value, b.(value.ValueString).Value)
+
+		case value.ValueBool:
+			if x.Value == b.(value.ValueBool).Value {
+				return 0
+			} else if x.Value {
+				return 1
+			}
+
+			return -1
+
+		default:
+			return 0
+	}
+}
+
+func number[T int | int8 | int16 | int32 | int64 | uint | uint8 | uint16 | uint32 | uint64 | float32 | float64](n T) value.Value {
+	return value.ValueNumber{ Value: float64(n) }
+}
@@ -0,0 +1,308 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"vm-go/ast"
+	"vm-go/compiler"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+// The API to embed the language in Go programs:
+//
+//	interp := interpreter.New(interpreter.Options{ FileName: "config.vm" })
+//	interp.RegisterNative("env", 1, func(args []any) (any, error) { ... })
+//	interp.SetGlobal("version", "1.2.0")
+//
+//	err := interp.Load(source)
+//	result, err := interp.Call("configure", 10, "debug")
+//
+// Untrusted scripts can be stopped with the limits and the timeout of the options, or with a context:
+//
+//	result, err := interp.CallContext(ctx, "handle", request)
+//
+// Values are converted with 'ToGo' and 'FromGo'.
+type Interpreter struct {
+	options  Options
+	registry *value.Registry
+	streams  *util.Streams
+
+	// Set once the program is loaded.
+	compiler *compiler.Compiler
+	vm       *vm.VM
+}
+
+type Options struct {
+	// The name shown in the diagnostics, imports are resolved relative to it.
+	FileName string
+
+	// Leaves out the default native functions, like 'print' and 'input'.
+	NoDefaultNatives bool
+
+	// The streams of the program, the diagnostics go to Stderr. They default to the ones of the process.
+	Stdout io.Writer
+	Stderr io.Writer
+	Stdin  io.Reader
+
+	// The most compile errors printed, 0 for no limit.
+	MaxErrors int
+
+	// The limits of the VM, like the instructions each run or call can execute. The ones left at 0 keep their default.
+	Limits vm.Limits
+
+	// How long each run or call can take, 0 for no limit.
+	Timeout time.Duration
+
+	// What the natives can touch, like the files of some directories, nil for everything.
+	// The program doesn't compile if it refers to a native that isn't granted.
+	Sandbox *value.Sandbox
+}
+
+var ErrCompile = errors.New("the program has compile errors")
+
+// An uncaught runtime error, which is also printed with its position.
+type RuntimeError struct {
+	Kind    string
+	Message string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+}
+
+func New(options Options) *Interpreter {
+	if options.Stdout == nil {
+		options.Stdout = os.Stdout
+	}
+
+	if options.Stderr == nil {
+		options.Stderr = os.Stderr
+	}
+
+	if options.Stdin == nil {
+		options.Stdin = os.Stdin
+	}
+
+	streams := util.NewStreams(options.Stdout, options.Stderr, options.Stdin)
+	registry := value.NewRegistry()
+
+	if !options.NoDefaultNatives {
+		registry = vm.DefaultRegistry(streams)
+	}
+
+	registry.SetSandbox(options.Sandbox)
+
+	if options.FileName == "" {
+		options.FileName = "<script>"
+	}
+
+	return &Interpreter{
+		options: options,
+		registry: registry,
+		streams: streams,
+	}
+}
+
+// Adds a native function, visible from every module. The arguments and the result are converted
+// with 'ToGo' and 'FromGo', and a returned error is raised as a runtime error, which scripts can catch.
+func (interp *Interpreter) RegisterNative(name string, arity int, fn func(args []any) (any, error)) error {
+	return interp.RegisterGuardedNative(name, arity, value.CapabilityNone, fn)
+}
+
+// Like 'RegisterNative', but the native can only be used if the sandbox grants the capability.
+func (interp *Interpreter) RegisterGuardedNative(name string, arity int, capability value.Capability, fn func(args []any) (any, error)) error {
+	if interp.vm != nil {
+		return fmt.Errorf("cannot register '%s': natives must be registered before loading the program", name)
+	}
+
+	native := func(args []value.Value) (value.Value, error) {
+		goArgs := make([]any, 0, len(args))
+
+		for _, arg := range args {
+			goArgs = append(goArgs, ToGo(arg))
+		}
+
+		result, err := fn(goArgs)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return FromGo(result)
+	}
+
+	if !interp.registry.DefineGuardedNative(name, arity, capability, native) {
+		return fmt.Errorf("cannot register '%s': it's already defined", name)
+	}
+
+	return nil
+}
+
+// Before loading, defines a global visible from every module.
+// After loading, changes a global of the program or of the host.
+func (interp *Interpreter) SetGlobal(name string, goValue any) error {
+	v, err := FromGo(goValue)
+
+	if err != nil {
+		return err
+	}
+
+	if interp.vm == nil {
+		if !interp.registry.Set(name, v) {
+			interp.registry.Define(name, v)
+		}
+
+		return nil
+	}
+
+	index := interp.compiler.GlobalIndex(name)
+
+	if index == -1 {
+		return fmt.Errorf("'%s' isn't a global of the program", name)
+	}
+
+	interp.vm.SetGlobal(index, v)
+	return nil
+}
+
+// Returns a global of the program or of the host, after loading.
+func (interp *Interpreter) Global(name string) (any, error) {
+	if interp.vm == nil {
+		return nil, errors.New("the program isn't loaded")
+	}
+
+	index := interp.compiler.GlobalIndex(name)
+
+	if index == -1 {
+		return nil, fmt.Errorf("'%s' isn't a global of the program", name)
+	}
+
+	return ToGo(interp.vm.Global(index)), nil
+}
+
+// Compiles the program and runs its top-level declarations, without calling main.
+// Its functions can be called afterwards with 'Call'.
+func (interp *Interpreter) Load(source string) error {
+	return interp.load(context.Background(), source, false)
+}
+
+// Like 'Load', but the top-level declarations stop running once the context is done.
+func (interp *Interpreter) LoadContext(ctx context.Context, source string) error {
+	return interp.load(ctx, source, false)
+}
+
+// Compiles the program and calls its main function, like the 'vm' command.
+func (interp *Interpreter) Run(source string) error {
+	return interp.load(context.Background(), source, true)
+}
+
+// Like 'Run', but the program stops once the context is done.
+func (interp *Interpreter) RunContext(ctx context.Context, source string) error {
+	return interp.load(ctx, source, true)
+}
+
+func (interp *Interpreter) RunFile(path string) error {
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	interp.options.FileName = path
+	return interp.Run(string(source))
+}
+
+// Calls a function of the program, after loading it.
+func (interp *Interpreter) Call(name string, args ...any) (any, error) {
+	return interp.CallContext(context.Background(), name, args...)
+}
+
+// Like 'Call', but the function stops once the context is done.
+func (interp *Interpreter) CallContext(ctx context.Context, name string, args ...any) (any, error) {
+	if interp.vm == nil {
+		return nil, errors.New("the program isn't loaded")
+	}
+
+	index := interp.compiler.GlobalIndex(name)
+
+	if index == -1 {
+		return nil, fmt.Errorf("'%s' isn't a global of the program", name)
+	}
+
+	values := make([]value.Value, 0, len(args))
+
+	for _, arg := range args {
+		v, err := FromGo(arg)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, v)
+	}
+
+	interp.prepare(ctx)
+	result, status := interp.vm.CallValue(interp.vm.Global(index), values)
+
+	if status != vm.STATUS_OK {
+		return nil, &RuntimeError{ Kind: status.String(), Message: interp.vm.ErrorMessage() }
+	}
+
+	return ToGo(result), nil
+}
+
+func (interp *Interpreter) load(ctx context.Context, source string, callMain bool) error {
+	if interp.vm != nil {
+		return errors.New("a program is already loaded")
+	}
+
+	fileData := util.FileData{
+		Name: util.GetFileName(interp.options.FileName),
+		Path: interp.options.FileName,
+		Lines: strings.Split(source, "\n"),
+	}
+
+	interp.compiler = compiler.NewCompiler([]ast.Statement{}, &fileData, interp.registry, interp.streams)
+	interp.compiler.SetDiagnostics(util.NewDiagnostics(interp.streams.Stderr, interp.options.MaxErrors))
+	mode := compiler.ModeLibrary
+
+	if callMain {
+		mode = compiler.ModeProgram
+	}
+
+	chunk, hadError := interp.compiler.CompileSource(source, &fileData, nil, mode)
+
+	if hadError {
+		return ErrCompile
+	}
+
+	interp.vm = vm.NewVM(chunk, &fileData, interp.registry, interp.streams)
+	interp.vm.SetLimits(interp.options.Limits)
+	interp.prepare(ctx)
+	status := interp.vm.Run()
+
+	if status != vm.STATUS_OK {
+		return &RuntimeError{ Kind: status.String(), Message: interp.vm.ErrorMessage() }
+	}
+
+	return nil
+}
+
+// Sets the context and the deadline of the next run, the timeout starts counting here.
+func (interp *Interpreter) prepare(ctx context.Context) {
+	deadline := time.Time{}
+
+	if interp.options.Timeout > 0 {
+		deadline = time.Now().Add(interp.options.Timeout)
+	}
+
+	interp.vm.SetContext(ctx)
+	interp.vm.SetDeadline(deadline)
+}
@@ -0,0 +1,374 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+func TestLoadAndCall(t *testing.T) {
+	stdout := strings.Builder{}
+	interp := New(Options{ Stdout: &stdout, Stderr: io.Discard })
+
+	err := interp.Load(`
+fn add(a, b) {
+    return a + b;
+}
+
+fn greet(name) {
+    println("hi " + name);
+}
+
+var loaded = println("loaded");`)
+
+	if err != nil {
+		t.Fatalf("expected the program to load, got '%s'", err)
+	}
+
+	if result, err := interp.Call("add", 1, 2.5); err != nil || result != 3.5 {
+		t.Errorf("expected 3.5, got %v (%v)", result, err)
+	}
+
+	if result, err := interp.Call("greet", "you"); err != nil || result != nil {
+		t.Errorf("expected nil, got %v (%v)", result, err)
+	}
+
+	// The top-level statements run once, and 'main' isn't needed.
+	if stdout.String() != "loaded\nhi you\n" {
+		t.Errorf("expected the output of the program, got '%s'", stdout.String())
+	}
+
+	if _, err := interp.Call("missing"); err == nil {
+		t.Error("expected an error calling a function that doesn't exist")
+	}
+
+	if err := interp.Load("var x = 1;"); err == nil {
+		t.Error("expected an error loading a second program")
+	}
+}
+
+func TestRun(t *testing.T) {
+	stdout := strings.Builder{}
+	interp := New(Options{ Stdout: &stdout, Stderr: io.Discard })
+
+	if err := interp.Run(`fn main() { println("main"); }`); err != nil {
+		t.Fatalf("expected the program to run, got '%s'", err)
+	}
+
+	if stdout.String() != "main\n" {
+		t.Errorf("expected main to be called, got '%s'", stdout.String())
+	}
+}
+
+func TestRegisterNative(t *testing.T) {
+	interp := New(Options{ Stdout: io.Discard, Stderr: io.Discard })
+
+	err := interp.RegisterNative("double", 1, func(args []any) (any, error) {
+		return args[0].(float64) * 2, nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interp.RegisterNative("fail", 0, func(args []any) (any, error) {
+		return nil, errors.New("it failed")
+	})
+
+	if err := interp.RegisterNative("double", 1, nil); err == nil {
+		t.Error("expected an error registering a native twice")
+	}
+
+	err = interp.Load(`
+fn quadruple(x) {
+    return double(double(x));
+}
+
+fn catchFail() {
+    try {
+        fail();
+    } catch e {
+        return e.kind + ": " + e.message;
+    }
+}`)
+
+	if err != nil {
+		t.Fatalf("expected the program to load, got '%s'", err)
+	}
+
+	if result, err := interp.Call("quadruple", 3); err != nil || result != 12.0 {
+		t.Errorf("expected 12, got %v (%v)", result, err)
+	}
+
+	// The errors of the natives can be caught by the scripts.
+	if result, err := interp.Call("catchFail"); err != nil || result != "native error: it failed" {
+		t.Errorf("expected the error to be caught, got %v (%v)", result, err)
+	}
+
+	if err := interp.RegisterNative("late", 0, nil); err == nil {
+		t.Error("expected an error registering a native after loading")
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	interp := New(Options{ Stdout: io.Discard, Stderr: io.Discard })
+
+	if _, err := interp.Global("version"); err == nil {
+		t.Error("expected an error reading a global before loading")
+	}
+
+	// Defined by the host before loading, it's visible from the program.
+	if err := interp.SetGlobal("version", "1.2.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := interp.Load(`
+var count = 1;
+var label = "v" + version;
+
+fn get() {
+    return count;
+}`)
+
+	if err != nil {
+		t.Fatalf("expected the program to load, got '%s'", err)
+	}
+
+	if label, err := interp.Global("label"); err != nil || label != "v1.2.0" {
+		t.Errorf("expected 'v1.2.0', got %v (%v)", label, err)
+	}
+
+	if err := interp.SetGlobal("count", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := interp.Call("get"); err != nil || result != 5.0 {
+		t.Errorf("expected the changed global, got %v (%v)", result, err)
+	}
+
+	if _, err := interp.Global("missing"); err == nil {
+		t.Error("expected an error reading a global that doesn't exist")
+	}
+
+	if err := interp.SetGlobal("missing", 1); err == nil {
+		t.Error("expected an error changing a global that doesn't exist")
+	}
+}
+
+func TestConversions(t *testing.T) {
+	interp := New(Options{ Stdout: io.Discard, Stderr: io.Discard })
+
+	err := interp.Load(`
+fn identity(x) {
+    return x;
+}
+
+fn keys(m) {
+    return m.keys();
+}`)
+
+	if err != nil {
+		t.Fatalf("expected the program to load, got '%s'", err)
+	}
+
+	cases := []struct {
+		in       any
+		expected any
+	}{
+		{ nil, nil },
+		{ true, true },
+		{ "text", "text" },
+		{ 3, 3.0 },
+		{ uint8(7), 7.0 },
+		{ float32(1.5), 1.5 },
+		{ []any{ 1, "a", []string{ "b" } }, []any{ 1.0, "a", []any{ "b" } } },
+		{ map[string]any{ "a": 1 }, map[any]any{ "a": 1.0 } },
+		{ map[any]any{ 1: true, "b": nil }, map[any]any{ 1.0: true, "b": nil } },
+	}
+
+	for _, c := range cases {
+		result, err := interp.Call("identity", c.in)
+
+		if err != nil || !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("%#v: expected %#v, got %#v (%v)", c.in, c.expected, result, err)
+		}
+	}
+
+	// The keys of the Go maps are sorted, so they're always in the same order.
+	result, err := interp.Call("keys", map[any]any{ "b": 1, "a": 2, 2: 3, 1: 4, true: 5 })
+
+	if expected := []any{ true, 1.0, 2.0, "a", "b" }; err != nil || !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected the keys %v, got %v (%v)", expected, result, err)
+	}
+
+	// The values without a Go equivalent are passed back unchanged.
+	fn, err := interp.Global("identity")
+
+	if _, ok := fn.(value.Value); err != nil || !ok {
+		t.Errorf("expected the function to stay a value, got %#v (%v)", fn, err)
+	}
+
+	if _, err := FromGo(struct{}{}); err == nil {
+		t.Error("expected an error converting a struct")
+	}
+
+	if _, err := FromGo(map[any]any{ value.NewValueList([]value.Value{}): 1 }); err == nil {
+		t.Error("expected an error converting a map with a list as a key")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	stderr := strings.Builder{}
+	interp := New(Options{ Stdout: io.Discard, Stderr: &stderr })
+
+	if err := interp.Load(`var x = ;`); !errors.Is(err, ErrCompile) {
+		t.Errorf("expected a compile error, got '%v'", err)
+	}
+
+	if !strings.Contains(stderr.String(), "[-] Error:") {
+		t.Errorf("expected the compile error to be printed, got '%s'", stderr.String())
+	}
+
+	interp = New(Options{ Stdout: io.Discard, Stderr: io.Discard })
+
+	if _, err := interp.Call("f"); err == nil {
+		t.Error("expected an error calling a function before loading")
+	}
+
+	if err := interp.Load(`fn lookup(m, key) { return m[key]; }`); err != nil {
+		t.Fatalf("expected the program to load, got '%s'", err)
+	}
+
+	_, err := interp.Call("lookup", map[string]any{}, "missing")
+	var runtimeErr *RuntimeError
+
+	if !errors.As(err, &runtimeErr) || runtimeErr.Kind != vm.STATUS_KEY_DOESNT_EXIST.String() {
+		t.Errorf("expected a runtime error, got '%v'", err)
+	}
+
+	// A failed call doesn't break the next ones.
+	if result, err := interp.Call("lookup", map[string]any{ "a": 1 }, "a"); err != nil || result != 1.0 {
+		t.Errorf("expected 1, got %v (%v)", result, err)
+	}
+}
+
+// The errors are reported like 'vm run' does: every stage that can find more runs, up to 'MaxErrors'.
+func TestCompileErrors(t *testing.T) {
+	source := `
+var a: num = "one";
+var b = missing;
+var c = @;`
+
+	stderr := strings.Builder{}
+	interp := New(Options{ Stdout: io.Discard, Stderr: &stderr })
+
+	if err := interp.Load(source); !errors.Is(err, ErrCompile) {
+		t.Errorf("expected a compile error, got '%v'", err)
+	}
+
+	if !strings.Contains(stderr.String(), "Unknown character") || !strings.Contains(stderr.String(), "Expected expression") {
+		t.Errorf("expected the errors of the lexer and of the parser, got:\n%s", stderr.String())
+	}
+
+	stderr.Reset()
+	interp = New(Options{ Stdout: io.Discard, Stderr: &stderr })
+
+	if err := interp.Load(source[:strings.Index(source, "var c")]); !errors.Is(err, ErrCompile) {
+		t.Errorf("expected a compile error, got '%v'", err)
+	}
+
+	if !strings.Contains(stderr.String(), "Cannot initialize 'a'") || !strings.Contains(stderr.String(), "'missing' doesn't exist") {
+		t.Errorf("expected the errors of the checker and of the compiler, got:\n%s", stderr.String())
+	}
+
+	stderr.Reset()
+	interp = New(Options{ Stdout: io.Discard, Stderr: &stderr, MaxErrors: 1 })
+	interp.Load(source[:strings.Index(source, "var c")])
+
+	if strings.Count(stderr.String(), "[-] Error:") != 1 || !strings.Contains(stderr.String(), "Too many errors") {
+		t.Errorf("expected only the first error, got:\n%s", stderr.String())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	source := `
+fn spin() {
+    loop {}
+}
+
+fn recurse(n) {
+    return recurse(n + 1);
+}`
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name    string
+		options Options
+		ctx     context.Context
+		fn      string
+		args    []any
+		status  vm.InterpretResult
+	}{
+		{ "budget", Options{ Limits: vm.Limits{ MaxInstructions: 1000 } }, context.Background(), "spin", nil, vm.STATUS_INSTRUCTION_LIMIT },
+		{ "depth", Options{ Limits: vm.Limits{ MaxCallDepth: 50 } }, context.Background(), "recurse", []any{ 0 }, vm.STATUS_STACK_OVERFLOW },
+		{ "timeout", Options{ Timeout: 10 * time.Millisecond }, context.Background(), "spin", nil, vm.STATUS_TIMEOUT },
+		{ "context", Options{}, cancelled, "spin", nil, vm.STATUS_CANCELLED },
+	}
+
+	for _, c := range cases {
+		c.options.Stdout = io.Discard
+		c.options.Stderr = io.Discard
+		interp := New(c.options)
+
+		if err := interp.Load(source); err != nil {
+			t.Fatalf("%s: expected the program to load, got '%s'", c.name, err)
+		}
+
+		_, err := interp.CallContext(c.ctx, c.fn, c.args...)
+		var runtimeErr *RuntimeError
+
+		if !errors.As(err, &runtimeErr) || runtimeErr.Kind != c.status.String() {
+			t.Errorf("%s: expected '%s', got '%v'", c.name, c.status, err)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	stdout := strings.Builder{}
+	stderr := strings.Builder{}
+	interp := New(Options{ Stdout: &stdout, Stderr: &stderr, Sandbox: value.NewSandbox(value.CapabilityIO) })
+
+	interp.RegisterGuardedNative("secret", 0, value.CapabilityEnv, func(args []any) (any, error) {
+		return "hidden", nil
+	})
+
+	// The granted natives can be used.
+	if err := interp.Load(`var printed = println("allowed");`); err != nil || stdout.String() != "allowed\n" {
+		t.Errorf("expected the program to print, got '%s' (%v)", stdout.String(), err)
+	}
+
+	// Referring to the others is a compile error, the host's guarded natives too.
+	for _, source := range []string{ `var t = time();`, `var s = secret();` } {
+		interp := New(Options{ Stdout: io.Discard, Stderr: &stderr, Sandbox: value.NewSandbox(value.CapabilityIO) })
+
+		interp.RegisterGuardedNative("secret", 0, value.CapabilityEnv, func(args []any) (any, error) {
+			return "hidden", nil
+		})
+
+		if err := interp.Load(source); !errors.Is(err, ErrCompile) {
+			t.Errorf("'%s': expected a compile error, got '%v'", source, err)
+		}
+	}
+
+	if !strings.Contains(stderr.String(), "needs the 'time' capability") || !strings.Contains(stderr.String(), "needs the 'env' capability") {
+		t.Errorf("expected the errors to name the capabilities, got:\n%s", stderr.String())
+	}
+}
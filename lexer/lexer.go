@@ -20,10 +20,19 @@ type Lexer struct {
 	hadError bool
 	tokens []token.Token
 
+	// How many interpolations of strings it's in, as their comments end at the closing '}'.
+	interpolations int
+
+	// A string or an interpolation was cut off by the end of the source, and it was reported.
+	reachedEnd bool
+
 	fileData *util.FileData
+	diagnostics *util.Diagnostics
 }
 
-func NewLexer(source string, fileData *util.FileData) *Lexer {
+func NewLexer(source string, fileData *util.FileData, streams *util.Streams) *Lexer {
+	fileData.Comments = map[int]string{}
+
 	return &Lexer{
 		source:  source,
 
@@ -37,11 +46,18 @@ func NewLexer(source string, fileData *util.FileData) *Lexer {
 		tokens:   []token.Token{},
 
 		fileData: fileData,
+		diagnostics: util.NewDiagnostics(streams.Stderr, 0),
 	}
 }
 
+// The errors are counted with the ones of the other stages of the compilation.
+func (l *Lexer) SetDiagnostics(diagnostics *util.Diagnostics) {
+	l.diagnostics = diagnostics
+}
+
+// The errors don't stop it, the characters it doesn't know are skipped.
 func (l *Lexer) Lex() ([]token.Token, bool) {
-	for !l.isAtEnd(0) {
+	for !l.isAtEnd(0) && !l.diagnostics.TooManyErrors() {
 		l.scanToken()
 	}
 
@@ -93,10 +109,12 @@ func (l *Lexer) scanToken() {
 
 		case '/': {
 			if l.match('/') {
-				// A comment goes until the end of the line.
-				for l.peek(0) != '\n' && !l.isAtEnd(0) {
+				// A comment goes until the end of the line, or the end of the interpolation it's in.
+				for l.peek(0) != '\n' && !(l.interpolations > 0 && l.peek(0) == '}') && !l.isAtEnd(0) {
 					l.advance()
 				}
+
+				l.fileData.Comments[l.startPos.Line] += l.source[l.start:l.current]
 			} else if l.match('=') {
 				l.addToken(token.TokenSlashEqual)
 			} else {
@@ -118,6 +136,9 @@ func (l *Lexer) scanToken() {
 		case '{': l.addToken(token.TokenLeftBrace)
 		case '}': l.addToken(token.TokenRightBrace)
 
+		case '[': l.addToken(token.TokenLeftBracket)
+		case ']': l.addToken(token.TokenRightBracket)
+
 		case '=': {
 			if l.match('=') {
 				l.addToken(token.TokenDoubleEqual)
@@ -152,8 +173,10 @@ func (l *Lexer) scanToken() {
 
 		case ';': l.addToken(token.TokenSemicolon)
 		case '"': l.string()
+		case '`': l.rawString()
 		case ',': l.addToken(token.TokenComma)
 		case ':': l.addToken(token.TokenColon)
+		case '?': l.addToken(token.TokenQuestion)
 
 		case '.':  {
 			if l.match('.') {
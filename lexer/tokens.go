@@ -1,7 +1,11 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 	"vm-go/token"
 )
 
@@ -21,20 +25,161 @@ func (l *Lexer) number() {
 	l.addToken(token.TokenNumber)
 }
 
+// Interpolated strings, like "a {b} c", are split into segments:
+// 'interpolation' ("a "), the tokens of 'b', and then 'string' (" c").
 func (l *Lexer) string() {
+	builder := strings.Builder{}
+	startPos := l.startPos
+
 	for l.peek(0) != '"' && !l.isAtEnd(0) {
-		l.advance()
+		if l.peek(0) == '\\' {
+			l.escapeSequence(&builder)
+		} else if l.peek(0) == '{' {
+			l.startPos = startPos
+			l.addTokenLexeme(token.TokenInterpolation, builder.String())
+			builder.Reset()
+
+			bracePos := l.currentPos
+			l.advance() // the '{'
+			l.interpolatedExpression(bracePos)
+
+			startPos = l.currentPos
+		} else {
+			builder.WriteByte(l.advance())
+		}
 	}
 
+	l.startPos = startPos
+
 	if l.isAtEnd(0) {
-		l.error("Unterminated string")
+		l.unterminated("Unterminated string")
 		return
 	}
 
 	l.advance() // the closing '"'
+	l.addTokenLexeme(token.TokenString, builder.String())
+}
+
+// Scans the tokens of an interpolated expression, until the matching '}', which is consumed.
+func (l *Lexer) interpolatedExpression(bracePos token.Position) {
+	depth := 0
+
+	l.interpolations++
+	defer func() { l.interpolations-- }()
+
+	for !l.isAtEnd(0) {
+		// Check the closing brace before scanning, because it's not part of the expression.
+		for strings.IndexByte(" \r\t\n", l.peek(0)) != -1 {
+			l.advance()
+		}
+
+		if l.peek(0) == '}' && depth == 0 {
+			l.advance()
+			return
+		}
+
+		count := len(l.tokens)
+		l.scanToken()
+
+		if len(l.tokens) > count {
+			switch l.tokens[len(l.tokens) - 1].Kind {
+				case token.TokenLeftBrace: depth++
+				case token.TokenRightBrace: depth--
+			}
+		}
+	}
+
+	// The strings it's in end there too, they don't report it again.
+	if !l.reachedEnd {
+		l.errorAt(bracePos, 1, "Unterminated interpolation in string.")
+		l.reachedEnd = true
+	}
+}
+
+// The innermost string or interpolation cut off by the end of the source reports it, the ones around it don't.
+func (l *Lexer) unterminated(message string) {
+	if !l.reachedEnd {
+		l.error(message)
+		l.reachedEnd = true
+	}
+}
+
+// Raw strings are delimited by backticks, may span multiple lines,
+// and don't have escape sequences.
+func (l *Lexer) rawString() {
+	for l.peek(0) != '`' && !l.isAtEnd(0) {
+		l.advance()
+	}
+
+	if l.isAtEnd(0) {
+		l.unterminated("Unterminated raw string")
+		return
+	}
+
+	l.advance() // the closing '`'
 	l.addTokenLexeme(token.TokenString, l.source[l.start + 1 : l.current - 1])
 }
 
+// Decodes the escape sequence at the current position and writes it into 'builder'.
+func (l *Lexer) escapeSequence(builder *strings.Builder) {
+	pos := l.currentPos
+	l.advance() // the '\'
+
+	if l.isAtEnd(0) {
+		return // the caller reports the unterminated string
+	}
+
+	c := l.advance()
+
+	switch c {
+		case 'n': builder.WriteByte('\n')
+		case 't': builder.WriteByte('\t')
+		case 'r': builder.WriteByte('\r')
+		case '0': builder.WriteByte(0)
+		case '"': builder.WriteByte('"')
+		case '{': builder.WriteByte('{')
+		case '}': builder.WriteByte('}')
+		case '\\': builder.WriteByte('\\')
+
+		// \u{1F600}
+		case 'u': {
+			if !l.match('{') {
+				l.errorAt(pos, 2, "Expected '{' after '\\u' in unicode escape sequence.")
+				return
+			}
+
+			digitsStart := l.current
+
+			for isHexDigit(l.peek(0)) {
+				l.advance()
+			}
+
+			digits := l.source[digitsStart:l.current]
+
+			if !l.match('}') {
+				l.errorAt(pos, l.current - digitsStart + 3, "Expected '}' after the digits of the unicode escape sequence.")
+				return
+			}
+
+			code, err := strconv.ParseUint(digits, 16, 32)
+
+			if len(digits) == 0 || len(digits) > 6 || err != nil || !utf8.ValidRune(rune(code)) {
+				l.errorAt(pos, len(digits) + 4, fmt.Sprintf("Invalid unicode code point: '%s'.", digits))
+				return
+			}
+
+			builder.WriteRune(rune(code))
+		}
+
+		default:
+			l.errorAt(pos, 2, fmt.Sprintf("Invalid escape sequence: '\\%c'.", c))
+	}
+}
+
+func isHexDigit(c byte) bool {
+	return strings.IndexByte("0123456789abcdefABCDEF", c) != -1
+}
+
 func (l *Lexer) identifier() {
 	for unicode.IsLetter(rune(l.peek(0))) || unicode.IsDigit(rune(l.peek(0))) || l.peek(0) == '_' {
 		l.advance()
@@ -44,7 +189,12 @@ func (l *Lexer) identifier() {
 }
 
 func (l *Lexer) checkKeyword() token.TokenKind {
-	switch l.source[l.start:l.current] {
+	return KeywordKind(l.source[l.start:l.current])
+}
+
+// Returns the kind of the keyword, or 'TokenIdentifier' if the word isn't one.
+func KeywordKind(word string) token.TokenKind {
+	switch word {
 		case "if": return token.TokenIfKw
 		case "else": return token.TokenElseKw
 		case "while": return token.TokenWhileKw
@@ -58,6 +208,11 @@ func (l *Lexer) checkKeyword() token.TokenKind {
 		case "self": return token.TokenSelfKw
 		case "record": return token.TokenRecordKw
 		case "return": return token.TokenReturnKw
+		case "try": return token.TokenTryKw
+		case "catch": return token.TokenCatchKw
+		case "import": return token.TokenImportKw
+		case "as": return token.TokenAsKw
+		case "from": return token.TokenFromKw
 
 		case "and": return token.TokenAndKw
 		case "or": return token.TokenOrKw
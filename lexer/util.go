@@ -2,7 +2,6 @@ package lexer
 
 import (
 	"vm-go/token"
-	"vm-go/util"
 )
 
 func (l *Lexer) match(c byte) bool {
@@ -49,7 +48,11 @@ func (l *Lexer) increaseLine() {
 }
 
 func (l *Lexer) error(message string) {
-	util.Error(l.startPos, 1, message, l.fileData)
+	l.errorAt(l.startPos, 1, message)
+}
+
+func (l *Lexer) errorAt(pos token.Position, length int, message string) {
+	l.diagnostics.Error(pos, length, message, l.fileData)
 	l.hadError = true
 }
 
@@ -3,26 +3,407 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"vm-go/bytecode"
+	"vm-go/coverage"
+	"vm-go/debugger"
+	"vm-go/profiler"
+	"vm-go/repl"
 	"vm-go/run"
+	"vm-go/tester"
+	"vm-go/tracer"
+	"vm-go/util"
+	"vm-go/value"
 )
 
+const usage = `Usage:
+  vm [repl]
+  vm [run] <source | bytecode> [-d | --dissassemble] [-O0] [--Werror] [--max-errors=<n>] [limits...] [tools...]
+  vm build <source> [-o <output>] [-O0] [--Werror] [--max-errors=<n>]
+  vm debug <source> [limits...]
+  vm test [-u | --update] [-O0] [paths...]
+
+  -O0                     compiles without the optimizations
+  --Werror                reports the warnings as errors, hide one with a '// nowarn' comment on its line
+  --max-errors=<n>        stops compiling after that many errors, 20 by default
+
+Limits:
+  --max-depth=<n>         how deep the calls can nest before a stack overflow, 10000 by default
+  --max-stack=<n>         how many values the stack can hold before a stack overflow, 1048576 by default
+  --max-instructions=<n>  stops the program after running that many instructions
+  --timeout=<duration>    stops the program after that long, like '500ms' or '2s'
+
+Tools, whose reports go to stderr:
+  --profile[=<format>]    counts the instructions by opcode, function and line, as a 'table' by default,
+                          or as 'folded' stacks for the flame graphs
+  --profile-out=<file>    writes the profile to the file instead
+  --cover[=<format>]      reports the lines and the branches that ran, as a 'summary' by default,
+                          with the 'annotate'd source, or as an 'lcov' tracefile
+  --cover-out=<file>      writes the coverage to the file instead
+  --trace                 prints each instruction as it runs, with the call depth and the stack
+  --trace-fn=<name>       only traces the instructions of the functions with that name
+  --trace-max=<n>         stops tracing after that many instructions
+
+Sandbox, which grants nothing unless the flags say so:
+  --allow=<capabilities>  grants some of 'io', 'time', 'env' and 'random', separated by commas
+  --allow-dir=<dir>       grants 'fs' for the files inside the directory, can be repeated`
+
+// The compile errors printed by default, more are rarely useful, as they're often caused by the first ones.
+const defaultMaxErrors = 20
+
 func main() {
-	if len(os.Args) == 1 || len(os.Args) > 3 {
-		fmt.Println("Usage: vm <source> [-d | --dissassemble]")
+	if len(os.Args) == 1 || (len(os.Args) == 2 && os.Args[1] == "repl") {
+		repl.New(util.DefaultStreams()).Start()
+		return
+	}
+
+	switch os.Args[1] {
+		case "test":
+			test(os.Args[2:])
+
+		case "build":
+			build(os.Args[2:])
+
+		case "run":
+			runFile(os.Args[2:])
+
+		case "debug":
+			debug(os.Args[2:])
+
+		default:
+			runFile(os.Args[1:])
+	}
+}
+
+// Runs a source file, or a bytecode file built with 'vm build'.
+func runFile(args []string) {
+	file, options, ok := parseRunArgs(args)
+
+	if !ok {
 		return
 	}
 
-	c, err := os.ReadFile(os.Args[1])
+	c, err := os.ReadFile(file)
 	
 	if err != nil {
-		fmt.Printf("Cannot read file: '%s'\n", os.Args[1])
+		fmt.Fprintf(os.Stderr, "Cannot read file: '%s'\n", file)
+		os.Exit(1)
+	}
+
+	if bytecode.IsBytecode(c) {
+		run.RunBytecode(c, options, util.DefaultStreams())
+	} else {
+		run.Run(string(c), file, options, util.DefaultStreams())
+	}
+}
+
+// Runs a source file under the debugger, with the same flags as 'run'.
+func debug(args []string) {
+	file, options, ok := parseRunArgs(args)
+
+	if !ok {
+		return
+	}
+
+	c, err := os.ReadFile(file)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read file: '%s'\n", file)
+		os.Exit(1)
+	}
+
+	if bytecode.IsBytecode(c) {
+		fmt.Fprintln(os.Stderr, "Cannot debug a bytecode file, it has no names for the variables.")
 		os.Exit(1)
 	}
 
-	mode := run.ModeRun
-	if len(os.Args) == 3 && (os.Args[2] == "-d" || os.Args[2] == "--dissassemble") {
-		mode = run.ModeDisassemble
+	if d := debugger.New(string(c), file, options, util.DefaultStreams()); d != nil {
+		d.Start()
 	}
+}
+
+// Reads the file and the flags of 'run', printing the usage if they're wrong.
+func parseRunArgs(args []string) (string, run.Options, bool) {
+	file := ""
+	options := run.Options{ MaxErrors: defaultMaxErrors }
+
+	for _, arg := range args {
+		switch {
+			case arg == "-d" || arg == "--dissassemble":
+				options.Mode = run.ModeDisassemble
+
+			case arg == "-O0":
+				options.NoOptimize = true
+
+			case arg == "--Werror":
+				options.WarningsAsErrors = true
+
+			case strings.HasPrefix(arg, "--max-errors="): {
+				if !parseLimit(arg, &options.MaxErrors) {
+					return "", options, false
+				}
+			}
+
+			case strings.HasPrefix(arg, "--max-depth="): {
+				if !parseLimit(arg, &options.Limits.MaxCallDepth) {
+					return "", options, false
+				}
+			}
+
+			case strings.HasPrefix(arg, "--max-stack="): {
+				if !parseLimit(arg, &options.Limits.MaxStackSize) {
+					return "", options, false
+				}
+			}
+
+			case strings.HasPrefix(arg, "--max-instructions="): {
+				if !parseLimit(arg, &options.Limits.MaxInstructions) {
+					return "", options, false
+				}
+			}
+
+			case strings.HasPrefix(arg, "--allow="): {
+				if !parseCapabilities(arg, &options) {
+					return "", options, false
+				}
+			}
+
+			case strings.HasPrefix(arg, "--allow-dir="): {
+				_, dir, _ := strings.Cut(arg, "=")
+				sandbox(&options)
+
+				if err := options.Sandbox.AllowDir(dir); err != nil {
+					fmt.Fprintf(os.Stderr, "Invalid directory: '%s': %s\n", dir, err)
+					return "", options, false
+				}
+			}
+
+			case arg == "--profile":
+				options.Profile = profiler.FormatTable
+
+			case strings.HasPrefix(arg, "--profile="): {
+				_, name, _ := strings.Cut(arg, "=")
+				format, ok := profiler.ParseFormat(name)
+
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Unknown profile format: '%s', it must be 'table' or 'folded'.\n", name)
+					return "", options, false
+				}
+
+				options.Profile = format
+			}
+
+			case strings.HasPrefix(arg, "--profile-out="): {
+				_, options.ProfileFile, _ = strings.Cut(arg, "=")
+
+				if options.Profile == profiler.FormatNone {
+					options.Profile = profiler.FormatTable
+				}
+			}
+
+			case arg == "--cover":
+				options.Cover = coverage.FormatSummary
+
+			case strings.HasPrefix(arg, "--cover="): {
+				_, name, _ := strings.Cut(arg, "=")
+				format, ok := coverage.ParseFormat(name)
+
+				if !ok {
+					fmt.Fprintf(os.Stderr, "Unknown coverage format: '%s', it must be 'summary', 'annotate' or 'lcov'.\n", name)
+					return "", options, false
+				}
+
+				options.Cover = format
+			}
+
+			case strings.HasPrefix(arg, "--cover-out="): {
+				_, options.CoverFile, _ = strings.Cut(arg, "=")
+
+				if options.Cover == coverage.FormatNone {
+					options.Cover = coverage.FormatSummary
+				}
+			}
+
+			case arg == "--trace":
+				trace(&options)
+
+			case strings.HasPrefix(arg, "--trace-fn="): {
+				trace(&options)
+				_, options.Trace.Function, _ = strings.Cut(arg, "=")
+			}
+
+			case strings.HasPrefix(arg, "--trace-max="): {
+				trace(&options)
+
+				if !parseLimit(arg, &options.Trace.MaxLines) {
+					return "", options, false
+				}
+			}
 
-	run.Run(string(c), os.Args[1], mode)
+			case strings.HasPrefix(arg, "--timeout="): {
+				_, text, _ := strings.Cut(arg, "=")
+				timeout, err := time.ParseDuration(text)
+
+				if err != nil || timeout <= 0 {
+					fmt.Fprintf(os.Stderr, "Invalid timeout: '%s', it must be a positive duration, like '500ms' or '2s'.\n", arg)
+					return "", options, false
+				}
+
+				options.Timeout = timeout
+			}
+
+			case file == "":
+				file = arg
+
+			default: {
+				fmt.Println(usage)
+				return "", options, false
+			}
+		}
+	}
+
+	if file == "" {
+		fmt.Println(usage)
+		return "", options, false
+	}
+
+	return file, options, true
+}
+
+// Reads the value of a '--name=<n>' flag, which must be a positive number.
+func parseLimit(arg string, limit *int) bool {
+	_, text, _ := strings.Cut(arg, "=")
+	n, err := strconv.Atoi(text)
+
+	if err != nil || n <= 0 {
+		fmt.Fprintf(os.Stderr, "Invalid limit: '%s', it must be a positive number.\n", arg)
+		return false
+	}
+
+	*limit = n
+	return true
+}
+
+// Reads the value of '--allow=<capabilities>', the names are separated by commas.
+func parseCapabilities(arg string, options *run.Options) bool {
+	_, list, _ := strings.Cut(arg, "=")
+	sandbox(options)
+
+	for _, name := range strings.Split(list, ",") {
+		if name == "" {
+			continue
+		}
+
+		capability, ok := value.ParseCapability(name)
+
+		// 'fs' is useless without the directories it gives access to.
+		if capability == value.CapabilityFS {
+			fmt.Fprintf(os.Stderr, "The 'fs' capability can't be granted with '--allow', use '--allow-dir=<dir>' instead.\n")
+			return false
+		}
+
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown capability: '%s', it must be one of 'io', 'time', 'env' and 'random'.\n", name)
+			return false
+		}
+
+		options.Sandbox.Grant(capability)
+	}
+
+	return true
+}
+
+// The trace flags turn the trace on.
+func trace(options *run.Options) {
+	if options.Trace == nil {
+		options.Trace = &tracer.Options{}
+	}
+}
+
+// The sandbox flags start from a sandbox that grants nothing.
+func sandbox(options *run.Options) {
+	if options.Sandbox == nil {
+		options.Sandbox = value.NewSandbox()
+	}
+}
+
+// Compiles a source file to bytecode, by default next to it, with the '.vmc' extension.
+func build(args []string) {
+	source := ""
+	output := ""
+	options := run.Options{ MaxErrors: defaultMaxErrors }
+
+	for i := 0; i < len(args); i++ {
+		if (args[i] == "-o" || args[i] == "--output") && i + 1 < len(args) {
+			output = args[i + 1]
+			i++
+		} else if args[i] == "-O0" {
+			options.NoOptimize = true
+		} else if args[i] == "--Werror" {
+			options.WarningsAsErrors = true
+		} else if strings.HasPrefix(args[i], "--max-errors=") {
+			if !parseLimit(args[i], &options.MaxErrors) {
+				return
+			}
+		} else if source == "" {
+			source = args[i]
+		} else {
+			fmt.Println(usage)
+			return
+		}
+	}
+
+	if source == "" {
+		fmt.Println(usage)
+		return
+	}
+
+	if output == "" {
+		output = strings.TrimSuffix(source, filepath.Ext(source)) + ".vmc"
+	}
+
+	c, err := os.ReadFile(source)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read file: '%s'\n", source)
+		os.Exit(1)
+	}
+
+	if !run.Build(string(c), source, output, options, util.DefaultStreams()) {
+		os.Exit(1)
+	}
+}
+
+// Runs the test programs, by default the ones in 'tests/'.
+func test(args []string) {
+	options := tester.Options{}
+	paths := []string{}
+
+	for _, arg := range args {
+		if arg == "-u" || arg == "--update" {
+			options.Update = true
+		} else if arg == "-O0" {
+			options.Run.NoOptimize = true
+		} else {
+			paths = append(paths, arg)
+		}
+	}
+
+	if len(paths) == 0 {
+		paths = append(paths, "tests")
+	}
+
+	summary, err := tester.RunAll(paths, options, os.Stdout)
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read the tests: %s\n", err)
+		os.Exit(1)
+	}
+
+	if summary.Failed > 0 {
+		os.Exit(1)
+	}
 }
@@ -8,6 +8,11 @@ import (
 )
 
 func (p *Parser) expression(precedence int) ast.Expression {
+	// The rest of the statement is skipped by 'synchronize', parsing it would consume the tokens it stops at.
+	if p.panicMode {
+		return ast.Expression{}
+	}
+
 	pos := p.peek(0).Pos
 	prefixFn, ok := p.prefixMap[p.peek(0).Kind]
 
@@ -62,6 +67,43 @@ func (p *Parser) parseString() ast.Expression {
 	}
 }
 
+func (p *Parser) parseInterpolation() ast.Expression {
+	pos := p.peek(0).Pos
+	parts := []ast.Expression{}
+
+	for p.check(token.TokenInterpolation) && !p.panicMode {
+		segment := p.parseString()
+
+		// Skip empty segments, like the one before '{' in "{a}".
+		if segment.Data.(ast.StringExpression).Literal != "" {
+			parts = append(parts, segment)
+		}
+
+		parts = append(parts, p.parseExpression())
+	}
+
+	if !p.check(token.TokenString) {
+		p.error("Expected the rest of the string after the interpolated expression.")
+		return ast.Expression{}
+	}
+
+	last := p.parseString()
+
+	if last.Data.(ast.StringExpression).Literal != "" {
+		parts = append(parts, last)
+	}
+
+	return ast.Expression{
+		Base: ast.AstBase{
+			Pos: pos,
+			Length: 1,
+		},
+		Data: ast.InterpolationExpression{
+			Parts: parts,
+		},
+	}
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	ident := p.expectToken(token.TokenIdentifier)
 
@@ -140,9 +182,9 @@ func (p *Parser) parseVoid() ast.Expression {
 }
 
 func (p *Parser) lParen() ast.Expression {
-	// lambda: ')' | ( ident ',' | ')' )
+	// lambda: ')' | ( ident ',' | ')' | ':' )
 	if p.peek(1).Kind == token.TokenRightParen ||
-		(p.peek(1).Kind == token.TokenIdentifier && (p.peek(2).Kind == token.TokenRightParen || p.peek(2).Kind == token.TokenComma)) {
+		(p.peek(1).Kind == token.TokenIdentifier && (p.peek(2).Kind == token.TokenRightParen || p.peek(2).Kind == token.TokenComma || p.peek(2).Kind == token.TokenColon)) {
 		return p.parseLambda()
 	} else {
 		return p.parseGroup()
@@ -190,6 +232,59 @@ func (p *Parser) parseLambda() ast.Expression {
 	}
 }
 
+func (p *Parser) parseList() ast.Expression {
+	bracket := p.expectToken(token.TokenLeftBracket)
+	elements := []ast.Expression{}
+
+	for !p.match(token.TokenRightBracket) && !p.isAtEnd(0) && !p.panicMode {
+		elements = append(elements, p.parseExpression())
+
+		if !p.check(token.TokenRightBracket) {
+			p.expect(token.TokenComma)
+		}
+	}
+
+	return ast.Expression{
+		Base: ast.AstBase{
+			Pos: bracket.Pos,
+			Length: len(bracket.Lexeme),
+		},
+		Data: ast.ListExpression{
+			Elements: elements,
+		},
+	}
+}
+
+// A '{' only starts a map when it's in an expression position,
+// since blocks are only parsed as statements.
+func (p *Parser) parseMap() ast.Expression {
+	brace := p.expectToken(token.TokenLeftBrace)
+
+	keys := []ast.Expression{}
+	values := []ast.Expression{}
+
+	for !p.match(token.TokenRightBrace) && !p.isAtEnd(0) && !p.panicMode {
+		keys = append(keys, p.parseExpression())
+		p.expect(token.TokenColon)
+		values = append(values, p.parseExpression())
+
+		if !p.check(token.TokenRightBrace) {
+			p.expect(token.TokenComma)
+		}
+	}
+
+	return ast.Expression{
+		Base: ast.AstBase{
+			Pos: brace.Pos,
+			Length: len(brace.Lexeme),
+		},
+		Data: ast.MapExpression{
+			Keys: keys,
+			Values: values,
+		},
+	}
+}
+
 func (p *Parser) parseIfExpr() ast.Expression {
 	if_ := p.advance()
 	cond := p.parseExpression()
@@ -304,7 +399,7 @@ func (p *Parser) parseCall(left ast.Expression, pos token.Position) ast.Expressi
 	p.expectToken(token.TokenLeftParen)
 	arguments := []ast.Expression{}
 
-	for !p.match(token.TokenRightParen) {
+	for !p.match(token.TokenRightParen) && !p.isAtEnd(0) && !p.panicMode {
 		arguments = append(arguments, p.parseExpression())
 
 		if !p.check(token.TokenRightParen) {
@@ -324,6 +419,24 @@ func (p *Parser) parseCall(left ast.Expression, pos token.Position) ast.Expressi
 	}
 }
 
+func (p *Parser) parseIndex(left ast.Expression, pos token.Position) ast.Expression {
+	bracket := p.expectToken(token.TokenLeftBracket)
+
+	index := p.parseExpression()
+	p.expect(token.TokenRightBracket)
+
+	return ast.Expression{
+		Base: ast.AstBase{
+			Pos:    bracket.Pos,
+			Length: len(bracket.Lexeme),
+		},
+		Data: ast.IndexExpression{
+			Left:  left,
+			Index: index,
+		},
+	}
+}
+
 func (p *Parser) parseAssignment(left ast.Expression, pos token.Position) ast.Expression {
 	operator := p.expectToken(token.TokenEqual)
 	right := p.parseExpression() // accept one level higher because assignment is right-associative
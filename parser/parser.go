@@ -16,7 +16,7 @@ const (
 	PrecTerm                // + -
 	PrecFactor              // * /
 	PrecUnary               // not -
-	PrecCall                // ()
+	PrecCall                // () []
 	PrecGetProperty			// .
 	PrecRange				// ..
 	// PrecPrimary          // literals, identifiers (unused)
@@ -34,10 +34,14 @@ type Parser struct {
 	hadError bool
 	panicMode bool
 
+	// Set by 'ParseInput', statements are allowed next to the declarations.
+	topLevelStatements bool
+
 	fileData *util.FileData
+	diagnostics *util.Diagnostics
 }
 
-func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
+func NewParser(tokens []token.Token, fileData *util.FileData, streams *util.Streams) *Parser {
 	p := &Parser{
 		tokens: tokens,
 		current: 0,
@@ -46,11 +50,13 @@ func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
 		panicMode: false,
 
 		fileData: fileData,
+		diagnostics: util.NewDiagnostics(streams.Stderr, 0),
 	}
 
 	p.prefixMap = map[token.TokenKind] func() ast.Expression {
 		token.TokenNumber: p.parseNumber,
 		token.TokenString: p.parseString,
+		token.TokenInterpolation: p.parseInterpolation,
 		token.TokenIdentifier: p.parseIdentifier,
 		token.TokenSelfKw: p.parseSelf,
 
@@ -60,6 +66,8 @@ func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
 		token.TokenVoidKw: p.parseVoid,
 
 		token.TokenLeftParen: p.lParen,
+		token.TokenLeftBracket: p.parseList,
+		token.TokenLeftBrace: p.parseMap,
 		token.TokenIfKw: p.parseIfExpr,
 
 		token.TokenNotKw: func() ast.Expression { return p.parseUnary(token.TokenNotKw) },
@@ -95,6 +103,7 @@ func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
 
 		token.TokenEqual: p.parseAssignment,
 		token.TokenLeftParen: p.parseCall,
+		token.TokenLeftBracket: p.parseIndex,
 		
 		token.TokenDot: p.parseDot,
 		token.TokenDoubleDot: p.parseRange,
@@ -129,6 +138,7 @@ func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
 
 		token.TokenEqual: PrecAssignment,
 		token.TokenLeftParen: PrecCall,
+		token.TokenLeftBracket: PrecCall,
 
 		token.TokenDot: PrecGetProperty,
 		token.TokenDoubleDot: PrecRange,
@@ -137,12 +147,24 @@ func NewParser(tokens []token.Token, fileData *util.FileData) *Parser {
 	return p
 }
 
+// The errors are counted with the ones of the other stages of the compilation.
+func (p *Parser) SetDiagnostics(diagnostics *util.Diagnostics) {
+	p.diagnostics = diagnostics
+}
+
+// After an error, it skips to the next statement to look for more, see 'synchronize'.
 func (p *Parser) Parse() ([]ast.Statement, bool) {
 	stmts := []ast.Statement{}
 
-	for !p.isAtEnd(0) {
+	for !p.isAtEnd(0) && !p.diagnostics.TooManyErrors() {
 		stmts = append(stmts, p.declaration(false))
 	}
 
 	return stmts, p.hadError
 }
+
+// Like 'Parse', but statements are allowed at top-level too, like the inputs of the REPL.
+func (p *Parser) ParseInput() ([]ast.Statement, bool) {
+	p.topLevelStatements = true
+	return p.Parse()
+}
@@ -8,6 +8,11 @@ import (
 func (p *Parser) declaration(allowStatements bool) ast.Statement {
 	if p.panicMode {
 		p.synchronize()
+
+		// It stopped at the end of the block, which its caller parses.
+		if p.isAtEnd(0) || p.check(token.TokenRightBrace) {
+			return ast.Statement{}
+		}
 	}
 
 	t := p.peek(0)
@@ -16,9 +21,18 @@ func (p *Parser) declaration(allowStatements bool) ast.Statement {
 		case token.TokenRecordKw: return p.recordStatement()
 		case token.TokenFnKw: return p.fnStatement()
 		case token.TokenVarKw: return p.varStatement()
+		case token.TokenImportKw: {
+			if allowStatements {
+				p.error("Imports are only allowed at top-level.")
+				p.advance()
+				return ast.Statement{}
+			}
+
+			return p.importStatement()
+		}
 		
 		default: {
-			if allowStatements {
+			if allowStatements || p.topLevelStatements {
 				return p.statement()
 			} else {
 				p.error("Statements are not allowed at top-level.")
@@ -40,6 +54,7 @@ func (p *Parser) statement() ast.Statement {
 		case token.TokenBreakKw: return p.breakStatement()
 		case token.TokenContinueKw: return p.continueStatement()
 		case token.TokenReturnKw: return p.returnStatement()
+		case token.TokenTryKw: return p.tryStatement()
 		case token.TokenLeftBrace: return p.blockStatement()
 		
 		default: return p.exprStatement()
@@ -75,11 +90,57 @@ func (p *Parser) recordStatement() ast.Statement {
 	}
 }
 
+func (p *Parser) importStatement() ast.Statement {
+	keyword := p.advance()
+	names := []token.Token{}
+	alias := token.AbsentToken()
+
+	if p.check(token.TokenIdentifier) {
+		for {
+			names = append(names, p.expectToken(token.TokenIdentifier))
+
+			if !p.match(token.TokenComma) {
+				break
+			}
+		}
+
+		p.expect(token.TokenFromKw)
+	}
+
+	path := p.expectToken(token.TokenString)
+
+	if len(names) == 0 && p.match(token.TokenAsKw) {
+		alias = p.expectToken(token.TokenIdentifier)
+	}
+
+	p.requireSemicolon()
+
+	return ast.Statement{
+		Base: ast.AstBase{
+			Pos:    keyword.Pos,
+			Length: len(keyword.Lexeme),
+		},
+
+		Data: ast.ImportStatement{
+			Path:  path,
+			Alias: alias,
+			Names: names,
+		},
+	}
+}
+
 func (p *Parser) fnStatement() ast.Statement {
 	keyword := p.advance()
 
 	name := p.expectToken(token.TokenIdentifier)
 	parameters := p.parseParameters()
+
+	var returnType *ast.TypeAnnotation = nil
+
+	if p.match(token.TokenArrow) {
+		returnType = p.parseType()
+	}
+
 	body := p.parseBlock()
 
 	return ast.Statement{
@@ -90,6 +151,7 @@ func (p *Parser) fnStatement() ast.Statement {
 		Data: ast.FnStatement{
 			Name: name,
 			Parameters: parameters,
+			ReturnType: returnType,
 			Body: body,
 		},
 	}
@@ -280,9 +342,42 @@ func (p *Parser) continueStatement() ast.Statement {
 	}
 }
 
+func (p *Parser) tryStatement() ast.Statement {
+	keyword := p.advance()
+	body := p.parseBlock()
+
+	p.expect(token.TokenCatchKw)
+	variable := token.AbsentToken()
+
+	if p.check(token.TokenIdentifier) {
+		variable = p.advance()
+	}
+
+	catch := p.parseBlock()
+
+	return ast.Statement{
+		Base: ast.AstBase{
+			Pos: keyword.Pos,
+			Length: len(keyword.Lexeme),
+		},
+		Data: ast.TryStatement{
+			Body: body,
+			Variable: variable,
+			Catch: catch,
+		},
+	}
+}
+
 func (p *Parser) varStatement() ast.Statement {
 	keyword := p.advance()
 	name := p.expectToken(token.TokenIdentifier)
+
+	var type_ *ast.TypeAnnotation = nil
+
+	if p.match(token.TokenColon) {
+		type_ = p.parseType()
+	}
+
 	p.expect(token.TokenEqual)
 
 	expr := p.expression(0)
@@ -295,6 +390,7 @@ func (p *Parser) varStatement() ast.Statement {
 		},
 		Data: ast.VarStatement{
 			Name: name,
+			Type: type_,
 			Init: expr,
 		},
 	}
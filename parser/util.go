@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"vm-go/ast"
 	"vm-go/token"
-	"vm-go/util"
 )
 
 func (p *Parser) parseBlock() ast.BlockStatement {
@@ -39,8 +38,15 @@ func (p *Parser) parseParameters() []ast.Parameter {
 
 	for !p.match(token.TokenRightParen) && !p.isAtEnd(0) && !p.panicMode {
 		name := p.expectToken(token.TokenIdentifier)
+		var type_ *ast.TypeAnnotation = nil
+
+		if p.match(token.TokenColon) {
+			type_ = p.parseType()
+		}
+
 		params = append(params, ast.Parameter{
 			Name: name,
+			Type: type_,
 		})
 
 		if !p.check(token.TokenRightParen) {
@@ -62,6 +68,38 @@ func (p *Parser) parseFields() []ast.Field {
 	return fields
 }
 
+// type: ( 'fn' '(' ( type ',' )* ')' ( '->' type )? | identifier | 'void' ) '?'?
+func (p *Parser) parseType() *ast.TypeAnnotation {
+	annotation := ast.TypeAnnotation{}
+
+	if p.check(token.TokenFnKw) {
+		annotation.Name = p.advance()
+		annotation.IsFn = true
+		annotation.Params = []ast.TypeAnnotation{}
+
+		p.expect(token.TokenLeftParen)
+
+		for !p.match(token.TokenRightParen) && !p.isAtEnd(0) && !p.panicMode {
+			annotation.Params = append(annotation.Params, *p.parseType())
+
+			if !p.check(token.TokenRightParen) {
+				p.expect(token.TokenComma)
+			}
+		}
+
+		if p.match(token.TokenArrow) {
+			annotation.Return = p.parseType()
+		}
+	} else if p.check(token.TokenVoidKw) {
+		annotation.Name = p.advance()
+	} else {
+		annotation.Name = p.expectToken(token.TokenIdentifier)
+	}
+
+	annotation.Nullable = p.match(token.TokenQuestion)
+	return &annotation
+}
+
 func (p *Parser) parseExpression() ast.Expression {
 	return p.expression(PrecLowest)
 }
@@ -150,6 +188,16 @@ func (p *Parser) makeAssignment(left ast.Expression, right ast.Expression, opera
 			},
 		}
 
+	case ast.IndexExpression:
+		return ast.Expression{
+			Base: left.Base,
+			Data: ast.SetIndexExpression{
+				Left:  lValue.Left,
+				Index: lValue.Index,
+				Value: right,
+			},
+		}
+
 	default:
 		p.error(fmt.Sprintf("Invalid assignment target: '%v'.", left))
 		return ast.Expression{}
@@ -168,24 +216,44 @@ func (p *Parser) isAtEnd(offset int) bool {
 	return p.current + offset >= len(p.tokens)
 }
 
+// Skips the rest of the statement with the error, to the next one. The blocks inside it are skipped whole, so their
+// statements aren't parsed out of place, and it stops at the brace that closes the block the statement is in.
+// It never stops at a token the next statement can't start with, or it would fail there again, forever.
 func (p *Parser) synchronize() {
-    p.panicMode = false
-
-    for !p.isAtEnd(0) {
-        kind := p.peek(0).Kind
-
-        // Return if a synchronization point is found
-        switch kind {
-			case token.TokenVarKw, token.TokenLeftBrace, token.TokenRightBrace,
-				token.TokenIfKw, token.TokenElseKw, token.TokenWhileKw, token.TokenBreakKw, token.TokenContinueKw,
-				token.TokenForKw, token.TokenFnKw, token.TokenReturnKw, token.TokenRecordKw,
-				token.TokenSemicolon:
-				return
-        }
+	p.panicMode = false
+	depth := 0
+
+	for !p.isAtEnd(0) {
+		switch p.peek(0).Kind {
+			case token.TokenLeftBrace:
+				depth++
+
+			case token.TokenRightBrace: {
+				if depth == 0 {
+					return
+				}
+
+				depth--
+			}
+
+			case token.TokenSemicolon: {
+				if depth == 0 {
+					p.advance()
+					return
+				}
+			}
+
+			case token.TokenVarKw, token.TokenIfKw, token.TokenWhileKw, token.TokenForKw, token.TokenLoopKw,
+				token.TokenBreakKw, token.TokenContinueKw, token.TokenFnKw, token.TokenReturnKw, token.TokenRecordKw,
+				token.TokenTryKw, token.TokenImportKw: {
+				if depth == 0 {
+					return
+				}
+			}
+		}
 
-        // Advance to the next token if no synchronization point is found
-        p.advance()
-    }
+		p.advance()
+	}
 }
 
 
@@ -206,7 +274,7 @@ func (p *Parser) rawError(message string, len int, pos token.Position) {
 		return
 	}
 	
-	util.Error(pos, len, message, p.fileData)
+	p.diagnostics.Error(pos, len, message, p.fileData)
 
 	p.hadError = true
 	p.panicMode = true
@@ -0,0 +1,306 @@
+package profiler
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+	"vm-go/disassembler"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+// Counts what a program runs, to find where it spends its time: the instructions by opcode, the calls and
+// the time of each function, and the instructions of each line. It's a hook of the VM, so the counts are exact,
+// but the program runs slower, and the times are only good to compare the functions between them.
+type Profiler struct {
+	instructions int
+	opcodes      [256]int
+	lines        map[line]int
+	functions    map[string]*function
+
+	// The instructions run by each stack of calls, from the top-level code, for the flame graphs.
+	stacks map[string]int
+
+	// The calls running, the top-level code first, the key of their stack, and when the last one started or ended.
+	calls []*call
+	stack string
+	last  time.Time
+
+	started time.Time
+	elapsed time.Duration
+}
+
+type Format int
+
+const (
+	FormatNone Format = iota
+	FormatTable
+	FormatFolded
+)
+
+func ParseFormat(name string) (Format, bool) {
+	switch name {
+		case "table": return FormatTable, true
+		case "folded": return FormatFolded, true
+		default: return FormatNone, false
+	}
+}
+
+type function struct {
+	name     string
+	position string // where its code starts, to tell apart the functions with the same name
+
+	calls        int
+	instructions int           // run by the function itself
+	total        time.Duration // with the functions it calls
+	self         time.Duration
+
+	// How many of its calls are running, so the time of the recursive ones isn't counted twice.
+	active int
+}
+
+type call struct {
+	function *function
+	start    time.Time
+}
+
+type line struct {
+	file *util.FileData
+	line int
+}
+
+// The profile of the runs starts here.
+func New() *Profiler {
+	now := time.Now()
+
+	p := &Profiler{
+		lines: map[line]int{},
+		functions: map[string]*function{},
+		stacks: map[string]int{},
+
+		calls: []*call{},
+		last: now,
+		started: now,
+	}
+
+	p.enter(nil, now)
+	return p
+}
+
+func (p *Profiler) Step(v *vm.VM) bool {
+	// The calls and the returns are seen by the depth of the next instruction.
+	if depth := v.Depth() + 1; depth != len(p.calls) {
+		now := time.Now()
+		p.current().self += now.Sub(p.last)
+		p.last = now
+
+		for len(p.calls) > depth {
+			p.leave(now)
+		}
+
+		for len(p.calls) < depth {
+			p.enter(v.Function(), now)
+		}
+	}
+
+	p.instructions++
+	p.opcodes[v.Instruction()]++
+	p.current().instructions++
+	p.stacks[p.stack]++
+
+	if meta := v.Position(); meta.File != nil {
+		p.lines[line{ file: meta.File, line: meta.Position.Line }]++
+	}
+
+	return true
+}
+
+// Ends the calls still running, like the ones of a runtime error, and the top-level code.
+func (p *Profiler) Stop() {
+	now := time.Now()
+	p.current().self += now.Sub(p.last)
+	p.last = now
+
+	for len(p.calls) > 0 {
+		p.leave(now)
+	}
+
+	p.elapsed = now.Sub(p.started)
+}
+
+func (p *Profiler) current() *function {
+	return p.calls[len(p.calls) - 1].function
+}
+
+// 'fn' is nil for the top-level code.
+func (p *Profiler) enter(fn *value.ValueFunction, now time.Time) {
+	name, position := "<top-level>", ""
+
+	if fn != nil {
+		name = "<anonymous>"
+
+		if fn.Name != nil {
+			name = *fn.Name
+		}
+
+		if len(fn.Chunk.Metadata) > 0 && fn.Chunk.Metadata[0].File != nil {
+			meta := fn.Chunk.Metadata[0]
+			position = fmt.Sprintf("%s:%d", meta.File.Name, meta.Position.Line + 1)
+		}
+	}
+
+	key := name + " " + position
+	f, ok := p.functions[key]
+
+	if !ok {
+		f = &function{ name: name, position: position }
+		p.functions[key] = f
+	}
+
+	f.calls++
+	f.active++
+	p.calls = append(p.calls, &call{ function: f, start: now })
+	p.updateStack()
+}
+
+func (p *Profiler) leave(now time.Time) {
+	c := util.PopList(&p.calls)
+	c.function.active--
+
+	if c.function.active == 0 {
+		c.function.total += now.Sub(c.start)
+	}
+
+	p.updateStack()
+}
+
+func (p *Profiler) updateStack() {
+	names := make([]string, len(p.calls))
+
+	for i, c := range p.calls {
+		names[i] = c.function.name
+	}
+
+	p.stack = strings.Join(names, ";")
+}
+
+// ---
+
+// How many lines the table shows, the ones that run the most instructions.
+const hotLines = 10
+
+func (p *Profiler) Write(w io.Writer, format Format) {
+	switch format {
+		case FormatTable:
+			p.writeTable(w)
+
+		case FormatFolded:
+			p.writeFolded(w)
+	}
+}
+
+func (p *Profiler) writeTable(w io.Writer) {
+	fmt.Fprintf(w, "Profile: %d instructions in %s\n\n", p.instructions, p.elapsed.Round(time.Microsecond))
+
+	fmt.Fprintln(w, "Opcodes:")
+	fmt.Fprintf(w, "  %10s  %7s  %s\n", "count", "%", "instruction")
+
+	opcodes := []int{}
+
+	for opcode, count := range p.opcodes {
+		if count > 0 {
+			opcodes = append(opcodes, opcode)
+		}
+	}
+
+	slices.SortStableFunc(opcodes, func(a, b int) int {
+		return p.opcodes[b] - p.opcodes[a]
+	})
+
+	for _, opcode := range opcodes {
+		fmt.Fprintf(w, "  %10d  %7s  %s\n", p.opcodes[opcode], p.percent(p.opcodes[opcode]), disassembler.InstructionName(byte(opcode)))
+	}
+
+	fmt.Fprintln(w, "\nFunctions:")
+	fmt.Fprintf(w, "  %10s  %12s  %12s  %12s  %s\n", "calls", "total", "self", "instructions", "function")
+
+	functions := []*function{}
+
+	for _, f := range p.functions {
+		functions = append(functions, f)
+	}
+
+	slices.SortFunc(functions, func(a, b *function) int {
+		if a.total != b.total {
+			return cmp.Compare(b.total, a.total)
+		}
+
+		return strings.Compare(a.name + a.position, b.name + b.position)
+	})
+
+	for _, f := range functions {
+		name := f.name
+
+		if f.position != "" {
+			name = fmt.Sprintf("%s (%s)", f.name, f.position)
+		}
+
+		fmt.Fprintf(w, "  %10d  %12s  %12s  %12d  %s\n", f.calls, f.total.Round(time.Microsecond), f.self.Round(time.Microsecond), f.instructions, name)
+	}
+
+	fmt.Fprintln(w, "\nLines:")
+	fmt.Fprintf(w, "  %10s  %7s  %s\n", "count", "%", "line")
+
+	lines := []line{}
+
+	for l := range p.lines {
+		lines = append(lines, l)
+	}
+
+	slices.SortFunc(lines, func(a, b line) int {
+		if p.lines[a] != p.lines[b] {
+			return p.lines[b] - p.lines[a]
+		}
+
+		if a.file.Name != b.file.Name {
+			return strings.Compare(a.file.Name, b.file.Name)
+		}
+
+		return a.line - b.line
+	})
+
+	for _, l := range lines[:min(hotLines, len(lines))] {
+		source := ""
+
+		// Programs loaded from bytecode may not have their source.
+		if l.line < len(l.file.Lines) {
+			source = " | " + strings.TrimSpace(l.file.Lines[l.line])
+		}
+
+		fmt.Fprintf(w, "  %10d  %7s  %s:%d%s\n", p.lines[l], p.percent(p.lines[l]), l.file.Name, l.line + 1, source)
+	}
+}
+
+func (p *Profiler) percent(count int) string {
+	return fmt.Sprintf("%.1f%%", float64(count) * 100 / float64(max(p.instructions, 1)))
+}
+
+// The format of the flame graph tools, like 'flamegraph.pl' and speedscope: one line per stack of calls,
+// with the functions separated by ';', and the instructions it ran as the weight.
+func (p *Profiler) writeFolded(w io.Writer) {
+	stacks := []string{}
+
+	for stack := range p.stacks {
+		stacks = append(stacks, stack)
+	}
+
+	slices.Sort(stacks)
+
+	for _, stack := range stacks {
+		fmt.Fprintf(w, "%s %d\n", stack, p.stacks[stack])
+	}
+}
@@ -0,0 +1,119 @@
+package profiler
+
+import (
+	"strings"
+	"testing"
+	"vm-go/checker"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/vm"
+)
+
+const program = `fn fib(n) {
+    if n < 2 {
+        return n;
+    }
+
+    return fib(n - 1) + fib(n - 2);
+}
+
+fn main() {
+    println(fib(5));
+}`
+
+func TestProfile(t *testing.T) {
+	p := profile(t, "fib.vm", program)
+
+	if fib := p.functions["fib fib.vm:2"]; fib == nil || fib.calls != 15 {
+		t.Errorf("expected 15 calls of 'fib', got %+v", fib)
+	}
+
+	// Every instruction is counted once by opcode, by function and by stack.
+	opcodes, functions, stacks := 0, 0, 0
+
+	for _, count := range p.opcodes {
+		opcodes += count
+	}
+
+	for _, f := range p.functions {
+		functions += f.instructions
+	}
+
+	for _, count := range p.stacks {
+		stacks += count
+	}
+
+	if opcodes != p.instructions || functions != p.instructions || stacks != p.instructions {
+		t.Errorf("expected %d instructions everywhere, got %d by opcode, %d by function and %d by stack", p.instructions, opcodes, functions, stacks)
+	}
+
+	table := strings.Builder{}
+	p.Write(&table, FormatTable)
+
+	for _, expected := range []string{ "CALL", "fib (fib.vm:2)", "fib.vm:6 | return fib(n - 1) + fib(n - 2);" } {
+		if !strings.Contains(table.String(), expected) {
+			t.Errorf("expected '%s' in the table:\n%s", expected, table.String())
+		}
+	}
+
+	folded := strings.Builder{}
+	p.Write(&folded, FormatFolded)
+
+	// The deepest calls are fib(1) and fib(0), under four more calls of 'fib'.
+	if !strings.Contains(folded.String(), "<top-level>;main;fib;fib;fib;fib;fib ") {
+		t.Errorf("expected the deepest stack in the folded stacks:\n%s", folded.String())
+	}
+}
+
+// The calls left running by an error end with the run.
+func TestProfileError(t *testing.T) {
+	p := profile(t, "fail.vm", `fn fail() {
+    return 1 / 0;
+}
+
+fn main() {
+    fail();
+}`)
+
+	if len(p.calls) != 0 {
+		t.Errorf("expected no calls running, got %d", len(p.calls))
+	}
+
+	if fail := p.functions["fail fail.vm:2"]; fail == nil || fail.calls != 1 {
+		t.Errorf("expected a call of 'fail', got %+v", fail)
+	}
+}
+
+func profile(t *testing.T, name, source string) *Profiler {
+	streams := util.NewStreams(&strings.Builder{}, &strings.Builder{}, strings.NewReader(""))
+	fileData := util.FileData{ Name: name, Path: name, Lines: strings.Split(source, "\n") }
+	registry := vm.DefaultRegistry(streams)
+
+	tokens, hadError := lexer.NewLexer(source, &fileData, streams).Lex()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	ast, hadError := parser.NewParser(tokens, &fileData, streams).Parse()
+
+	if hadError || checker.NewChecker(ast, &fileData, registry, streams).Check() {
+		t.Fatal("the program has errors")
+	}
+
+	chunk, hadError := compiler.NewCompiler(ast, &fileData, registry, streams).Compile()
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	p := New()
+	v := vm.NewVM(chunk, &fileData, registry, streams)
+	v.AddHook(p)
+	v.Run()
+	p.Stop()
+
+	return p
+}
@@ -0,0 +1,167 @@
+package repl
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"vm-go/ast"
+	"vm-go/checker"
+	"vm-go/compiler"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+// Every input is compiled and run against the same VM, so the globals, records and functions of the
+// previous inputs are still there. The value of an input that ends with an expression is echoed.
+// An input continues on the next line while its braces, brackets or parentheses aren't closed,
+// and its final ';' can be left out.
+type Repl struct {
+	registry *value.Registry
+	checker  *checker.Checker
+	compiler *compiler.Compiler
+	vm       *vm.VM
+
+	streams *util.Streams
+}
+
+const (
+	prompt             = "> "
+	continuationPrompt = "... "
+)
+
+func New(streams *util.Streams) *Repl {
+	registry := vm.DefaultRegistry(streams)
+	fileData := util.FileData{ Name: "<repl>", Path: "<repl>", Lines: []string{} }
+
+	return &Repl{
+		registry: registry,
+		checker: checker.NewChecker([]ast.Statement{}, &fileData, registry, streams),
+		compiler: compiler.NewInputCompiler(&fileData, registry, streams),
+		vm: vm.NewVM(value.Chunk{}, &fileData, registry, streams),
+
+		streams: streams,
+	}
+}
+
+// Reads inputs until the end of stdin.
+func (r *Repl) Start() {
+	for {
+		input, ok := r.read()
+
+		if !ok {
+			fmt.Fprintln(r.streams.Stdout)
+			return
+		}
+
+		if strings.TrimSpace(input) == "" {
+			continue
+		}
+
+		r.Eval(input)
+	}
+}
+
+// Returns false at the end of stdin, if there's nothing left to run.
+func (r *Repl) read() (string, bool) {
+	fmt.Fprint(r.streams.Stdout, prompt)
+	lines := []string{}
+
+	for {
+		line, err := r.streams.Stdin.ReadString('\n')
+
+		if err != nil && (err != io.EOF || line == "") {
+			return strings.Join(lines, "\n"), len(lines) > 0
+		}
+
+		lines = append(lines, strings.TrimRight(line, "\r\n"))
+		input := strings.Join(lines, "\n")
+
+		if err == io.EOF || depth(input) <= 0 {
+			return input, true
+		}
+
+		fmt.Fprint(r.streams.Stdout, continuationPrompt)
+	}
+}
+
+// Compiles and runs an input, and prints its value. Errors are printed, and the declarations of a failed input are discarded.
+func (r *Repl) Eval(input string) {
+	source := strings.TrimRight(input, " \t\n")
+
+	if !strings.HasSuffix(source, ";") && !strings.HasSuffix(source, "}") {
+		source += ";"
+	}
+
+	fileData := util.FileData{
+		Name: "<repl>",
+		Path: "<repl>",
+		Lines: strings.Split(source, "\n"),
+	}
+
+	// Each input is a compilation of its own, whose errors don't hide the warnings of the next ones.
+	diagnostics := util.NewDiagnostics(r.streams.Stderr, 0)
+	r.checker.SetDiagnostics(diagnostics)
+	r.compiler.SetDiagnostics(diagnostics)
+
+	checkpoint := r.compiler.Checkpoint()
+	chunk, hadError := r.compiler.CompileSource(source, &fileData, r.checker, compiler.ModeInput)
+
+	if hadError {
+		r.compiler.Rollback(checkpoint)
+		return
+	}
+
+	result, status := r.vm.RunChunk(chunk)
+
+	if status != vm.STATUS_OK {
+		r.compiler.Rollback(checkpoint)
+		r.vm.DiscardGlobals(checkpoint.GlobalCount())
+		return
+	}
+
+	if _, ok := result.(value.ValueVoid); !ok {
+		fmt.Fprintln(r.streams.Stdout, result.String())
+	}
+}
+
+// Returns how many braces, brackets and parentheses are still open, skipping strings and comments.
+func depth(source string) int {
+	depth := 0
+	var quote rune = 0
+
+	for i := 0; i < len(source); i++ {
+		c := rune(source[i])
+
+		if quote != 0 {
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+
+			continue
+		}
+
+		switch c {
+			case '"', '`':
+				quote = c
+
+			case '/': {
+				if i + 1 < len(source) && source[i + 1] == '/' {
+					for i < len(source) && source[i] != '\n' {
+						i++
+					}
+				}
+			}
+
+			case '{', '[', '(':
+				depth++
+
+			case '}', ']', ')':
+				depth--
+		}
+	}
+
+	return depth
+}
@@ -0,0 +1,111 @@
+package repl
+
+import (
+	"strings"
+	"testing"
+	"vm-go/util"
+)
+
+func session(input string) (string, string) {
+	stdout := strings.Builder{}
+	stderr := strings.Builder{}
+
+	New(util.NewStreams(&stdout, &stderr, strings.NewReader(input))).Start()
+	return stdout.String(), stderr.String()
+}
+
+func TestSession(t *testing.T) {
+	stdout, stderr := session(`var x = 10
+x + 1
+fn add(a, b) {
+    return a +
+        b;
+}
+add(x, 2)
+`)
+
+	// The globals persist between the inputs, the values of the expressions are printed,
+	// and the input goes on while its braces and parentheses are open.
+	if expected := "> > 11\n> ... ... ... > 12\n> \n"; stdout != expected {
+		t.Errorf("expected the output '%s', got '%s'", expected, stdout)
+	}
+
+	if stderr != "" {
+		t.Errorf("expected no errors, got:\n%s", stderr)
+	}
+}
+
+// The globals of an input that fails to compile or to run are discarded, and the others are kept.
+func TestRollback(t *testing.T) {
+	stdout, stderr := session(`var x = 10
+var y = [1][5]
+y
+var w = nope
+w
+var y = "again"
+y
+x
+`)
+
+	if expected := "> > > > > > > again\n> 10\n> \n"; stdout != expected {
+		t.Errorf("expected the output '%s', got '%s'", expected, stdout)
+	}
+
+	for _, message := range []string{
+		"Index '5' is out of bounds",
+		"'y' doesn't exist in this or in a parent scope.",
+		"'nope' doesn't exist in this or in a parent scope.",
+		"'w' doesn't exist in this or in a parent scope.",
+	} {
+		if !strings.Contains(stderr, message) {
+			t.Errorf("expected the error '%s', got:\n%s", message, stderr)
+		}
+	}
+}
+
+// The names of an input are resolved even if its types are wrong.
+func TestStages(t *testing.T) {
+	_, stderr := session(`var a: num = "one"; var b = missing;
+`)
+
+	for _, message := range []string{ "Cannot initialize 'a'", "'missing' doesn't exist" } {
+		if !strings.Contains(stderr, message) {
+			t.Errorf("expected the error '%s', got:\n%s", message, stderr)
+		}
+	}
+}
+
+// The builtins are kept when the first input fails.
+func TestFirstInputFails(t *testing.T) {
+	stdout, stderr := session(`1 / 0
+var t = 5
+t + 1
+println("still here")
+`)
+
+	if expected := "> > > 6\n> still here\n> \n"; stdout != expected {
+		t.Errorf("expected the output '%s', got '%s'", expected, stdout)
+	}
+
+	if !strings.Contains(stderr, "Cannot divide by zero.") {
+		t.Errorf("expected the runtime error, got:\n%s", stderr)
+	}
+}
+
+func TestDepth(t *testing.T) {
+	cases := map[string]int{
+		"fn f() {": 1,
+		"fn f() { return [1, (2": 3,
+		"}": -1,
+		`"{" + "("`: 0,
+		"`{`": 0,
+		`"\"{"`: 0,
+		"x // {": 0,
+	}
+
+	for source, expected := range cases {
+		if got := depth(source); got != expected {
+			t.Errorf("'%s': expected %d, got %d", source, expected, got)
+		}
+	}
+}
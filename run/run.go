@@ -1,11 +1,18 @@
 package run
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
+	"vm-go/ast"
+	"vm-go/bytecode"
 	"vm-go/compiler"
+	"vm-go/coverage"
 	"vm-go/disassembler"
-	"vm-go/lexer"
-	"vm-go/parser"
+	"vm-go/profiler"
+	"vm-go/tracer"
 	"vm-go/util"
 	"vm-go/value"
 	"vm-go/vm"
@@ -18,52 +25,198 @@ const (
 	ModeDisassemble
 )
 
-func Run(source, fileName string, mode RunMode) {
+type Options struct {
+	Mode RunMode
+
+	// Compiles without the optimizations, like with '-O0'.
+	NoOptimize bool
+
+	// Reports the warnings of the compiler as errors, like with '--Werror', so the program doesn't run.
+	WarningsAsErrors bool
+
+	// The most compile errors printed, 0 for no limit. The compilation stops looking for more after them.
+	MaxErrors int
+
+	// The limits of the VM, the ones left at 0 keep their default.
+	Limits vm.Limits
+
+	// How long the program can run, 0 for no limit.
+	Timeout time.Duration
+
+	// What the natives can touch, nil for everything.
+	Sandbox *value.Sandbox
+
+	// Profiles the run, and writes the report to 'ProfileFile', or to stderr without one.
+	Profile     profiler.Format
+	ProfileFile string
+
+	// Records the lines that run, and writes the report to 'CoverFile', or to stderr without one.
+	Cover     coverage.Format
+	CoverFile string
+
+	// Prints each instruction to stderr as it runs, nil for no trace.
+	Trace *tracer.Options
+}
+
+func Run(source, fileName string, options Options, streams *util.Streams) {
 	fileData := util.FileData{
 		Name: util.GetFileName(fileName),
+		Path: fileName,
 		Lines: strings.Split(source, "\n"),
 	}
 
-	chunk, hadError := compile(source, &fileData)
+	registry := vm.DefaultRegistry(streams)
+	registry.SetSandbox(options.Sandbox)
+	chunk, hadError := Compile(source, &fileData, registry, options, streams)
 
 	if hadError {
 		return
 	}
 	
-	switch mode {
+	switch options.Mode {
 		case ModeRun: {
-			vm_ := vm.NewVM(chunk, &fileData)
-			vm_.Run()
+			vm_ := newVM(chunk, &fileData, registry, options, streams)
+			execute(vm_, chunk, &fileData, options, streams)
 		}
 
 		case ModeDisassemble: {
 			diss := disassembler.NewDisassembler(chunk, &fileData)
+			diss.SetOutput(streams.Stdout)
 			diss.Disassemble()
 		}
 	}
 }
 
-func compile(source string, fileData *util.FileData) (value.Chunk, bool) {
-	lexer := lexer.NewLexer(source, fileData)
-	tokens, hadError := lexer.Lex()
+// Compiles the program and writes its bytecode to 'output', to run it later without the source.
+func Build(source, fileName, output string, options Options, streams *util.Streams) bool {
+	fileData := util.FileData{
+		Name: util.GetFileName(fileName),
+		Path: fileName,
+		Lines: strings.Split(source, "\n"),
+	}
+
+	registry := vm.DefaultRegistry(streams)
+	registry.SetSandbox(options.Sandbox)
+	chunk, hadError := Compile(source, &fileData, registry, options, streams)
 
 	if hadError {
-		return value.Chunk{}, true
+		return false
 	}
 
-	parser := parser.NewParser(tokens, fileData)
-	ast, hadError := parser.Parse()
+	file, err := os.Create(output)
 
-	if hadError {
-		return value.Chunk{}, true
+	if err != nil {
+		fmt.Fprintf(streams.Stderr, "Cannot create file: '%s'\n", output)
+		return false
 	}
 
-	compiler := compiler.NewCompiler(ast, fileData)
-	chunk_, hadError := compiler.Compile()
+	defer file.Close()
 
-	if hadError {
-		return value.Chunk{}, true
+	if err := bytecode.Write(file, chunk, &fileData, registry); err != nil {
+		fmt.Fprintf(streams.Stderr, "Cannot write the bytecode: %s\n", err)
+		return false
+	}
+
+	return true
+}
+
+// Runs a program built with 'Build'.
+func RunBytecode(data []byte, options Options, streams *util.Streams) {
+	registry := vm.DefaultRegistry(streams)
+	registry.SetSandbox(options.Sandbox)
+	chunk, fileData, err := bytecode.Read(data, registry)
+
+	if err != nil {
+		fmt.Fprintf(streams.Stderr, "Cannot load the bytecode: %s\n", err)
+		return
+	}
+
+	switch options.Mode {
+		case ModeRun: {
+			vm_ := newVM(chunk, fileData, registry, options, streams)
+			execute(vm_, chunk, fileData, options, streams)
+		}
+
+		case ModeDisassemble: {
+			diss := disassembler.NewDisassembler(chunk, fileData)
+			diss.SetOutput(streams.Stdout)
+			diss.Disassemble()
+		}
+	}
+}
+
+// The timeout starts counting here.
+func newVM(chunk value.Chunk, fileData *util.FileData, registry *value.Registry, options Options, streams *util.Streams) *vm.VM {
+	vm_ := vm.NewVM(chunk, fileData, registry, streams)
+	vm_.SetLimits(options.Limits)
+
+	if options.Timeout > 0 {
+		vm_.SetDeadline(time.Now().Add(options.Timeout))
+	}
+
+	return vm_
+}
+
+// Runs the program with the tools the options ask for, and writes their reports.
+func execute(vm_ *vm.VM, chunk value.Chunk, fileData *util.FileData, options Options, streams *util.Streams) {
+	var profile *profiler.Profiler
+
+	if options.Profile != profiler.FormatNone {
+		profile = profiler.New()
+		vm_.AddHook(profile)
+	}
+
+	if options.Trace != nil {
+		vm_.AddHook(tracer.New(fileData, *options.Trace, streams.Stderr))
 	}
 
-	return chunk_, false
+	var cover *coverage.Coverage
+
+	if options.Cover != coverage.FormatNone {
+		cover = coverage.New(chunk)
+		vm_.AddHook(cover)
+	}
+
+	vm_.Run()
+
+	if profile != nil {
+		profile.Stop()
+		writeReport(options.ProfileFile, streams, func(w io.Writer) {
+			profile.Write(w, options.Profile)
+		})
+	}
+
+	if cover != nil {
+		writeReport(options.CoverFile, streams, func(w io.Writer) {
+			cover.Write(w, options.Cover)
+		})
+	}
+}
+
+// The reports go to stderr, so they don't mix with the output of the program, unless they have a file.
+func writeReport(path string, streams *util.Streams, write func(w io.Writer)) {
+	if path == "" {
+		write(streams.Stderr)
+		return
+	}
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		fmt.Fprintf(streams.Stderr, "Cannot create file: '%s'\n", path)
+		return
+	}
+
+	defer file.Close()
+	write(file)
+}
+
+// Compiles the program, the errors are printed to the streams. The registry is the one the chunk must run with.
+func Compile(source string, fileData *util.FileData, registry *value.Registry, options Options, streams *util.Streams) (value.Chunk, bool) {
+	compiler_ := compiler.NewCompiler([]ast.Statement{}, fileData, registry, streams)
+	compiler_.SetOptimize(!options.NoOptimize)
+	compiler_.SetWarningsAsErrors(options.WarningsAsErrors)
+	compiler_.SetDiagnostics(util.NewDiagnostics(streams.Stderr, options.MaxErrors))
+
+	return compiler_.CompileSource(source, fileData, nil, compiler.ModeProgram)
 }
@@ -0,0 +1,42 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vm-go/util"
+)
+
+const program = `fn main() {
+    println(1);
+}`
+
+// The disassembly is written to the streams of the run, from the source and from the bytecode.
+func TestDisassembleToStreams(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "main.vmc")
+
+	if !Build(program, "main.vm", output, Options{}, util.NewStreams(&strings.Builder{}, &strings.Builder{}, strings.NewReader(""))) {
+		t.Fatal("the program doesn't build")
+	}
+
+	data, err := os.ReadFile(output)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runs := map[string]func(streams *util.Streams){
+		"source": func(streams *util.Streams) { Run(program, "main.vm", Options{ Mode: ModeDisassemble }, streams) },
+		"bytecode": func(streams *util.Streams) { RunBytecode(data, Options{ Mode: ModeDisassemble }, streams) },
+	}
+
+	for name, run := range runs {
+		stdout := strings.Builder{}
+		run(util.NewStreams(&stdout, &strings.Builder{}, strings.NewReader("")))
+
+		if !strings.Contains(stdout.String(), "PUSH_CLOSURE") {
+			t.Errorf("%s: expected the disassembly in the output, got '%s'", name, stdout.String())
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package tester
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The expectations of a program, written as comments next to the code:
+//
+//	println(1 + 2); // expect: 3
+//	var x: num = "a"; // expect error: Cannot initialize 'x'
+//	println([1][5]); // expect runtime error (col 15): Index out of bounds
+//	var unused = 1; // expect warning: 'unused' is declared but never used.
+//
+// Every 'expect:' is a line of stdout, in order. The errors and the warnings are expected on the line of the comment,
+// and their message must contain the text after the colon.
+type expectations struct {
+	output []expectedLine
+	errors []expectedError
+}
+
+type expectedLine struct {
+	line int
+	text string
+}
+
+type expectedError struct {
+	runtime bool
+	warning bool
+	line    int
+	col     int // 0 if any column is fine
+	message string
+}
+
+var expectRegex = regexp.MustCompile(`//\s*expect(?: (error|runtime error|warning))?(?: \(col (\d+)\))?:(.*)$`)
+
+func parseExpectations(source string) expectations {
+	e := expectations{
+		output: []expectedLine{},
+		errors: []expectedError{},
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		match := expectRegex.FindStringSubmatch(strings.TrimRight(line, "\r"))
+
+		if match == nil {
+			continue
+		}
+
+		text := strings.TrimPrefix(match[3], " ")
+
+		if match[1] == "" {
+			e.output = append(e.output, expectedLine{ line: i + 1, text: text })
+			continue
+		}
+
+		col := 0
+
+		if match[2] != "" {
+			col, _ = strconv.Atoi(match[2])
+		}
+
+		e.errors = append(e.errors, expectedError{
+			runtime: match[1] == "runtime error",
+			warning: match[1] == "warning",
+			line: i + 1,
+			col: col,
+			message: strings.TrimSpace(text),
+		})
+	}
+
+	return e
+}
+
+func (e expectations) isEmpty() bool {
+	return len(e.output) == 0 && len(e.errors) == 0
+}
+
+// ---
+
+// An error printed by the compiler or the VM, or a warning of the compiler.
+// The line is 0 if it has no position, like a missing main.
+type diagnostic struct {
+	runtime bool
+	warning bool
+	file    string
+	line    int
+	col     int
+	message string
+}
+
+var (
+	diagnosticRegex = regexp.MustCompile(`^\[-\] (Error|Runtime error|Warning): (.*)$`)
+	positionRegex   = regexp.MustCompile(`^ \|\s+\[-\] (.+) \((\d+), (\d+)\)$`)
+)
+
+// Reads the errors back from stderr, the source lines and the stack traces are skipped.
+func parseDiagnostics(stderr string) []diagnostic {
+	diagnostics := []diagnostic{}
+	lines := strings.Split(stderr, "\n")
+
+	for i, line := range lines {
+		match := diagnosticRegex.FindStringSubmatch(line)
+
+		if match == nil {
+			continue
+		}
+
+		d := diagnostic{
+			runtime: match[1] == "Runtime error",
+			warning: match[1] == "Warning",
+			message: match[2],
+		}
+
+		if i + 1 < len(lines) {
+			if position := positionRegex.FindStringSubmatch(lines[i + 1]); position != nil {
+				d.file = position[1]
+				d.line, _ = strconv.Atoi(position[2])
+				d.col, _ = strconv.Atoi(position[3])
+			}
+		}
+
+		diagnostics = append(diagnostics, d)
+	}
+
+	return diagnostics
+}
+
+func (e expectedError) matches(d diagnostic, file string) bool {
+	return e.runtime == d.runtime &&
+		e.warning == d.warning &&
+		d.file == file &&
+		e.line == d.line &&
+		(e.col == 0 || e.col == d.col) &&
+		strings.Contains(d.message, e.message)
+}
+
+func (e expectedError) String() string {
+	kind := "an error"
+
+	if e.runtime {
+		kind = "a runtime error"
+	} else if e.warning {
+		kind = "a warning"
+	}
+
+	if e.col != 0 {
+		return fmt.Sprintf("%s at (%d, %d) containing '%s'", kind, e.line, e.col, e.message)
+	}
+
+	return fmt.Sprintf("%s at line %d containing '%s'", kind, e.line, e.message)
+}
+
+func (d diagnostic) String() string {
+	kind := "error"
+
+	if d.runtime {
+		kind = "runtime error"
+	} else if d.warning {
+		kind = "warning"
+	}
+
+	if d.line == 0 {
+		return fmt.Sprintf("%s: %s", kind, d.message)
+	}
+
+	return fmt.Sprintf("%s at %s (%d, %d): %s", kind, d.file, d.line, d.col, d.message)
+}
@@ -0,0 +1,244 @@
+package tester
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"vm-go/run"
+	"vm-go/util"
+)
+
+// Runs the programs in 'tests/' and compares what they print with what's expected.
+//
+// A program can have a golden file next to it, 'name.out', with everything it writes to stdout and stderr.
+// Otherwise its expectations are read from '// expect' comments, see 'expectations'.
+// Programs with neither, like the modules imported by other tests, are skipped.
+// The input of a program is read from 'name.in', if present.
+
+type Result struct {
+	Path     string
+	Skipped  bool
+	Failures []string
+}
+
+type Options struct {
+	// Rewrites the existing golden files with the current output, instead of comparing them.
+	Update bool
+
+	// How the programs are compiled and run.
+	Run run.Options
+}
+
+func (r Result) Passed() bool {
+	return !r.Skipped && len(r.Failures) == 0
+}
+
+type Summary struct {
+	Passed  int
+	Failed  int
+	Skipped int
+}
+
+// Returns the '.vm' files in the paths, searching the directories recursively.
+func Collect(paths []string) ([]string, error) {
+	files := []string{}
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !entry.IsDir() && filepath.Ext(file) == ".vm" {
+				files = append(files, file)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// Runs all the files, reporting each one and the summary to 'w'.
+func RunAll(paths []string, options Options, w io.Writer) (Summary, error) {
+	files, err := Collect(paths)
+
+	if err != nil {
+		return Summary{}, err
+	}
+
+	summary := Summary{}
+
+	for _, file := range files {
+		result := RunFile(file, options)
+
+		switch {
+			case result.Skipped: {
+				summary.Skipped++
+			}
+
+			case result.Passed(): {
+				summary.Passed++
+				fmt.Fprintf(w, "[+] %s\n", file)
+			}
+
+			default: {
+				summary.Failed++
+				fmt.Fprintf(w, "[-] %s\n", file)
+
+				for _, failure := range result.Failures {
+					fmt.Fprintf(w, " | %s\n", failure)
+				}
+
+				fmt.Fprintln(w, "[-]")
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped.\n", summary.Passed, summary.Failed, summary.Skipped)
+	return summary, nil
+}
+
+func RunFile(path string, options Options) Result {
+	result := Result{
+		Path: path,
+		Failures: []string{},
+	}
+
+	source, err := os.ReadFile(path)
+
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+
+	golden := strings.TrimSuffix(path, ".vm") + ".out"
+
+	if expected, err := os.ReadFile(golden); err == nil {
+		// stdout and stderr share the buffer, so the errors stay in place between the printed lines.
+		output := bytes.Buffer{}
+		execute(path, string(source), options.Run, &output, &output)
+
+		if options.Update {
+			if err := os.WriteFile(golden, output.Bytes(), 0644); err != nil {
+				result.Failures = append(result.Failures, err.Error())
+			}
+
+			return result
+		}
+
+		result.Failures = compareGolden(string(expected), output.String())
+		return result
+	}
+
+	e := parseExpectations(string(source))
+
+	if e.isEmpty() {
+		result.Skipped = true
+		return result
+	}
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	execute(path, string(source), options.Run, &stdout, &stderr)
+
+	result.Failures = append(result.Failures, compareOutput(e.output, stdout.String())...)
+	result.Failures = append(result.Failures, compareErrors(e.errors, parseDiagnostics(stderr.String()), util.GetFileName(path))...)
+
+	return result
+}
+
+func execute(path, source string, options run.Options, stdout, stderr io.Writer) {
+	// A missing input file is just an empty input.
+	input, _ := os.ReadFile(strings.TrimSuffix(path, ".vm") + ".in")
+
+	streams := util.NewStreams(stdout, stderr, bytes.NewReader(input))
+	run.Run(source, path, options, streams)
+}
+
+// ---
+
+func compareGolden(expected, got string) []string {
+	if expected == got {
+		return []string{}
+	}
+
+	expectedLines := strings.Split(expected, "\n")
+	gotLines := strings.Split(got, "\n")
+
+	for i := 0; i < len(expectedLines) && i < len(gotLines); i++ {
+		if expectedLines[i] != gotLines[i] {
+			return []string{ fmt.Sprintf("Line %d of the output: expected '%s', but got '%s'.", i + 1, expectedLines[i], gotLines[i]) }
+		}
+	}
+
+	return []string{ fmt.Sprintf("Expected %d line(s) of output, but got %d.", len(expectedLines), len(gotLines)) }
+}
+
+func compareOutput(expected []expectedLine, stdout string) []string {
+	failures := []string{}
+	lines := strings.Split(stdout, "\n")
+
+	// A trailing newline doesn't start another line.
+	if lines[len(lines) - 1] == "" {
+		lines = lines[:len(lines) - 1]
+	}
+
+	for i, e := range expected {
+		if i >= len(lines) {
+			failures = append(failures, fmt.Sprintf("Line %d: expected '%s', but nothing more was printed.", e.line, e.text))
+			continue
+		}
+
+		if lines[i] != e.text {
+			failures = append(failures, fmt.Sprintf("Line %d: expected '%s', but got '%s'.", e.line, e.text, lines[i]))
+		}
+	}
+
+	for _, line := range lines[min(len(expected), len(lines)):] {
+		failures = append(failures, fmt.Sprintf("Unexpected output '%s'.", line))
+	}
+
+	return failures
+}
+
+// Every expected error must match one printed error, in any order, and every printed error must be expected.
+func compareErrors(expected []expectedError, diagnostics []diagnostic, file string) []string {
+	failures := []string{}
+	matched := make([]bool, len(diagnostics))
+
+	for _, e := range expected {
+		found := false
+
+		for i, d := range diagnostics {
+			if !matched[i] && e.matches(d, file) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			failures = append(failures, fmt.Sprintf("Expected %s, but it wasn't printed.", e))
+		}
+	}
+
+	for i, d := range diagnostics {
+		if !matched[i] {
+			failures = append(failures, fmt.Sprintf("Unexpected %s", d))
+		}
+	}
+
+	return failures
+}
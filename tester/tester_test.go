@@ -0,0 +1,150 @@
+package tester
+
+import (
+	"strings"
+	"testing"
+	"vm-go/run"
+)
+
+// Runs every program in 'tests/', like 'vm test'.
+func TestPrograms(t *testing.T) {
+	runPrograms(t, Options{})
+}
+
+// The optimizations don't change what the programs do.
+func TestProgramsUnoptimized(t *testing.T) {
+	runPrograms(t, Options{ Run: run.Options{ NoOptimize: true } })
+}
+
+func runPrograms(t *testing.T, options Options) {
+	files, err := Collect([]string{"../tests"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			result := RunFile(file, options)
+
+			if result.Skipped {
+				t.Skip("no expectations")
+			}
+
+			for _, failure := range result.Failures {
+				t.Error(failure)
+			}
+		})
+	}
+}
+
+func TestParseExpectations(t *testing.T) {
+	source := `fn main() {
+    println(1); // expect: 1
+    println(""); // expect:
+    println(x); // expect error: Undefined
+    println(1 / 0); // expect runtime error (col 15): Cannot divide by zero.
+}`
+
+	e := parseExpectations(source)
+
+	if len(e.output) != 2 || e.output[0] != (expectedLine{ line: 2, text: "1" }) || e.output[1] != (expectedLine{ line: 3, text: "" }) {
+		t.Errorf("wrong output: %+v", e.output)
+	}
+
+	expected := []expectedError{
+		{ runtime: false, line: 4, col: 0, message: "Undefined" },
+		{ runtime: true, line: 5, col: 15, message: "Cannot divide by zero." },
+	}
+
+	if len(e.errors) != len(expected) {
+		t.Fatalf("wrong errors: %+v", e.errors)
+	}
+
+	for i := range expected {
+		if e.errors[i] != expected[i] {
+			t.Errorf("error %d: expected %+v, got %+v", i, expected[i], e.errors[i])
+		}
+	}
+}
+
+func TestCompareErrors(t *testing.T) {
+	diagnostics := parseDiagnostics(`[-] Runtime error: Cannot divide by zero. (left: '1', right: '0')
+ |   [-] main.vm (5, 15)
+ |  5 |     println(1 / 0);
+ |    |               ^
+ |   [-]
+[-]
+ | in main (1, 1)
+[-]
+`)
+
+	if len(diagnostics) != 1 || diagnostics[0].line != 5 || diagnostics[0].col != 15 || !diagnostics[0].runtime {
+		t.Fatalf("wrong diagnostics: %+v", diagnostics)
+	}
+
+	matching := []expectedError{{ runtime: true, line: 5, message: "divide by zero" }}
+
+	if failures := compareErrors(matching, diagnostics, "main.vm"); len(failures) != 0 {
+		t.Errorf("expected no failures, got %v", failures)
+	}
+
+	wrongLine := []expectedError{{ runtime: true, line: 4, message: "divide by zero" }}
+
+	if failures := compareErrors(wrongLine, diagnostics, "main.vm"); len(failures) != 2 {
+		t.Errorf("expected a missing and an unexpected error, got %v", failures)
+	}
+}
+
+// The compilation stops looking for errors after the limit, and says so.
+func TestErrorLimit(t *testing.T) {
+	source := `fn main() {
+    println(a);
+    println(b);
+    println(c);
+    println(d);
+}`
+
+	stdout := strings.Builder{}
+	stderr := strings.Builder{}
+	execute("limit.vm", source, run.Options{ MaxErrors: 2 }, &stdout, &stderr)
+
+	diagnostics := parseDiagnostics(stderr.String())
+
+	if len(diagnostics) != 2 || diagnostics[0].line != 2 || diagnostics[1].line != 3 {
+		t.Errorf("expected the errors of lines 2 and 3, got %+v", diagnostics)
+	}
+
+	if !strings.Contains(stderr.String(), "[-] Too many errors, only the first 2 are shown.") {
+		t.Errorf("expected the limit to be reported:\n%s", stderr.String())
+	}
+
+	stderr.Reset()
+	execute("limit.vm", source, run.Options{}, &stdout, &stderr)
+
+	if diagnostics := parseDiagnostics(stderr.String()); len(diagnostics) != 4 {
+		t.Errorf("expected 4 errors without a limit, got %+v", diagnostics)
+	}
+}
+
+// The warnings are errors, and the program doesn't run.
+func TestWarningsAsErrors(t *testing.T) {
+	source := `fn main() {
+    var unused = 1;
+    println("ran");
+}`
+
+	stdout := strings.Builder{}
+	stderr := strings.Builder{}
+	execute("werror.vm", source, run.Options{ WarningsAsErrors: true }, &stdout, &stderr)
+
+	diagnostics := parseDiagnostics(stderr.String())
+
+	if len(diagnostics) != 1 || diagnostics[0].warning || diagnostics[0].message != "'unused' is declared but never used." {
+		t.Errorf("expected the warning as an error, got %+v", diagnostics)
+	}
+
+	if stdout.String() != "" {
+		t.Errorf("expected the program not to run, got '%s'", stdout.String())
+	}
+}
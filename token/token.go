@@ -5,6 +5,9 @@ type TokenKind string
 const (
 	TokenNumber     = "number"
 	TokenString     = "string"
+
+	// A string segment that is followed by an interpolated expression.
+	TokenInterpolation = "interpolation"
 	TokenIdentifier = "identifier"
 
 	TokenPlus    = "+"
@@ -25,6 +28,9 @@ const (
 	TokenLeftBrace  = "{"
 	TokenRightBrace = "}"
 
+	TokenLeftBracket  = "["
+	TokenRightBracket = "]"
+
 	TokenEqual       = "="
 	TokenDoubleEqual = "=="
 	TokenBangEqual   = "!="
@@ -35,7 +41,8 @@ const (
 	TokenDot       = "."
 	TokenDoubleDot = ".."
 
-	TokenArrow = "->"
+	TokenArrow    = "->"
+	TokenQuestion = "?"
 
 	TokenGreater      = ">"
 	TokenGreaterEqual = ">="
@@ -56,6 +63,11 @@ const (
 	TokenSelfKw     = "self keyword"
 	TokenRecordKw   = "record keyword"
 	TokenReturnKw   = "return keyword"
+	TokenTryKw      = "try keyword"
+	TokenCatchKw    = "catch keyword"
+	TokenImportKw   = "import keyword"
+	TokenAsKw       = "as keyword"
+	TokenFromKw     = "from keyword"
 
 	TokenAndKw = "and keyword"
 	TokenOrKw  = "or keyword"
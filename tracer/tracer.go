@@ -0,0 +1,113 @@
+package tracer
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"vm-go/disassembler"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+// Prints every instruction the program runs as it runs it, in the format of the disassembler, with the call depth,
+// the function and the values of the stack before it runs. It's a hook of the VM, and the program runs much slower.
+type Tracer struct {
+	options Options
+	lines   int
+
+	// The disassembler of the chunk of the last instruction, to not create one for each instruction.
+	last         *value.Chunk
+	disassembler *disassembler.Disassembler
+
+	fileData *util.FileData
+	out      io.Writer
+}
+
+type Options struct {
+	// Only the instructions run by the functions with this name, "" for all of them.
+	Function string
+
+	// The most instructions printed, 0 for no limit. The program keeps running after the last one.
+	MaxLines int
+}
+
+// The most values of the stack shown, the ones on top.
+const stackValues = 8
+
+func New(fileData *util.FileData, options Options, out io.Writer) *Tracer {
+	fmt.Fprintln(out, "depth | function         | offset | position  | length | instruction      | index  | result")
+
+	return &Tracer{
+		options: options,
+		fileData: fileData,
+		out: out,
+	}
+}
+
+func (t *Tracer) Step(v *vm.VM) bool {
+	if t.options.MaxLines > 0 && t.lines >= t.options.MaxLines {
+		return true
+	}
+
+	name := "<top-level>"
+
+	if fn := v.Function(); fn != nil {
+		name = "<anonymous>"
+
+		if fn.Name != nil {
+			name = *fn.Name
+		}
+	}
+
+	if t.options.Function != "" && name != t.options.Function {
+		return true
+	}
+
+	if v.Chunk() != t.last {
+		t.last = v.Chunk()
+		t.disassembler = disassembler.NewDisassembler(*t.last, t.fileData)
+	}
+
+	// The row of the disassembler ends the line, and the closures have a row for each upvalue.
+	row := bytes.Buffer{}
+	t.disassembler.SetOutput(&row)
+	t.disassembler.PrintInstructionAt(v.Offset())
+
+	lines := strings.Split(strings.TrimSuffix(row.String(), "\n"), "\n")
+	fmt.Fprintf(t.out, "%s | %s |%s  stack: %s\n", util.PadLeft(strconv.Itoa(v.Depth()), 5, " "), util.PadRight(name, 16, " "), strings.TrimRight(lines[0], " "), stack(v.Stack()))
+
+	for _, line := range lines[1:] {
+		fmt.Fprintf(t.out, "%s | %s |%s\n", strings.Repeat(" ", 5), strings.Repeat(" ", 16), line)
+	}
+
+	t.lines++
+
+	if t.lines == t.options.MaxLines {
+		fmt.Fprintf(t.out, "... the trace stopped after %d instructions.\n", t.lines)
+	}
+
+	return true
+}
+
+func stack(values []value.Value) string {
+	parts := []string{}
+
+	if len(values) > stackValues {
+		parts = append(parts, fmt.Sprintf("... %d more", len(values) - stackValues))
+		values = values[len(values) - stackValues:]
+	}
+
+	for _, v := range values {
+		// Like the constants in the disassembler.
+		if s, ok := v.(value.ValueString); ok {
+			parts = append(parts, strconv.Quote(s.Value))
+		} else {
+			parts = append(parts, v.String())
+		}
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
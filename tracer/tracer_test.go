@@ -0,0 +1,81 @@
+package tracer_test
+
+import (
+	"strings"
+	"testing"
+	"vm-go/run"
+	"vm-go/tracer"
+	"vm-go/util"
+	"vm-go/vm"
+)
+
+const program = `fn greet(name) {
+    return "hi " + name;
+}
+
+fn main() {
+    greet("a");
+    greet("b");
+}`
+
+func TestTrace(t *testing.T) {
+	lines := trace(t, tracer.Options{})
+
+	if lines[0] != "depth | function         | offset | position  | length | instruction      | index  | result" {
+		t.Errorf("expected the header first, got '%s'", lines[0])
+	}
+
+	// The strings on the stack are quoted, and the call of 'greet' is one call deeper than 'main'.
+	found := false
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "    2 | greet ") && strings.Contains(line, "| ADD ") && strings.HasSuffix(line, `stack: ["hi ", "a"]`) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("expected the ADD of the first call of 'greet':\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+func TestTraceFilter(t *testing.T) {
+	lines := trace(t, tracer.Options{ Function: "greet", MaxLines: 5 })
+
+	// The header, the instructions and the line that says it stopped.
+	if len(lines) != 7 {
+		t.Fatalf("expected 7 lines, got %d:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+
+	for _, line := range lines[1:6] {
+		if !strings.HasPrefix(line, "    2 | greet ") {
+			t.Errorf("expected only the instructions of 'greet', got '%s'", line)
+		}
+	}
+
+	if lines[6] != "... the trace stopped after 5 instructions." {
+		t.Errorf("expected the trace to stop, got '%s'", lines[6])
+	}
+}
+
+func trace(t *testing.T, options tracer.Options) []string {
+	stderr := strings.Builder{}
+	streams := util.NewStreams(&strings.Builder{}, &stderr, strings.NewReader(""))
+	fileData := util.FileData{ Name: "greet.vm", Path: "greet.vm", Lines: strings.Split(program, "\n") }
+	registry := vm.DefaultRegistry(streams)
+
+	chunk, hadError := run.Compile(program, &fileData, registry, run.Options{}, streams)
+
+	if hadError {
+		t.Fatal("the program has errors")
+	}
+
+	v := vm.NewVM(chunk, &fileData, registry, streams)
+	v.AddHook(tracer.New(&fileData, options, &stderr))
+
+	if status := v.Run(); status != vm.STATUS_OK {
+		t.Fatalf("the program failed: %s", status)
+	}
+
+	return strings.Split(strings.TrimSuffix(stderr.String(), "\n"), "\n")
+}
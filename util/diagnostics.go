@@ -0,0 +1,59 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"vm-go/token"
+)
+
+// The errors of a compilation. They're counted together, as every stage, and every module imported, reports
+// them here, and at most 'max' are printed, 0 for no limit.
+type Diagnostics struct {
+	stderr io.Writer
+	max    int
+	errors int
+}
+
+func NewDiagnostics(stderr io.Writer, max int) *Diagnostics {
+	return &Diagnostics{ stderr: stderr, max: max }
+}
+
+// Prints an error, unless there were too many already. The first one over the limit says so.
+func (d *Diagnostics) Error(pos token.Position, length int, message string, fileData *FileData) {
+	if d.countError() {
+		Error(d.stderr, pos, length, message, fileData)
+	}
+}
+
+// Like 'Error', for the errors of a whole file, like a missing main function.
+func (d *Diagnostics) ErrorNoPosition(message string, fileData *FileData) {
+	if d.countError() {
+		fmt.Fprintf(d.stderr, "[-] Error: %s\n", message)
+		fmt.Fprintf(d.stderr, " |   [-] %s\n", fileData.Name)
+		fmt.Fprint(d.stderr, "[-]\n\n")
+	}
+}
+
+// Any stage had errors, even if they weren't printed.
+func (d *Diagnostics) HadErrors() bool {
+	return d.errors > 0
+}
+
+// The stages stop looking for more errors once one isn't printed.
+func (d *Diagnostics) TooManyErrors() bool {
+	return d.max > 0 && d.errors > d.max
+}
+
+func (d *Diagnostics) countError() bool {
+	d.errors++
+
+	if d.max == 0 || d.errors <= d.max {
+		return true
+	}
+
+	if d.errors == d.max + 1 {
+		fmt.Fprintf(d.stderr, "[-] Too many errors, only the first %d are shown.\n\n", d.max)
+	}
+
+	return false
+}
@@ -2,5 +2,9 @@ package util
 
 type FileData struct {
 	Name  string
+	Path  string // as given by the user or the import, used to resolve imports relative to this file
 	Lines []string
+
+	// The comments of each line, from their '//', filled by the lexer.
+	Comments map[int]string
 }
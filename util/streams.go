@@ -0,0 +1,29 @@
+package util
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// The streams of a run: the program's output and input, and the diagnostics.
+// Hosts and tests can replace them to capture the output.
+type Streams struct {
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// A single reader for the whole run, so the input buffered by one read isn't lost in the next.
+	Stdin *bufio.Reader
+}
+
+func NewStreams(stdout, stderr io.Writer, stdin io.Reader) *Streams {
+	return &Streams{
+		Stdout: stdout,
+		Stderr: stderr,
+		Stdin: bufio.NewReader(stdin),
+	}
+}
+
+func DefaultStreams() *Streams {
+	return NewStreams(os.Stdout, os.Stderr, os.Stdin)
+}
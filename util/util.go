@@ -3,6 +3,7 @@ package util
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"path/filepath"
 	"slices"
 	"strconv"
@@ -103,11 +104,20 @@ func IsRangeReachable(start, end, step float64, inclusive bool) bool {
            (step == 0 && start == end && !inclusive)
 }
 
-func Error(pos token.Position, length int, message string, fileData *FileData) {
-	fmt.Printf("[-] Error: %s\n", message)
-	fmt.Printf(" | %s [-] %s (%d, %d)\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line + 1))), fileData.Name, pos.Line + 1, pos.Col + 1)
-	fmt.Printf(" |  %d | %s\n", pos.Line+1, fileData.Lines[pos.Line])
-	fmt.Printf(" | %s  | %s%s\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line+1))), strings.Repeat(" ", pos.Col), strings.Repeat("^", length))
-	fmt.Printf(" | %s [-]\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line + 1))))
-	fmt.Printf("[-]\n\n")
+func Error(w io.Writer, pos token.Position, length int, message string, fileData *FileData) {
+	diagnostic(w, "Error", pos, length, message, fileData)
+}
+
+// Like 'Error', for the code that's valid, but likely a mistake.
+func Warning(w io.Writer, pos token.Position, length int, message string, fileData *FileData) {
+	diagnostic(w, "Warning", pos, length, message, fileData)
+}
+
+func diagnostic(w io.Writer, kind string, pos token.Position, length int, message string, fileData *FileData) {
+	fmt.Fprintf(w, "[-] %s: %s\n", kind, message)
+	fmt.Fprintf(w, " | %s [-] %s (%d, %d)\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line + 1))), fileData.Name, pos.Line + 1, pos.Col + 1)
+	fmt.Fprintf(w, " |  %d | %s\n", pos.Line+1, fileData.Lines[pos.Line])
+	fmt.Fprintf(w, " | %s  | %s%s\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line+1))), strings.Repeat(" ", pos.Col), strings.Repeat("^", length))
+	fmt.Fprintf(w, " | %s [-]\n", strings.Repeat(" ", len(strconv.Itoa(pos.Line + 1))))
+	fmt.Fprintf(w, "[-]\n\n")
 }
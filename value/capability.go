@@ -0,0 +1,126 @@
+package value
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The natives that touch the outside world need a capability, which the host grants with a sandbox.
+// Without a sandbox, every native can be called, like in the 'vm' command.
+// A program that refers to a native that isn't granted doesn't compile, and natives that reach it
+// some other way, like from the host, fail when called.
+
+type Capability string
+
+const (
+	CapabilityNone   Capability = ""
+	CapabilityIO     Capability = "io"     // the streams: 'print', 'println' and 'input'
+	CapabilityTime   Capability = "time"   // the clock: 'time'
+	CapabilityFS     Capability = "fs"     // the files in the allowed directories: 'readFile' and 'writeFile'
+	CapabilityEnv    Capability = "env"    // the environment variables: 'env'
+	CapabilityRandom Capability = "random" // the random numbers: 'random'
+)
+
+var Capabilities = []Capability{ CapabilityIO, CapabilityTime, CapabilityFS, CapabilityEnv, CapabilityRandom }
+
+func ParseCapability(name string) (Capability, bool) {
+	for _, c := range Capabilities {
+		if string(c) == name {
+			return c, true
+		}
+	}
+
+	return CapabilityNone, false
+}
+
+type Sandbox struct {
+	granted map[Capability]bool
+
+	// Absolute, with the symbolic links resolved.
+	dirs []string
+}
+
+func NewSandbox(capabilities ...Capability) *Sandbox {
+	s := &Sandbox{ granted: map[Capability]bool{} }
+	s.Grant(capabilities...)
+
+	return s
+}
+
+func (s *Sandbox) Grant(capabilities ...Capability) {
+	for _, c := range capabilities {
+		s.granted[c] = true
+	}
+}
+
+// Grants 'fs' for the files inside the directory, and its subdirectories.
+func (s *Sandbox) AllowDir(dir string) error {
+	resolved, err := resolvePath(dir)
+
+	if err != nil {
+		return err
+	}
+
+	s.Grant(CapabilityFS)
+	s.dirs = append(s.dirs, resolved)
+
+	return nil
+}
+
+// A nil sandbox allows everything.
+func (s *Sandbox) Allows(c Capability) bool {
+	return s == nil || c == CapabilityNone || s.granted[c]
+}
+
+// Returns the path the 'fs' natives can use, or an error if it's outside the allowed directories.
+func (s *Sandbox) CheckPath(path string) (string, error) {
+	if s == nil {
+		return path, nil
+	}
+
+	resolved, err := resolvePath(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, dir := range s.dirs {
+		if rel, err := filepath.Rel(dir, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".." + string(filepath.Separator)) {
+			return resolved, nil
+		}
+	}
+
+	return "", fmt.Errorf("'%s' is outside the directories the program can access", path)
+}
+
+// The symbolic links are resolved, so they can't point outside the allowed directories.
+// A file that doesn't exist yet is resolved through its directory.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(abs)
+
+	if errors.Is(err, os.ErrNotExist) {
+		dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+
+		if err != nil {
+			return "", err
+		}
+
+		return filepath.Join(dir, filepath.Base(abs)), nil
+	}
+
+	return resolved, err
+}
+
+// The error for a native that needs a capability that isn't granted.
+func MissingCapability(name string, c Capability) string {
+	return fmt.Sprintf("'%s' needs the '%s' capability, which isn't granted to the program.", name, c)
+}
@@ -1,17 +1,63 @@
 package value
 
-import "vm-go/token"
+import (
+	"vm-go/token"
+	"vm-go/util"
+)
 
 type Chunk struct {
 	Code      []byte
 	Constants []Value
 
 	Metadata []ChunkMetadata
+
+	// One for each property access, which has its index as an operand.
+	Caches []PropertyCache
+
+	// The names of the locals and the upvalues, for the debugger. They aren't part of the bytecode files.
+	Locals   []LocalName
+	Upvalues []string
+
+	// How many globals the program has, the registry's included. Only set in the top-level chunk,
+	// so the verifier can check the global operands of all the chunks.
+	Globals int
+}
+
+// A local is named from the instruction that declares it, until another local takes its slot.
+type LocalName struct {
+	Name  string
+	Slot  int
+	Start int
+}
+
+// The name of the local in the slot, at the offset, or "" if it's not known.
+func (c *Chunk) LocalName(slot, offset int) string {
+	name := ""
+
+	for _, local := range c.Locals {
+		if local.Start > offset {
+			break
+		}
+
+		if local.Slot == slot {
+			name = local.Name
+		}
+	}
+
+	return name
+}
+
+// The inline cache of a property access: the slot the property was found at, the last time the instruction ran,
+// and the shape of the record of that instance. The next instances of the same record skip the lookup.
+type PropertyCache struct {
+	Shape *RecordShape
+	Slot  int
 }
 
 type ChunkMetadata struct {
 	Position token.Position
 	Length int
+	File *util.FileData // the file the position belongs to, as imported modules share the chunk
 }
 
 func NewMetaLen1(pos token.Position) ChunkMetadata {
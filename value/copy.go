@@ -6,56 +6,17 @@ import (
 	"vm-go/util"
 )
 
-// Deep-copies a Value.
+// Copies a Value where the language gives it copy semantics: the lists, maps, instances and ranges, which
+// can be changed in place, are deep-copied. The rest can't be changed, so they're shared instead: the numbers,
+// strings and bools, the functions and their chunks, the records, and the closures, whose upvalues
+// are shared anyway.
 func CopyValue(value Value) Value {
 	switch v := value.(type) {
-		case ValueNumber:
-			return ValueNumber{Value: v.Value}
-		case ValueString:
-			return ValueString{Value: v.Value}
-		case ValueBool:
-			return ValueBool{Value: v.Value}
-		
-		case ValueNil:
-			return ValueNil{}
-		case ValueVoid:
-			return ValueVoid{}
-			
-		case ValueFunction: {
-			return ValueFunction{
-				Arity: v.Arity,
-				Chunk: Chunk{
-					Code: util.CopyList(v.Chunk.Code, func(b byte) byte {
-						return b
-					}),
-					Constants: util.CopyList(v.Chunk.Constants, CopyValue),
-					Metadata: util.CopyList(v.Chunk.Metadata, func(m ChunkMetadata) ChunkMetadata {
-						return m
-					}),
-				},
-				Name: v.Name,
-			}
-		}
+		case ValueNumber, ValueString, ValueBool, ValueNil, ValueVoid:
+			return value
 
-		case ValueClosure: {
-			// dereference the function first
-			fn, _ := CopyValue(*v.Fn).(ValueFunction)
-
-			return ValueClosure{
-				Fn: &fn,
-				Upvalues: util.CopyList(v.Upvalues, func(up *Upvalue) *Upvalue {
-					// copy the pointer
-					return up
-				}),
-			}
-		}
-
-		case ValueNativeFn: {
-			return ValueNativeFn{
-				Arity: v.Arity,
-				Fn: v.Fn,
-			}
-		}
+		case ValueFunction, ValueClosure, ValueNativeFn, ValueRecord, ValueBoundMethod, ValueError:
+			return value
 
         case ValueRange: {
             start := *v.Start
@@ -71,13 +32,17 @@ func CopyValue(value Value) Value {
             }
         }
 
-		case ValueRecord: {
-			return ValueRecord{
-				FieldNames: util.CopyList(v.FieldNames, func(s string) string {
-					return s
-				}),
-				Name: v.Name,
+		case ValueList:
+			return NewValueList(util.CopyList(*v.Elements, CopyValue))
+
+		case ValueMap: {
+			res := NewValueMap()
+
+			for _, key := range v.Entries.Keys {
+				res.Set(key, v.Entries.Values[key])
 			}
+
+			return res
 		}
 
 		case ValueInstance: {
@@ -86,13 +51,6 @@ func CopyValue(value Value) Value {
 				Record: v.Record, // don't copy
 			}
 		}
-		
-		case ValueBoundMethod: {
-			return ValueBoundMethod{
-				Receiver: v.Receiver, // don't copy
-				Method: CopyValue(v.Method).(ValueClosure),
-			}
-		}
 
 		default:
 			panic(fmt.Sprintf("Unknown value: '%v': %s", v, reflect.TypeOf(v)))
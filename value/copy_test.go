@@ -0,0 +1,23 @@
+package value
+
+import "testing"
+
+// A copied record, like the one passed to a function, must keep its methods, or calling one on its instances fails.
+func TestCopyRecordKeepsMethods(t *testing.T) {
+	method := ValueClosure{Fn: &ValueFunction{}}
+	record := ValueRecord{Name: "Point", FieldNames: []string{"x", "y"}, Methods: []ValueClosure{method}}
+
+	copied, ok := CopyValue(record).(ValueRecord)
+
+	if !ok {
+		t.Fatalf("expected a record, got %T", CopyValue(record))
+	}
+
+	if len(copied.Methods) != 1 || copied.Methods[0].Fn != method.Fn {
+		t.Errorf("expected the copy to keep the method, got %v", copied.Methods)
+	}
+
+	if copied.Name != "Point" || len(copied.FieldNames) != 2 {
+		t.Errorf("expected the copy to keep the name and the fields, got %v", copied)
+	}
+}
@@ -4,7 +4,7 @@ type Upvalue struct {
 	LocalsIndex int
 	Index       int // filled when open.
 
-	ClosedValue Value // filled when closed.
+	ClosedValue Slot // filled when closed.
 	IsClosed    bool
 }
 
@@ -1,16 +1,29 @@
 package value
 
 import (
+	"slices"
+
 	"vm-go/util"
 )
 
-// TODO: add ToList() when lists are in the language
 type Iterator interface {
     HasNext() bool
     Advance()
     GetNext() Value
 }
 
+// Consumes the remaining elements of the iterator into a new list.
+func ToList(it Iterator) ValueList {
+    elements := []Value{}
+
+    for it.HasNext() {
+        elements = append(elements, it.GetNext())
+        it.Advance()
+    }
+
+    return NewValueList(elements)
+}
+
 // ---
 
 type RangeIterator struct {
@@ -100,3 +113,76 @@ func (x StrBytesIterator) String() string {
 func (x StrBytesIterator) Type() string {
     return "iterator"
 }
+
+// ---
+
+type ListIterator struct {
+	List ValueList
+	Pos  int
+}
+
+func NewListIterator(list ValueList) ListIterator {
+	return ListIterator{
+		List: NewValueList(slices.Clone(*list.Elements)), // to avoid changes to the list while iterating, the elements are shared
+		Pos:  0,
+	}
+}
+
+// impl Iterator for *ListIterator
+func (r *ListIterator) HasNext() bool {
+	return r.Pos < len(*r.List.Elements)
+}
+
+func (r *ListIterator) Advance() {
+	r.Pos++
+}
+
+func (r *ListIterator) GetNext() Value {
+	return (*r.List.Elements)[r.Pos]
+}
+
+// impl Value for ListIterator
+func (x ListIterator) String() string {
+    return "<list iterator>"
+}
+
+func (x ListIterator) Type() string {
+    return "iterator"
+}
+
+// ---
+
+// Iterates over the keys of a map, in insertion order.
+type MapIterator struct {
+	Keys []Value
+	Pos  int
+}
+
+func NewMapIterator(m ValueMap) MapIterator {
+	return MapIterator{
+		Keys: util.CopyList(m.Entries.Keys, CopyValue), // to avoid changes to the map while iterating
+		Pos:  0,
+	}
+}
+
+// impl Iterator for *MapIterator
+func (r *MapIterator) HasNext() bool {
+	return r.Pos < len(r.Keys)
+}
+
+func (r *MapIterator) Advance() {
+	r.Pos++
+}
+
+func (r *MapIterator) GetNext() Value {
+	return r.Keys[r.Pos]
+}
+
+// impl Value for MapIterator
+func (x MapIterator) String() string {
+    return "<map iterator>"
+}
+
+func (x MapIterator) Type() string {
+    return "iterator"
+}
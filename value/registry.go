@@ -0,0 +1,99 @@
+package value
+
+// The globals defined by the host, like the native functions, which come before the globals of the program.
+// The compiler and the VM are built from the same registry, so both agree on the index of each global.
+type Registry struct {
+	names  []string
+	values []Value
+
+	// What the natives can touch, nil for everything.
+	sandbox *Sandbox
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: []string{},
+		values: []Value{},
+	}
+}
+
+// Returns false if the name is already defined.
+func (r *Registry) Define(name string, value Value) bool {
+	if r.Index(name) != -1 {
+		return false
+	}
+
+	r.names = append(r.names, name)
+	r.values = append(r.values, value)
+
+	return true
+}
+
+func (r *Registry) DefineNative(name string, arity int, fn NativeFn) bool {
+	return r.DefineGuardedNative(name, arity, CapabilityNone, fn)
+}
+
+// Defines a native that can only be called if the sandbox grants the capability.
+func (r *Registry) DefineGuardedNative(name string, arity int, capability Capability, fn NativeFn) bool {
+	return r.Define(name, ValueNativeFn{
+		Arity: arity,
+		Fn: fn,
+		Name: name,
+		Capability: capability,
+	})
+}
+
+// Replaces the value of a defined global, returns false if it isn't defined.
+func (r *Registry) Set(name string, value Value) bool {
+	index := r.Index(name)
+
+	if index == -1 {
+		return false
+	}
+
+	r.values[index] = value
+	return true
+}
+
+func (r *Registry) Index(name string) int {
+	for i, n := range r.names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (r *Registry) SetSandbox(sandbox *Sandbox) {
+	r.sandbox = sandbox
+}
+
+func (r *Registry) Sandbox() *Sandbox {
+	return r.sandbox
+}
+
+// Returns the capability a global needs, if it's a native that the sandbox doesn't grant.
+func (r *Registry) MissingCapability(name string) (Capability, bool) {
+	index := r.Index(name)
+
+	if index == -1 {
+		return CapabilityNone, false
+	}
+
+	native, ok := r.values[index].(ValueNativeFn)
+
+	if !ok || r.sandbox.Allows(native.Capability) {
+		return CapabilityNone, false
+	}
+
+	return native.Capability, true
+}
+
+func (r *Registry) Names() []string {
+	return r.names
+}
+
+func (r *Registry) Values() []Value {
+	return r.values
+}
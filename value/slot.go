@@ -0,0 +1,42 @@
+package value
+
+// How the VM keeps the values in its stack, locals, globals and upvalues. The numbers stay unboxed in 'Num',
+// so the arithmetic doesn't allocate, and the other values go in 'Ref', which is nil for the numbers.
+// The rest of the code, like the natives, sees a Value, and a number is only boxed when it gets there.
+type Slot struct {
+	Num float64
+	Ref Value
+}
+
+func NumberSlot(n float64) Slot {
+	return Slot{ Num: n }
+}
+
+func ToSlot(v Value) Slot {
+	if n, ok := v.(ValueNumber); ok {
+		return Slot{ Num: n.Value }
+	}
+
+	return Slot{ Ref: v }
+}
+
+func (s Slot) IsNumber() bool {
+	return s.Ref == nil
+}
+
+func (s Slot) Value() Value {
+	if s.Ref == nil {
+		return ValueNumber{ Value: s.Num }
+	}
+
+	return s.Ref
+}
+
+// Like CopyValue, the numbers are copied as they are.
+func (s Slot) Copy() Slot {
+	if s.Ref == nil {
+		return s
+	}
+
+	return Slot{ Ref: CopyValue(s.Ref) }
+}
@@ -3,6 +3,10 @@ package value
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"slices"
+	"vm-go/token"
+	"vm-go/util"
 )
 
 type RangeSetStatus int
@@ -12,7 +16,8 @@ const (
     RANGE_TYPE_ERROR
 )
 
-type NativeFn = func(args []Value) Value
+// Natives return an error to raise a runtime error, which can be caught by the script.
+type NativeFn = func(args []Value) (Value, error)
 
 type Value interface {
 	String() string
@@ -33,8 +38,8 @@ func (s *ValueString) GetProperty(name string) (Value, bool) {
     switch name {
         case "len": return ValueNativeFn{
 			Arity: 0,
-			Fn: func(_ []Value) Value {
-				return ValueNumber{float64(len(s.Value))}
+			Fn: func(_ []Value) (Value, error) {
+				return ValueNumber{float64(len(s.Value))}, nil
 			},
 		}, true
 
@@ -63,6 +68,10 @@ type ValueClosure struct {
 type ValueNativeFn struct {
 	Arity int
 	Fn NativeFn
+
+	// Set for the natives of the registry.
+	Name       string
+	Capability Capability
 }
 
 // The fields are pointers because they are not copied directly,
@@ -82,6 +91,14 @@ func (r *ValueRange) GetProperty(name string) (Value, bool) {
         case "step": return ValueNumber{Value: *r.Step}, true
         case "inclusive": return ValueBool{Value: *r.Inclusive}, true
 
+        case "toList": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				it := NewRangeIterator(*r)
+				return ToList(&it), nil
+			},
+		}, true
+
         default: return ValueNil{}, false
     }
 }
@@ -139,10 +156,311 @@ func (r *ValueRange) SetProperty(name string, value Value) RangeSetStatus {
     return RANGE_OK
 }
 
+// The elements are a pointer so methods like 'push' can change the list
+// in place, the same way ValueRange shares its fields.
+type ValueList struct {
+	Elements *[]Value
+}
+
+func NewValueList(elements []Value) ValueList {
+	return ValueList{
+		Elements: &elements,
+	}
+}
+
+func (l *ValueList) GetProperty(name string) (Value, bool) {
+	switch name {
+		case "len": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				return ValueNumber{float64(len(*l.Elements))}, nil
+			},
+		}, true
+
+		case "push": return ValueNativeFn{
+			Arity: 1,
+			Fn: func(args []Value) (Value, error) {
+				*l.Elements = append(*l.Elements, CopyValue(args[0]))
+				return ValueVoid{}, nil
+			},
+		}, true
+
+		// Returns 'nil' if the list is empty.
+		case "pop": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				if len(*l.Elements) == 0 {
+					return ValueNil{}, nil
+				}
+
+				return util.PopList(l.Elements), nil
+			},
+		}, true
+
+		// Returns 'false' if the index is invalid.
+		case "insert": return ValueNativeFn{
+			Arity: 2,
+			Fn: func(args []Value) (Value, error) {
+				index, ok := l.toIndex(args[0], true)
+
+				if !ok {
+					return ValueBool{false}, nil
+				}
+
+				*l.Elements = slices.Insert(*l.Elements, index, CopyValue(args[1]))
+				return ValueBool{true}, nil
+			},
+		}, true
+
+		// Returns the removed element, or 'nil' if the index is invalid.
+		case "remove": return ValueNativeFn{
+			Arity: 1,
+			Fn: func(args []Value) (Value, error) {
+				index, ok := l.toIndex(args[0], false)
+
+				if !ok {
+					return ValueNil{}, nil
+				}
+
+				removed := (*l.Elements)[index]
+				*l.Elements = slices.Delete(*l.Elements, index, index + 1)
+
+				return removed, nil
+			},
+		}, true
+
+		default: return ValueNil{}, false
+	}
+}
+
+// Converts 'index' into a valid position inside the list.
+// If 'allowEnd' is true, the position right after the last element is also valid.
+func (l *ValueList) toIndex(index Value, allowEnd bool) (int, bool) {
+	num, ok := index.(ValueNumber)
+
+	if !ok || num.Value != math.Trunc(num.Value) {
+		return 0, false
+	}
+
+	length := len(*l.Elements)
+
+	if allowEnd {
+		length += 1
+	}
+
+	if num.Value < 0 || num.Value >= float64(length) {
+		return 0, false
+	}
+
+	return int(num.Value), true
+}
+
+// Returns the element at 'index', or false if it isn't a valid index.
+func (l *ValueList) GetIndex(index Value) (Value, bool) {
+	i, ok := l.toIndex(index, false)
+
+	if !ok {
+		return ValueNil{}, false
+	}
+
+	return (*l.Elements)[i], true
+}
+
+// Sets the element at 'index', returns false if it isn't a valid index.
+func (l *ValueList) SetIndex(index Value, value Value) bool {
+	i, ok := l.toIndex(index, false)
+
+	if !ok {
+		return false
+	}
+
+	(*l.Elements)[i] = CopyValue(value)
+	return true
+}
+
+// The keys are kept in insertion order, so iterating and printing maps is deterministic.
+// Only strings, numbers and bools can be used as keys.
+type MapEntries struct {
+	Keys   []Value
+	Values map[Value]Value
+}
+
+// The entries are a pointer so the map can be changed in place, just like ValueList.
+type ValueMap struct {
+	Entries *MapEntries
+}
+
+func NewValueMap() ValueMap {
+	return ValueMap{
+		Entries: &MapEntries{
+			Keys:   []Value{},
+			Values: map[Value]Value{},
+		},
+	}
+}
+
+func IsHashable(key Value) bool {
+	switch key.(type) {
+		case ValueString, ValueNumber, ValueBool:
+			return true
+
+		default:
+			return false
+	}
+}
+
+func (m *ValueMap) GetProperty(name string) (Value, bool) {
+	switch name {
+		case "len": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				return ValueNumber{float64(len(m.Entries.Keys))}, nil
+			},
+		}, true
+
+		case "has": return ValueNativeFn{
+			Arity: 1,
+			Fn: func(args []Value) (Value, error) {
+				_, ok := m.Get(args[0])
+				return ValueBool{ok}, nil
+			},
+		}, true
+
+		case "keys": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				return NewValueList(util.CopyList(m.Entries.Keys, CopyValue)), nil
+			},
+		}, true
+
+		case "values": return ValueNativeFn{
+			Arity: 0,
+			Fn: func(_ []Value) (Value, error) {
+				values := make([]Value, 0, len(m.Entries.Keys))
+
+				for _, key := range m.Entries.Keys {
+					values = append(values, CopyValue(m.Entries.Values[key]))
+				}
+
+				return NewValueList(values), nil
+			},
+		}, true
+
+		// Returns the removed value, or 'nil' if the key doesn't exist.
+		case "remove": return ValueNativeFn{
+			Arity: 1,
+			Fn: func(args []Value) (Value, error) {
+				return m.Remove(args[0]), nil
+			},
+		}, true
+
+		default: return ValueNil{}, false
+	}
+}
+
+// Returns the value associated with 'key', or false if it doesn't exist.
+func (m *ValueMap) Get(key Value) (Value, bool) {
+	if !IsHashable(key) {
+		return ValueNil{}, false
+	}
+
+	val, ok := m.Entries.Values[key]
+
+	if !ok {
+		return ValueNil{}, false
+	}
+
+	return val, true
+}
+
+// Sets the value associated with 'key', returns false if the key can't be used in a map.
+func (m *ValueMap) Set(key Value, value Value) bool {
+	if !IsHashable(key) {
+		return false
+	}
+
+	if _, ok := m.Entries.Values[key]; !ok {
+		m.Entries.Keys = append(m.Entries.Keys, key)
+	}
+
+	m.Entries.Values[key] = CopyValue(value)
+	return true
+}
+
+func (m *ValueMap) Remove(key Value) Value {
+	val, ok := m.Get(key)
+
+	if !ok {
+		return ValueNil{}
+	}
+
+	delete(m.Entries.Values, key)
+	m.Entries.Keys = slices.DeleteFunc(m.Entries.Keys, func(k Value) bool {
+		return k == key
+	})
+
+	return val
+}
+
+// A runtime error, as seen by a 'catch' block.
+type ValueError struct {
+	Message  string
+	Kind     string
+	Position token.Position
+}
+
+func (e *ValueError) GetProperty(name string) (Value, bool) {
+	switch name {
+		case "message": return ValueString{Value: e.Message}, true
+		case "kind": return ValueString{Value: e.Kind}, true
+
+		// The positions are 1-based, like in the error messages.
+		case "line": return ValueNumber{Value: float64(e.Position.Line + 1)}, true
+		case "col": return ValueNumber{Value: float64(e.Position.Col + 1)}, true
+
+		default: return ValueNil{}, false
+	}
+}
+
 type ValueRecord struct {
 	Name string
 	FieldNames []string
 	Methods []ValueClosure
+
+	Shape *RecordShape
+}
+
+// What the copies of a record, and its instances, share. It's replaced when the methods are added,
+// so it never changes after it's created.
+type RecordShape struct {
+	// The slot of each property: the fields first, in order, then the methods.
+	Slots map[string]int
+}
+
+func NewRecord(name string, fieldNames []string) ValueRecord {
+	record := ValueRecord{
+		Name: name,
+		FieldNames: fieldNames,
+		Methods: []ValueClosure{},
+	}
+
+	record.SetMethods(record.Methods)
+	return record
+}
+
+func (r *ValueRecord) SetMethods(methods []ValueClosure) {
+	r.Methods = methods
+	r.Shape = &RecordShape{ Slots: make(map[string]int, len(r.FieldNames) + len(methods)) }
+
+	for i, field := range r.FieldNames {
+		r.Shape.Slots[field] = i
+	}
+
+	for i, method := range methods {
+		if _, ok := r.Shape.Slots[*method.Fn.Name]; !ok {
+			r.Shape.Slots[*method.Fn.Name] = len(r.FieldNames) + i
+		}
+	}
 }
 
 type ValueInstance struct {
@@ -156,33 +474,40 @@ type ValueBoundMethod struct {
 }
 
 func (in *ValueInstance) GetProperty(name string) (Value, bool) {
-	for i, value := range in.Fields {
-		if in.Record.FieldNames[i] == name {
-			return value, true
-		}
-	}
+	slot, ok := in.Record.Shape.Slots[name]
 
-	for _, method := range in.Record.Methods {
-		if *method.Fn.Name == name {
-			return ValueBoundMethod{
-				Receiver: *in,
-				Method: method,
-			}, true
-		}
+	if !ok {
+		return ValueNil{}, false
 	}
 
-	return ValueNil{}, false
+	return in.GetSlot(slot), true
 }
 
 func (in *ValueInstance) SetProperty(name string, value Value) bool {
-	for i := range in.Fields {
-		if in.Record.FieldNames[i] == name {
-			in.Fields[i] = CopyValue(value)
-			return true
-		}
+	slot, ok := in.Record.Shape.Slots[name]
+	return ok && in.SetSlot(slot, value)
+}
+
+// The slots come from the shape of the record, see 'RecordShape'.
+func (in *ValueInstance) GetSlot(slot int) Value {
+	if slot < len(in.Fields) {
+		return in.Fields[slot]
+	}
+
+	return ValueBoundMethod{
+		Receiver: *in,
+		Method: in.Record.Methods[slot - len(in.Fields)],
 	}
+}
 
-	return false
+// Only the fields can be changed, not the methods.
+func (in *ValueInstance) SetSlot(slot int, value Value) bool {
+	if slot >= len(in.Fields) {
+		return false
+	}
+
+	in.Fields[slot] = CopyValue(value)
+	return true
 }
 
 // ---
@@ -218,6 +543,42 @@ func (x ValueRange) String() string {
     }
 }
 
+func (x ValueList) String() string {
+	res := bytes.Buffer{}
+	res.WriteString("[")
+
+	for i, element := range *x.Elements {
+		res.WriteString(element.String())
+
+		// Add a comma and space if it isn't the last element.
+		if i < len(*x.Elements) - 1 {
+			res.WriteString(", ")
+		}
+	}
+
+	res.WriteString("]")
+	return res.String()
+}
+
+func (x ValueMap) String() string {
+	res := bytes.Buffer{}
+	res.WriteString("{")
+
+	for i, key := range x.Entries.Keys {
+		res.WriteString(fmt.Sprintf("%s: %s", key.String(), x.Entries.Values[key].String()))
+
+		// Add a comma and space if it isn't the last entry.
+		if i < len(x.Entries.Keys) - 1 {
+			res.WriteString(", ")
+		}
+	}
+
+	res.WriteString("}")
+	return res.String()
+}
+
+func (x ValueError) String() string { return fmt.Sprintf("%s: %s", x.Kind, x.Message) }
+
 func (x ValueRecord) String() string { return fmt.Sprintf("<record %s>", x.Name) }
 
 func (x ValueInstance) String() string {
@@ -259,6 +620,9 @@ func (x ValueNativeFn) Type() string { return "native fn" }
 func (x ValueClosure) Type() string { return "fn" }
 
 func (x ValueRange) Type() string { return "range" }
+func (x ValueList) Type() string { return "list" }
+func (x ValueMap) Type() string { return "map" }
+func (x ValueError) Type() string { return "error" }
 func (x ValueRecord) Type() string { return "record" }
 func (x ValueInstance) Type() string { return x.Record.Name }
 
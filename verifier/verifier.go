@@ -0,0 +1,544 @@
+package verifier
+
+import (
+	"fmt"
+	"vm-go/compiler"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The VM trusts the chunks it runs, so the ones that don't come from the compiler, like the bytecode files,
+// are verified first. A valid chunk can still fail at runtime, but it can't make the VM read outside
+// the code, the constants, the metadata, the globals or the locals, or use a constant of the wrong kind.
+
+type Error struct {
+	Function string
+	Offset   int
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid bytecode in %s, at offset %d: %s", e.Function, e.Offset, e.Message)
+}
+
+// The operands that follow each opcode.
+type operands int
+
+const (
+	operandsNone operands = iota
+	operandsInt          // a count, an arity or a slot
+	operandsConstant     // an index into the constants
+	operandsProperty     // a name, which is a string constant, and the index of the inline cache
+	operandsJump         // an offset forward, from the end of the instruction
+	operandsLoop         // an offset backward, from the end of the instruction
+	operandsClosure      // a function constant, the upvalue count, and 'isLocal' and the index of each upvalue
+	operandsCallProperty // a name, the arity and the index of the inline cache
+)
+
+var layouts = map[byte]operands{
+	compiler.OP_PUSH_CONST: operandsConstant,
+	compiler.OP_PUSH_CLOSURE: operandsClosure,
+	compiler.OP_APPEND_METHODS: operandsInt,
+
+	compiler.OP_ADD: operandsNone,
+	compiler.OP_CONCAT: operandsInt,
+	compiler.OP_SUB: operandsNone,
+	compiler.OP_MUL: operandsNone,
+	compiler.OP_DIV: operandsNone,
+	compiler.OP_MOD: operandsNone,
+
+	compiler.OP_DEF_LOCAL: operandsNone,
+	compiler.OP_GET_LOCAL: operandsInt,
+	compiler.OP_SET_LOCAL: operandsInt,
+
+	compiler.OP_GET_UPVALUE: operandsInt,
+	compiler.OP_SET_UPVALUE: operandsInt,
+
+	compiler.OP_DEF_GLOBAL: operandsNone,
+	compiler.OP_GET_GLOBAL: operandsInt,
+	compiler.OP_SET_GLOBAL: operandsInt,
+
+	compiler.OP_GET_PROPERTY: operandsProperty,
+	compiler.OP_SET_PROPERTY: operandsProperty,
+
+	compiler.OP_GET_INDEX: operandsNone,
+	compiler.OP_SET_INDEX: operandsNone,
+
+	compiler.OP_POP: operandsNone,
+	compiler.OP_POP_LOCAL: operandsNone,
+	compiler.OP_POPN_LOCAL: operandsInt,
+
+	compiler.OP_CLOSE_UPVALUE: operandsNone,
+
+	compiler.OP_JUMP: operandsJump,
+	compiler.OP_JUMP_TRUE: operandsJump,
+	compiler.OP_JUMP_FALSE: operandsJump,
+	compiler.OP_JUMP_HAS_NO_NEXT: operandsJump,
+	compiler.OP_LOOP: operandsLoop,
+
+	compiler.OP_EQUAL: operandsNone,
+	compiler.OP_NOT_EQUAL: operandsNone,
+	compiler.OP_GREATER: operandsNone,
+	compiler.OP_GREATER_EQUAL: operandsNone,
+	compiler.OP_LESS: operandsNone,
+	compiler.OP_LESS_EQUAL: operandsNone,
+	compiler.OP_AND: operandsNone,
+	compiler.OP_OR: operandsNone,
+	compiler.OP_NOT: operandsNone,
+	compiler.OP_NEGATE: operandsNone,
+
+	compiler.OP_CALL: operandsInt,
+	compiler.OP_CALL_PROPERTY: operandsCallProperty,
+	compiler.OP_RETURN: operandsNone,
+
+	compiler.OP_PUSH_HANDLER: operandsJump,
+	compiler.OP_POP_HANDLER: operandsNone,
+
+	compiler.OP_PUSH_TRUE: operandsNone,
+	compiler.OP_PUSH_FALSE: operandsNone,
+	compiler.OP_PUSH_NIL: operandsNone,
+	compiler.OP_PUSH_VOID: operandsNone,
+
+	compiler.OP_MAKE_RANGE: operandsNone,
+	compiler.OP_MAKE_INCL_RANGE: operandsNone,
+	compiler.OP_MAKE_ITERATOR: operandsNone,
+	compiler.OP_MAKE_LIST: operandsInt,
+	compiler.OP_MAKE_MAP: operandsInt,
+
+	compiler.OP_GET_NEXT: operandsNone,
+	compiler.OP_ADVANCE: operandsNone,
+
+	compiler.OP_ASSERT_BOOL: operandsNone,
+}
+
+// The length of the instruction at the offset, with its operands, from the same table the verifier uses.
+// It's false for an unknown opcode, or for operands cut off by the end of the code, which 'Verify' rejects.
+func InstructionLength(code []byte, offset int) (int, bool) {
+	layout, ok := layouts[code[offset]]
+
+	if !ok {
+		return 0, false
+	}
+
+	length := 1
+
+	switch layout {
+		case operandsInt, operandsConstant, operandsJump, operandsLoop:
+			length = 5
+
+		case operandsProperty:
+			length = 9
+
+		case operandsCallProperty:
+			length = 13
+
+		// Each upvalue is 'isLocal' and an index.
+		case operandsClosure: {
+			if offset + 9 > len(code) {
+				return 0, false
+			}
+
+			count, _ := util.BytesToInt(code[offset + 5:offset + 9])
+			length = 9 + count * 5
+		}
+	}
+
+	if offset + length > len(code) {
+		return 0, false
+	}
+
+	return length, true
+}
+
+// Verifies the top-level chunk and the chunks of its functions, returning the first problem found.
+// The global operands are checked against the globals of the top-level chunk.
+func Verify(chunk value.Chunk) error {
+	v := verifier{ name: "top-level", globals: chunk.Globals }
+	return v.verify(chunk, 0, -1)
+}
+
+type verifier struct {
+	name    string
+	globals int
+}
+
+type jump struct {
+	offset int // of the instruction
+	target int
+}
+
+// What the locals pass needs to know about an instruction.
+type instruction struct {
+	opcode   byte
+	operand  int   // of the instructions with an int operand
+	target   int   // of the jumps, -1 otherwise
+	captures []int // the slots of the locals captured by a closure
+	next     int
+}
+
+// 'upvalues' is the amount of upvalues of the closures of the function, or -1 if no closure is created from it.
+// 'locals' is the amount of locals the function starts with, its parameters and its receiver, or -1 at top-level,
+// which runs without a frame, so it can't have locals.
+func (v *verifier) verify(chunk value.Chunk, upvalues, locals int) error {
+	if len(chunk.Metadata) != len(chunk.Code) {
+		return v.error(0, fmt.Sprintf("the chunk has %d bytes of code, but %d positions", len(chunk.Code), len(chunk.Metadata)))
+	}
+
+	// The offsets where instructions start, and the jumps to check once all of them are known.
+	boundaries := map[int]bool{}
+	jumps := []jump{}
+	instructions := map[int]instruction{}
+	functionUpvalues := map[int]int{} // constant index -> upvalue count
+
+	// The methods get the receiver as their first local. They're the closures created right before
+	// OP_APPEND_METHODS, which must never be called as plain functions, without it.
+	closures := []int{}
+	created := map[int]int{} // constant index -> closures created from it
+	methods := map[int]int{} // constant index -> closures added as methods
+
+	for ip := 0; ip < len(chunk.Code); {
+		start := ip
+		boundaries[start] = true
+
+		opcode := chunk.Code[ip]
+		layout, ok := layouts[opcode]
+		ip++
+
+		if !ok {
+			return v.error(start, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		in := instruction{ opcode: opcode, target: -1 }
+
+		if opcode != compiler.OP_PUSH_CLOSURE && opcode != compiler.OP_APPEND_METHODS {
+			closures = closures[:0]
+		}
+
+		// Reads an operand, which must fit in the code. They're unsigned, so they can't be negative.
+		readInt := func() (int, error) {
+			if ip + 4 > len(chunk.Code) {
+				return 0, v.error(start, "the operands are cut off by the end of the chunk")
+			}
+
+			n, _ := util.BytesToInt(chunk.Code[ip:ip + 4])
+			ip += 4
+
+			return n, nil
+		}
+
+		readConstant := func() (value.Value, int, error) {
+			index, err := readInt()
+
+			if err != nil {
+				return nil, 0, err
+			}
+
+			if index >= len(chunk.Constants) {
+				return nil, 0, v.error(start, fmt.Sprintf("constant %d is out of range, there are %d constants", index, len(chunk.Constants)))
+			}
+
+			return chunk.Constants[index], index, nil
+		}
+
+		switch layout {
+			case operandsInt: {
+				n, err := readInt()
+
+				if err != nil {
+					return err
+				}
+
+				in.operand = n
+
+				// The slot must be one of the upvalues of the closures, and there are none at top-level.
+				if (opcode == compiler.OP_GET_UPVALUE || opcode == compiler.OP_SET_UPVALUE) && upvalues >= 0 && n >= upvalues {
+					return v.error(start, fmt.Sprintf("upvalue %d is out of range, the function has %d upvalues", n, upvalues))
+				}
+
+				if (opcode == compiler.OP_GET_GLOBAL || opcode == compiler.OP_SET_GLOBAL) && n >= v.globals {
+					return v.error(start, fmt.Sprintf("global %d is out of range, there are %d globals", n, v.globals))
+				}
+
+				if opcode == compiler.OP_APPEND_METHODS {
+					if n > len(closures) {
+						return v.error(start, fmt.Sprintf("%d methods are added, but only %d closures were created right before", n, len(closures)))
+					}
+
+					for _, index := range closures[len(closures) - n:] {
+						methods[index]++
+					}
+
+					closures = closures[:0]
+				}
+			}
+
+			case operandsConstant: {
+				if _, _, err := readConstant(); err != nil {
+					return err
+				}
+			}
+
+			case operandsProperty, operandsCallProperty: {
+				constant, index, err := readConstant()
+
+				if err != nil {
+					return err
+				}
+
+				if _, ok := constant.(value.ValueString); !ok {
+					return v.error(start, fmt.Sprintf("constant %d must be a string, but it's a '%s'", index, constant.Type()))
+				}
+
+				if layout == operandsCallProperty {
+					if _, err := readInt(); err != nil {
+						return err
+					}
+				}
+
+				cache, err := readInt()
+
+				if err != nil {
+					return err
+				}
+
+				if cache >= len(chunk.Caches) {
+					return v.error(start, fmt.Sprintf("cache %d is out of range, there are %d caches", cache, len(chunk.Caches)))
+				}
+			}
+
+			case operandsJump, operandsLoop: {
+				offset, err := readInt()
+
+				if err != nil {
+					return err
+				}
+
+				if layout == operandsJump {
+					in.target = ip + offset
+				} else {
+					in.target = ip - offset
+				}
+
+				jumps = append(jumps, jump{ offset: start, target: in.target })
+			}
+
+			case operandsClosure: {
+				constant, index, err := readConstant()
+
+				if err != nil {
+					return err
+				}
+
+				if _, ok := constant.(value.ValueFunction); !ok {
+					return v.error(start, fmt.Sprintf("constant %d must be a function, but it's a '%s'", index, constant.Type()))
+				}
+
+				count, err := readInt()
+
+				if err != nil {
+					return err
+				}
+
+				if previous, ok := functionUpvalues[index]; ok && previous != count {
+					return v.error(start, fmt.Sprintf("function %d is created with %d and %d upvalues", index, previous, count))
+				}
+
+				functionUpvalues[index] = count
+				closures = append(closures, index)
+				created[index]++
+
+				for range count {
+					if ip >= len(chunk.Code) {
+						return v.error(start, "the upvalues are cut off by the end of the chunk")
+					}
+
+					isLocal := chunk.Code[ip]
+					ip++
+
+					if isLocal > 1 {
+						return v.error(start, fmt.Sprintf("invalid upvalue kind %d", isLocal))
+					}
+
+					slot, err := readInt()
+
+					if err != nil {
+						return err
+					}
+
+					// Upvalues that aren't locals come from the enclosing function's.
+					if isLocal == 0 && upvalues >= 0 && slot >= upvalues {
+						return v.error(start, fmt.Sprintf("upvalue %d is out of range, the function has %d upvalues", slot, upvalues))
+					}
+
+					if isLocal == 1 {
+						in.captures = append(in.captures, slot)
+					}
+				}
+			}
+		}
+
+		in.next = ip
+		instructions[start] = in
+	}
+
+	// Jumping to the end of the chunk is fine, it's where the code ends.
+	for _, j := range jumps {
+		if j.target < 0 || j.target > len(chunk.Code) {
+			return v.error(j.offset, fmt.Sprintf("the jump lands at %d, outside the chunk", j.target))
+		}
+
+		if j.target != len(chunk.Code) && !boundaries[j.target] {
+			return v.error(j.offset, fmt.Sprintf("the jump lands at %d, which isn't the start of an instruction", j.target))
+		}
+	}
+
+	if err := v.verifyLocals(instructions, len(chunk.Code), locals); err != nil {
+		return err
+	}
+
+	for i, constant := range chunk.Constants {
+		fn, ok := constant.(value.ValueFunction)
+
+		if !ok {
+			continue
+		}
+
+		upvalues, ok := functionUpvalues[i]
+
+		if !ok {
+			upvalues = -1
+		}
+
+		if fn.Arity < 0 {
+			return v.error(0, fmt.Sprintf("function %d has a negative arity", i))
+		}
+
+		locals := fn.Arity
+
+		if methods[i] > 0 {
+			if methods[i] != created[i] {
+				return v.error(0, fmt.Sprintf("function %d is used both as a method and as a function", i))
+			}
+
+			locals++ // the receiver
+		}
+
+		name := "an anonymous function"
+
+		if fn.Name != nil {
+			name = fmt.Sprintf("function '%s'", *fn.Name)
+		}
+
+		inner := verifier{ name: fmt.Sprintf("%s, in %s", name, v.name), globals: v.globals }
+
+		if err := inner.verify(fn.Chunk, upvalues, locals); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Follows every path of the chunk, counting the locals of the frame, so the slots of the instructions
+// always exist. The paths that meet must have the same amount of locals, like the compiler generates them.
+func (v *verifier) verifyLocals(instructions map[int]instruction, end int, locals int) error {
+	if len(instructions) == 0 {
+		return nil
+	}
+
+	depths := map[int]int{ 0: max(locals, 0) }
+	pending := []int{ 0 }
+
+	for len(pending) > 0 {
+		offset := util.PopList(&pending)
+		in := instructions[offset]
+		depth := depths[offset]
+
+		switch in.opcode {
+			case compiler.OP_DEF_LOCAL, compiler.OP_GET_LOCAL, compiler.OP_SET_LOCAL,
+				compiler.OP_POP_LOCAL, compiler.OP_POPN_LOCAL, compiler.OP_CLOSE_UPVALUE: {
+				if locals < 0 {
+					return v.error(offset, "the top-level can't have locals")
+				}
+			}
+		}
+
+		switch in.opcode {
+			case compiler.OP_DEF_LOCAL:
+				depth++
+
+			case compiler.OP_GET_LOCAL, compiler.OP_SET_LOCAL: {
+				if in.operand >= depth {
+					return v.error(offset, fmt.Sprintf("local %d is out of range, there are %d locals", in.operand, depth))
+				}
+			}
+
+			case compiler.OP_POP_LOCAL, compiler.OP_CLOSE_UPVALUE: {
+				if depth == 0 {
+					return v.error(offset, "there are no locals to pop")
+				}
+
+				depth--
+			}
+
+			case compiler.OP_POPN_LOCAL: {
+				if in.operand > depth {
+					return v.error(offset, fmt.Sprintf("%d locals are popped, but there are %d", in.operand, depth))
+				}
+
+				depth -= in.operand
+			}
+
+			case compiler.OP_PUSH_CLOSURE: {
+				for _, slot := range in.captures {
+					if slot >= depth {
+						return v.error(offset, fmt.Sprintf("the closure captures local %d, but there are %d locals", slot, depth))
+					}
+				}
+			}
+		}
+
+		next := []int{}
+
+		switch in.opcode {
+			case compiler.OP_JUMP, compiler.OP_LOOP:
+				next = append(next, in.target)
+
+			case compiler.OP_RETURN:
+
+			// The handler's target is where the 'catch' starts, with the locals of the 'try'.
+			case compiler.OP_JUMP_TRUE, compiler.OP_JUMP_FALSE, compiler.OP_JUMP_HAS_NO_NEXT, compiler.OP_PUSH_HANDLER:
+				next = append(next, in.next, in.target)
+
+			default:
+				next = append(next, in.next)
+		}
+
+		for _, target := range next {
+			if target == end {
+				continue
+			}
+
+			if previous, ok := depths[target]; ok {
+				if previous != depth {
+					return v.error(target, fmt.Sprintf("the instruction is reached with %d and with %d locals", previous, depth))
+				}
+
+				continue
+			}
+
+			depths[target] = depth
+			pending = append(pending, target)
+		}
+	}
+
+	return nil
+}
+
+func (v *verifier) error(offset int, message string) error {
+	return &Error{
+		Function: v.name,
+		Offset: offset,
+		Message: message,
+	}
+}
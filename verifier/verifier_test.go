@@ -0,0 +1,209 @@
+package verifier
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/value"
+	"vm-go/vm"
+)
+
+func TestEveryOpcodeHasALayout(t *testing.T) {
+	for opcode := compiler.OP_PUSH_CONST; opcode <= compiler.OP_ASSERT_BOOL; opcode++ {
+		if _, ok := layouts[byte(opcode)]; !ok {
+			t.Errorf("opcode %d has no layout", opcode)
+		}
+	}
+}
+
+// The chunks generated by the compiler are always valid.
+func TestCompiledPrograms(t *testing.T) {
+	files, _ := filepath.Glob("../tests/*.vm")
+
+	for _, file := range files {
+		chunk, ok := compile(t, file)
+
+		if !ok {
+			continue
+		}
+
+		if err := Verify(chunk); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+func TestInstructionLength(t *testing.T) {
+	code := []byte{
+		compiler.OP_POP,
+		compiler.OP_GET_LOCAL, 0, 0, 0, 0,
+		compiler.OP_CALL_PROPERTY, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		compiler.OP_PUSH_CLOSURE, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		compiler.OP_CALL, 0,
+	}
+
+	tests := []struct {
+		offset int
+		length int
+		ok     bool
+	}{
+		{ 0, 1, true },
+		{ 1, 5, true },
+		{ 6, 13, true },
+		{ 19, 19, true },
+		{ 38, 0, false },
+	}
+
+	for _, test := range tests {
+		length, ok := InstructionLength(code, test.offset)
+
+		if length != test.length || ok != test.ok {
+			t.Errorf("offset %d: expected %d (%v), got %d (%v)", test.offset, test.length, test.ok, length, ok)
+		}
+	}
+
+	if _, ok := InstructionLength([]byte{ 250 }, 0); ok {
+		t.Error("expected an unknown opcode to have no length")
+	}
+}
+
+func TestInvalidChunks(t *testing.T) {
+	name := "f"
+	fn := value.ValueFunction{
+		Arity: 0,
+		Name: &name,
+		Chunk: chunk([]byte{ compiler.OP_GET_UPVALUE, 1, 0, 0, 0, compiler.OP_RETURN }),
+	}
+
+	tests := []struct {
+		name    string
+		chunk   value.Chunk
+		message string
+	}{
+		{ "unknown opcode", chunk([]byte{ 250 }), "unknown opcode" },
+		{ "cut operand", chunk([]byte{ compiler.OP_CALL, 0, 0 }), "cut off" },
+		{ "constant out of range", chunk([]byte{ compiler.OP_PUSH_CONST, 3, 0, 0, 0 }), "out of range" },
+		{ "jump into an operand", chunk([]byte{ compiler.OP_JUMP, 1, 0, 0, 0, compiler.OP_CALL, 0, 0, 0, 0 }), "isn't the start" },
+		{ "jump outside", chunk([]byte{ compiler.OP_LOOP, 9, 0, 0, 0 }), "outside the chunk" },
+		{ "missing metadata", value.Chunk{ Code: []byte{ compiler.OP_POP }, Metadata: []value.ChunkMetadata{} }, "positions" },
+		{ "upvalue at top-level", chunk([]byte{ compiler.OP_GET_UPVALUE, 0, 0, 0, 0 }), "upvalue 0 is out of range" },
+	}
+
+	wrongKind := chunk([]byte{ compiler.OP_GET_PROPERTY, 0, 0, 0, 0, 0, 0, 0, 0 })
+	wrongKind.Constants = []value.Value{ value.ValueNumber{ Value: 1 } }
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "property name", wrongKind, "must be a string" })
+
+	missingCache := chunk([]byte{ compiler.OP_SET_PROPERTY, 0, 0, 0, 0, 0, 0, 0, 0 })
+	missingCache.Constants = []value.Value{ value.ValueString{ Value: "x" } }
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "property cache", missingCache, "cache 0 is out of range" })
+
+	nested := chunk([]byte{ compiler.OP_PUSH_CLOSURE, 0, 0, 0, 0, 0, 0, 0, 0 })
+	nested.Constants = []value.Value{ fn }
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "nested function", nested, "function 'f'" })
+
+	getGlobal := chunk([]byte{ compiler.OP_GET_GLOBAL, 2, 0, 0, 0 })
+	getGlobal.Globals = 2
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "global out of range", getGlobal, "global 2 is out of range" })
+
+	setGlobal := function(0, []byte{ compiler.OP_PUSH_NIL, compiler.OP_SET_GLOBAL, 9, 0, 0, 0, compiler.OP_RETURN })
+	setGlobal.Globals = 1
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "global of a function", setGlobal, "global 9 is out of range" })
+
+	locals := []struct {
+		name    string
+		arity   int
+		code    []byte
+		message string
+	}{
+		{ "local out of range", 1, []byte{ compiler.OP_GET_LOCAL, 1, 0, 0, 0, compiler.OP_RETURN }, "local 1 is out of range, there are 1 locals" },
+		{ "local set out of range", 0, []byte{ compiler.OP_PUSH_NIL, compiler.OP_SET_LOCAL, 0, 0, 0, 0, compiler.OP_RETURN }, "local 0 is out of range" },
+		{ "local popped", 1, []byte{ compiler.OP_POP_LOCAL, compiler.OP_GET_LOCAL, 0, 0, 0, 0, compiler.OP_RETURN }, "local 0 is out of range, there are 0 locals" },
+		{ "too many popped", 1, []byte{ compiler.OP_POPN_LOCAL, 2, 0, 0, 0, compiler.OP_RETURN }, "2 locals are popped, but there are 1" },
+		{ "nothing to close", 0, []byte{ compiler.OP_CLOSE_UPVALUE, compiler.OP_RETURN }, "no locals to pop" },
+		{
+			// The local is only declared if the jump isn't taken, so the paths meet with different locals.
+			"paths disagree", 0,
+			[]byte{ compiler.OP_PUSH_TRUE, compiler.OP_JUMP_FALSE, 2, 0, 0, 0, compiler.OP_PUSH_NIL, compiler.OP_DEF_LOCAL, compiler.OP_RETURN },
+			"the instruction is reached with",
+		},
+	}
+
+	for _, l := range locals {
+		tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ l.name, function(l.arity, l.code), l.message })
+	}
+
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "local at top-level", chunk([]byte{ compiler.OP_PUSH_NIL, compiler.OP_DEF_LOCAL }), "top-level can't have locals" })
+
+	// A method reads its receiver from the slot after its parameters, so it can't be called without it.
+	method := value.ValueFunction{ Arity: 0, Chunk: chunk([]byte{ compiler.OP_GET_LOCAL, 0, 0, 0, 0, compiler.OP_RETURN }) }
+	record := chunk([]byte{ compiler.OP_PUSH_CONST, 0, 0, 0, 0, compiler.OP_PUSH_CLOSURE, 1, 0, 0, 0, 0, 0, 0, 0, compiler.OP_APPEND_METHODS, 1, 0, 0, 0 })
+	record.Constants = []value.Value{ value.NewRecord("R", []string{}), method }
+
+	if err := Verify(record); err != nil {
+		t.Errorf("expected the method to be valid, got '%s'", err)
+	}
+
+	calledMethod := record
+	calledMethod.Code = append(append([]byte{}, record.Code...), compiler.OP_PUSH_CLOSURE, 1, 0, 0, 0, 0, 0, 0, 0)
+	calledMethod.Metadata = make([]value.ChunkMetadata, len(calledMethod.Code))
+	tests = append(tests, struct{ name string; chunk value.Chunk; message string }{ "method as a function", calledMethod, "both as a method and as a function" })
+
+	for _, test := range tests {
+		err := Verify(test.chunk)
+
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		} else if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%s: expected '%s' in '%s'", test.name, test.message, err)
+		}
+	}
+}
+
+func chunk(code []byte) value.Chunk {
+	return value.Chunk{
+		Code: code,
+		Constants: []value.Value{},
+		Metadata: make([]value.ChunkMetadata, len(code)),
+	}
+}
+
+// A top-level chunk that creates a closure of a function with the code.
+func function(arity int, code []byte) value.Chunk {
+	name := "g"
+	top := chunk([]byte{ compiler.OP_PUSH_CLOSURE, 0, 0, 0, 0, 0, 0, 0, 0 })
+	top.Constants = []value.Value{ value.ValueFunction{ Arity: arity, Name: &name, Chunk: chunk(code) } }
+
+	return top
+}
+
+func compile(t *testing.T, file string) (value.Chunk, bool) {
+	source, err := os.ReadFile(file)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	streams := util.NewStreams(io.Discard, io.Discard, strings.NewReader(""))
+	fileData := util.FileData{ Name: filepath.Base(file), Path: file, Lines: strings.Split(string(source), "\n") }
+
+	tokens, hadError := lexer.NewLexer(string(source), &fileData, streams).Lex()
+
+	if hadError {
+		return value.Chunk{}, false
+	}
+
+	ast, hadError := parser.NewParser(tokens, &fileData, streams).Parse()
+
+	if hadError {
+		return value.Chunk{}, false
+	}
+
+	chunk, hadError := compiler.NewCompiler(ast, &fileData, vm.DefaultRegistry(streams), streams).Compile()
+	return chunk, !hadError
+}
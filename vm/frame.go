@@ -3,7 +3,19 @@ package vm
 import "vm-go/value"
 
 type CallFrame struct {
-	function *value.ValueClosure
+	function value.ValueClosure
 	oldIp int
-	locals []value.Value
+	callIp int // where the call instruction starts, in the caller's chunk, for the traces
+	chunk *value.Chunk // the caller's, which it returns to
+	locals []value.Slot
+}
+
+// Saves the state of the VM when entering a 'try' block, to restore it if an error is caught.
+type ErrorHandler struct {
+	catchIp int
+	chunk   *value.Chunk
+
+	frameCount  int
+	stackSize   int
+	localsCount int
 }
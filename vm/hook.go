@@ -0,0 +1,189 @@
+package vm
+
+import (
+	"vm-go/value"
+)
+
+// The functions used by the tools that watch the program while it runs, like the debugger.
+
+// 'Step' is called before each instruction, which hasn't run yet, and the run stops with STATUS_STOPPED
+// if it returns false. The VM only checks for the hooks every instruction when there's one.
+type Hook interface {
+	Step(v *VM) bool
+}
+
+func (v *VM) AddHook(hook Hook) {
+	v.hooks = append(v.hooks, hook)
+	v.nextCheck = v.executed
+}
+
+func (v *VM) runHooks() bool {
+	for _, hook := range v.hooks {
+		if !hook.Step(v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A call of the program, as the tools see it.
+type Frame struct {
+	Name     string
+	Position value.ChunkMetadata // of the next instruction of the innermost frame, and of the call the others wait for
+
+	// The variables without a name, like the ones of a chunk loaded from bytecode, have an empty one.
+	Locals   []Variable
+	Upvalues []Variable
+}
+
+type Variable struct {
+	Name  string
+	Value value.Value
+}
+
+// The position of the next instruction.
+func (v *VM) Position() value.ChunkMetadata {
+	return metadataAt(v.currentChunk, v.ip)
+}
+
+// The chunk of the next instruction, and its offset. Closures of the same function share the code of its chunk.
+func (v *VM) Chunk() *value.Chunk {
+	return v.currentChunk
+}
+
+func (v *VM) Offset() int {
+	return v.ip
+}
+
+// The opcode of the next instruction.
+func (v *VM) Instruction() byte {
+	return v.currentChunk.Code[v.ip]
+}
+
+// How many calls are running, 0 in the top-level code.
+func (v *VM) Depth() int {
+	return len(v.callStack)
+}
+
+// The values of the stack, the top last.
+func (v *VM) Stack() []value.Value {
+	values := make([]value.Value, len(v.stack))
+
+	for i, slot := range v.stack {
+		values[i] = slot.Value()
+	}
+
+	return values
+}
+
+// The function of the innermost call, nil in the top-level code.
+func (v *VM) Function() *value.ValueFunction {
+	if len(v.callStack) == 0 {
+		return nil
+	}
+
+	return v.callStack[len(v.callStack) - 1].function.Fn
+}
+
+// The top-level code comes first, and the innermost call last.
+func (v *VM) Frames() []Frame {
+	frames := []Frame{ { Name: "<top-level>" } }
+
+	for i, call := range v.callStack {
+		frames[i].Position = metadataAt(call.chunk, call.callIp)
+		chunk := &call.function.Fn.Chunk
+
+		// The locals are named by where the frame is.
+		offset := v.ip
+
+		if i < len(v.callStack) - 1 {
+			offset = v.callStack[i + 1].callIp
+		}
+
+		frame := Frame{ Name: "<anonymous>", Locals: []Variable{}, Upvalues: []Variable{} }
+
+		if call.function.Fn.Name != nil {
+			frame.Name = *call.function.Fn.Name
+		}
+
+		for slot, local := range call.locals {
+			frame.Locals = append(frame.Locals, Variable{ Name: chunk.LocalName(slot, offset), Value: local.Value() })
+		}
+
+		for j, upvalue := range call.function.Upvalues {
+			name := ""
+
+			if j < len(chunk.Upvalues) {
+				name = chunk.Upvalues[j]
+			}
+
+			frame.Upvalues = append(frame.Upvalues, Variable{ Name: name, Value: v.getUpvalueValue(upvalue).Value() })
+		}
+
+		frames = append(frames, frame)
+	}
+
+	frames[len(frames) - 1].Position = v.Position()
+	return frames
+}
+
+func metadataAt(chunk *value.Chunk, offset int) value.ChunkMetadata {
+	if offset < 0 || offset >= len(chunk.Metadata) {
+		return value.ChunkMetadata{}
+	}
+
+	return chunk.Metadata[offset]
+}
+
+// Runs a chunk compiled by 'CompileExpression' inside the innermost frame, from a hook, and returns the value
+// it leaves on the stack. The program then continues where it was paused, with the changes the expression made
+// to its variables. The 'try' blocks of the program don't catch its errors, which aren't printed: 'ErrorMessage'
+// returns them.
+func (v *VM) Evaluate(chunk value.Chunk) (result value.Value, status InterpretResult) {
+	ip, oldIp, currentChunk := v.ip, v.oldIp, v.currentChunk
+	stackSize, frameCount, handlers := len(v.stack), len(v.callStack), v.handlers
+	executed, nextCheck, hooks := v.executed, v.nextCheck, v.hooks
+	localsCount := 0
+
+	if frameCount > 0 {
+		localsCount = len(v.callStack[frameCount - 1].locals)
+	}
+
+	v.currentChunk, v.ip, v.oldIp = &chunk, 0, 0
+	v.handlers = []ErrorHandler{}
+	v.hooks = nil
+
+	// Put the program back as it was, even if the expression failed halfway through a call.
+	defer func() {
+		if status != STATUS_OK {
+			for i := len(v.callStack) - 1; i >= frameCount; i-- {
+				v.closeUpvalues(i)
+			}
+
+			v.callStack = v.callStack[:frameCount]
+
+			if frameCount > 0 {
+				for i := localsCount; i < len(v.callStack[frameCount - 1].locals); i++ {
+					v.closeUpvalue(frameCount - 1, i)
+				}
+
+				v.callStack[frameCount - 1].locals = v.callStack[frameCount - 1].locals[:localsCount]
+			}
+		}
+
+		v.stack = v.stack[:min(stackSize, len(v.stack))]
+		v.ip, v.oldIp, v.currentChunk = ip, oldIp, currentChunk
+		v.handlers = handlers
+		v.executed, v.nextCheck, v.hooks = executed, nextCheck, hooks
+		v.hadError = false
+	}()
+
+	status = v.run()
+
+	if status != STATUS_OK || v.hadError {
+		return value.ValueNil{}, status
+	}
+
+	return v.pop(), STATUS_OK
+}
@@ -0,0 +1,83 @@
+package vm
+
+import "vm-go/value"
+
+// The functions used by the hosts that embed the VM, after the program has run.
+
+func (v *VM) Global(index int) value.Value {
+	return v.globals[index].Value()
+}
+
+func (v *VM) SetGlobal(index int, global value.Value) {
+	v.globals[index] = value.ToSlot(value.CopyValue(global))
+}
+
+// The message of the last runtime error.
+func (v *VM) ErrorMessage() string {
+	return v.errorMessage
+}
+
+// Calls a function of the program, or any other callable value, and returns its result.
+// The call runs like a call from the end of the top-level code, so it stops when the function returns.
+func (v *VM) CallValue(callee value.Value, args []value.Value) (value.Value, InterpretResult) {
+	v.reset()
+
+	v.push(callee)
+
+	for _, arg := range args {
+		v.push(value.CopyValue(arg))
+	}
+
+	status := v.call(callee, len(args))
+
+	// Errors of the call itself, like a wrong arity, have no position to print.
+	if status == STATUS_OK {
+		status = v.Run()
+	}
+
+	if status != STATUS_OK {
+		return value.ValueNil{}, status
+	}
+
+	return v.pop(), STATUS_OK
+}
+
+// Discards what a previous run left behind, like the frames of an uncaught error.
+func (v *VM) reset() {
+	for i := len(v.callStack) - 1; i >= 0; i-- {
+		v.closeUpvalues(i)
+	}
+
+	v.stack = v.stack[:0]
+	v.callStack = v.callStack[:0]
+	v.handlers = v.handlers[:0]
+
+	v.currentChunk = &v.topLevel
+	v.ip = len(v.topLevel.Code)
+	v.oldIp = v.ip
+	v.hadError = false
+}
+
+// Runs another chunk with the same globals, like the inputs of the REPL, and returns the value it leaves on the stack.
+func (v *VM) RunChunk(chunk value.Chunk) (value.Value, InterpretResult) {
+	v.topLevel = chunk
+	v.reset()
+	v.ip = 0
+
+	status := v.Run()
+
+	if status != STATUS_OK {
+		return value.ValueNil{}, status
+	}
+
+	return v.pop(), STATUS_OK
+}
+
+func (v *VM) GlobalCount() int {
+	return len(v.globals)
+}
+
+// Forgets the globals after the first 'count', like the ones of an input of the REPL that failed.
+func (v *VM) DiscardGlobals(count int) {
+	v.globals = v.globals[:count]
+}
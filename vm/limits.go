@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The limits of the VM, so the scripts it runs can't grow it until Go runs out of memory, or run forever.
+
+type Limits struct {
+	// How deep the calls can nest, and how many values the stack can hold, before a 'stack overflow' error.
+	MaxCallDepth int
+	MaxStackSize int
+
+	// How many instructions each 'Run' can execute, 0 for no limit.
+	MaxInstructions int
+}
+
+var DefaultLimits = Limits{
+	MaxCallDepth: 10000,
+	MaxStackSize: 1 << 20,
+}
+
+// The deadline and the context are only checked every so many instructions, as it's slower.
+const checkInterval = 1024
+
+// The limits left at 0 keep their value.
+func (v *VM) SetLimits(limits Limits) {
+	if limits.MaxCallDepth > 0 {
+		v.limits.MaxCallDepth = limits.MaxCallDepth
+	}
+
+	if limits.MaxStackSize > 0 {
+		v.limits.MaxStackSize = limits.MaxStackSize
+	}
+
+	if limits.MaxInstructions > 0 {
+		v.limits.MaxInstructions = limits.MaxInstructions
+	}
+}
+
+// Stops the runs that are still going at the deadline, the zero time removes it.
+func (v *VM) SetDeadline(deadline time.Time) {
+	v.deadline = deadline
+}
+
+// Stops the runs once the context is done, nil removes it.
+func (v *VM) SetContext(ctx context.Context) {
+	v.context = ctx
+}
+
+// The errors that end the run, even inside a 'try' block.
+func (r InterpretResult) stopsExecution() bool {
+	return r == STATUS_INSTRUCTION_LIMIT || r == STATUS_TIMEOUT || r == STATUS_CANCELLED
+}
+
+// Checked before pushing a frame. The stack only grows without bound through calls, as each
+// function leaves a bounded amount of values in it, so it's checked here too.
+func (v *VM) checkLimits() InterpretResult {
+	if len(v.callStack) >= v.limits.MaxCallDepth {
+		v.error(fmt.Sprintf("Stack overflow, the calls are nested more than %d times.", v.limits.MaxCallDepth))
+		return STATUS_STACK_OVERFLOW
+	}
+
+	if len(v.stack) > v.limits.MaxStackSize {
+		v.error(fmt.Sprintf("Stack overflow, the stack holds more than %d values.", v.limits.MaxStackSize))
+		return STATUS_STACK_OVERFLOW
+	}
+
+	return STATUS_OK
+}
+
+// Checked before the instruction when 'nextCheck' is reached, so the dispatch loop only compares two numbers.
+func (v *VM) checkExecution() InterpretResult {
+	if v.limits.MaxInstructions > 0 && v.executed > v.limits.MaxInstructions {
+		v.error(fmt.Sprintf("The program ran more than %d instructions.", v.limits.MaxInstructions))
+		return STATUS_INSTRUCTION_LIMIT
+	}
+
+	if !v.deadline.IsZero() && time.Now().After(v.deadline) {
+		v.error("The program didn't finish before its deadline.")
+		return STATUS_TIMEOUT
+	}
+
+	if v.context != nil && v.context.Err() != nil {
+		v.error(fmt.Sprintf("The program was cancelled: %s.", v.context.Err()))
+		return STATUS_CANCELLED
+	}
+
+	v.nextCheck = v.executed + checkInterval
+
+	// The budget is checked right after its last instruction.
+	if v.limits.MaxInstructions > 0 {
+		v.nextCheck = min(v.nextCheck, v.limits.MaxInstructions + 1)
+	}
+
+	// The hooks see every instruction, so the next one is checked too.
+	if len(v.hooks) > 0 {
+		if !v.runHooks() {
+			return STATUS_STOPPED
+		}
+
+		v.nextCheck = v.executed + 1
+	}
+
+	return STATUS_OK
+}
@@ -1,89 +1,196 @@
 package vm
 
 import (
-	"bufio"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+	"vm-go/util"
 	"vm-go/value"
 )
 
-func (v *VM) includeNativeFns() {
-    appendNativeFn(&v.globals, 1, nativePrint)
-    appendNativeFn(&v.globals, 1, nativePrintln)
-    appendNativeFn(&v.globals, 1, nativeInput)
-    appendNativeFn(&v.globals, 0, nativeTime)
-    appendNativeFn(&v.globals, 1, nativeStr)
-    appendNativeFn(&v.globals, 1, nativeNum)
-    appendNativeFn(&v.globals, 1, nativeType)
-}
+// The native functions available to every program, hosts can add their own to the registry.
+// The ones that touch the outside world need a capability, see 'value.Sandbox'.
+func DefaultRegistry(streams *util.Streams) *value.Registry {
+	registry := value.NewRegistry()
+
+	// fn print(value: any) -> void
+	registry.DefineGuardedNative("print", 1, value.CapabilityIO, nativePrint(streams))
+
+	// fn println(value: any) -> void
+	registry.DefineGuardedNative("println", 1, value.CapabilityIO, nativePrintln(streams))
+
+	// fn input(prompt: str) -> str
+	registry.DefineGuardedNative("input", 1, value.CapabilityIO, nativeInput(streams))
+
+	// fn time() -> num
+	registry.DefineGuardedNative("time", 0, value.CapabilityTime, nativeTime)
+
+	// fn readFile(path: str) -> str
+	registry.DefineGuardedNative("readFile", 1, value.CapabilityFS, nativeReadFile(registry))
+
+	// fn writeFile(path: str, content: str) -> void
+	registry.DefineGuardedNative("writeFile", 2, value.CapabilityFS, nativeWriteFile(registry))
+
+	// fn env(name: str) -> str | nil
+	registry.DefineGuardedNative("env", 1, value.CapabilityEnv, nativeEnv)
+
+	// fn random() -> num, between 0 and 1
+	registry.DefineGuardedNative("random", 0, value.CapabilityRandom, nativeRandom)
+
+	// fn str(value: any) -> str
+	registry.DefineNative("str", 1, nativeStr)
+
+	// fn num(n: str) -> num
+	registry.DefineNative("num", 1, nativeNum)
+
+	// fn type(value: any) -> str
+	registry.DefineNative("type", 1, nativeType)
 
-func appendNativeFn(list *[]value.Value, arity int, fn value.NativeFn) {
-    *list = append(*list, value.ValueNativeFn{
-        Arity: arity,
-        Fn: fn,
-    })
+	return registry
 }
 
 // ---
 
+// The natives that use the streams are created for them.
+
 // TODO: use format string "%.10g" without printing {}
-func nativePrint(args []value.Value) value.Value {
-	fmt.Print(args[0].String())
-	return value.ValueVoid{}
+func nativePrint(streams *util.Streams) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		fmt.Fprint(streams.Stdout, args[0].String())
+		return value.ValueVoid{}, nil
+	}
+}
+
+func nativePrintln(streams *util.Streams) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		fmt.Fprintln(streams.Stdout, args[0].String())
+		return value.ValueVoid{}, nil
+	}
+}
+
+func nativeInput(streams *util.Streams) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		prompt, ok := args[0].(value.ValueString)
+		if !ok {
+			return nil, fmt.Errorf("The prompt must be a string. (got '%s', of type '%s')", args[0].String(), args[0].Type())
+		}
+
+		fmt.Fprint(streams.Stdout, prompt.Value)
+
+		// The last line may not end with a newline.
+		input, err := streams.Stdin.ReadString('\n')
+
+		if err != nil && (err != io.EOF || input == "") {
+			return nil, fmt.Errorf("Cannot read the input: %s.", err)
+		}
+
+		// Trim the newline character from the input
+		input = strings.TrimSpace(input)
+		return value.ValueString{Value: input}, nil
+	}
 }
 
-func nativePrintln(args []value.Value) value.Value {
-    fmt.Println(args[0].String())
-	return value.ValueVoid{}
+func nativeTime(_ []value.Value) (value.Value, error) {
+	return value.ValueNumber{ Value: float64(time.Now().UnixMilli()) }, nil
 }
 
-func nativeInput(args []value.Value) value.Value {
-	prompt, ok := args[0].(value.ValueString)
-    if !ok {
-        return value.ValueNil{}
-    }
+// The paths are checked against the sandbox of the registry when they're used, as it can be set later.
+func nativeReadFile(registry *value.Registry) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		path, ok := args[0].(value.ValueString)
 
-    fmt.Print(prompt.Value)
+		if !ok {
+			return nil, fmt.Errorf("The path must be a string. (got '%s', of type '%s')", args[0].String(), args[0].Type())
+		}
 
-    reader := bufio.NewReader(os.Stdin)
-    input, err := reader.ReadString('\n')
+		resolved, err := registry.Sandbox().CheckPath(path.Value)
 
-    if err != nil {
-        return value.ValueNil{}
-    }
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read '%s': %s.", path.Value, err)
+		}
+
+		content, err := os.ReadFile(resolved)
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read '%s': %s.", path.Value, err)
+		}
+
+		return value.ValueString{ Value: string(content) }, nil
+	}
+}
+
+func nativeWriteFile(registry *value.Registry) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		path, ok := args[0].(value.ValueString)
+
+		if !ok {
+			return nil, fmt.Errorf("The path must be a string. (got '%s', of type '%s')", args[0].String(), args[0].Type())
+		}
+
+		content, ok := args[1].(value.ValueString)
+
+		if !ok {
+			return nil, fmt.Errorf("The content must be a string. (got '%s', of type '%s')", args[1].String(), args[1].Type())
+		}
+
+		resolved, err := registry.Sandbox().CheckPath(path.Value)
+
+		if err != nil {
+			return nil, fmt.Errorf("Cannot write '%s': %s.", path.Value, err)
+		}
+
+		if err := os.WriteFile(resolved, []byte(content.Value), 0644); err != nil {
+			return nil, fmt.Errorf("Cannot write '%s': %s.", path.Value, err)
+		}
+
+		return value.ValueVoid{}, nil
+	}
+}
+
+func nativeEnv(args []value.Value) (value.Value, error) {
+	name, ok := args[0].(value.ValueString)
+
+	if !ok {
+		return nil, fmt.Errorf("The name must be a string. (got '%s', of type '%s')", args[0].String(), args[0].Type())
+	}
+
+	env, ok := os.LookupEnv(name.Value)
+
+	if !ok {
+		return value.ValueNil{}, nil
+	}
 
-    // Trim the newline character from the input
-    input = strings.TrimSpace(input)
-    return value.ValueString{Value: input}
+	return value.ValueString{ Value: env }, nil
 }
 
-func nativeTime(_ []value.Value) value.Value {
-	return value.ValueNumber{ Value: float64(time.Now().UnixMilli()) }
+func nativeRandom(_ []value.Value) (value.Value, error) {
+	return value.ValueNumber{ Value: rand.Float64() }, nil
 }
 
-func nativeStr(args []value.Value) value.Value {
-	return value.ValueString{ Value: args[0].String() }
+func nativeStr(args []value.Value) (value.Value, error) {
+	return value.ValueString{ Value: args[0].String() }, nil
 }
 
-func nativeNum(args []value.Value) value.Value {
+func nativeNum(args []value.Value) (value.Value, error) {
 	argStr, ok := args[0].(value.ValueString)
 
 	if !ok {
-		return value.ValueNil{}
+		return nil, fmt.Errorf("Only strings can be converted to numbers. (got '%s', of type '%s')", args[0].String(), args[0].Type())
 	}
 
 	asNum, err := strconv.ParseFloat(argStr.Value, 64)
 
 	if err != nil {
-		return value.ValueNil{}
+		return nil, fmt.Errorf("Cannot convert '%s' to a number.", argStr.Value)
 	}
 
-	return value.ValueNumber{ Value: asNum }
+	return value.ValueNumber{ Value: asNum }, nil
 }
 
-func nativeType(args []value.Value) value.Value {
-	return value.ValueString{Value: args[0].Type()}
+func nativeType(args []value.Value) (value.Value, error) {
+	return value.ValueString{Value: args[0].Type()}, nil
 }
@@ -22,18 +22,58 @@ func typesEqual(a, b value.Value) bool {
 }
 
 func valuesEqual(a, b value.Value) bool {
-	switch a.(type) {
+	switch a := a.(type) {
 		case value.ValueNil:
 			return true;
 		
 		case value.ValueVoid:
 			return true;
+
+		case value.ValueList: {
+			other, ok := b.(value.ValueList)
+
+			if !ok || len(*a.Elements) != len(*other.Elements) {
+				return false
+			}
+
+			for i, element := range *a.Elements {
+				if !elementsEqual(element, (*other.Elements)[i]) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+		// The order the keys were added in doesn't matter.
+		case value.ValueMap: {
+			other, ok := b.(value.ValueMap)
+
+			if !ok || len(a.Entries.Keys) != len(other.Entries.Keys) {
+				return false
+			}
+
+			for key, val := range a.Entries.Values {
+				otherVal, ok := other.Get(key)
+
+				if !ok || !elementsEqual(val, otherVal) {
+					return false
+				}
+			}
+
+			return true
+		}
 		
 		default:
 			return reflect.DeepEqual(a, b)
 	}
 }
 
+// The elements of lists and maps can have different types, unlike the operands of '=='.
+func elementsEqual(a, b value.Value) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && valuesEqual(a, b)
+}
+
 func (v *VM) getByte() byte {
 	res := v.currentChunk.Code[v.ip]
 	v.ip += 1
@@ -137,7 +177,7 @@ func (v *VM) closeUpvalues(localsIndex int) {
 
 // ---
 
-func (v *VM) getUpvalueValue(upvalue *value.Upvalue) value.Value {
+func (v *VM) getUpvalueValue(upvalue *value.Upvalue) value.Slot {
 	if upvalue.IsClosed {
 		return upvalue.ClosedValue
 	} else {
@@ -145,7 +185,7 @@ func (v *VM) getUpvalueValue(upvalue *value.Upvalue) value.Value {
 	}
 }
 
-func (v *VM) setUpvalueValue(upvalue *value.Upvalue, val value.Value) {
+func (v *VM) setUpvalueValue(upvalue *value.Upvalue, val value.Slot) {
 	if upvalue.IsClosed {
 		upvalue.ClosedValue = val
 	} else {
@@ -196,6 +236,23 @@ func (v *VM) getArguments(arity int) []value.Value {
 	return args
 }
 
+// Pops the arguments of a call as the first locals of the function, in order, after 'receiver' if there's one.
+// They stay as slots, so the numbers aren't boxed.
+func (v *VM) argumentLocals(arity int, receiver value.Value) []value.Slot {
+	locals := make([]value.Slot, 0, arity + 1)
+
+	if receiver != nil {
+		locals = append(locals, value.ToSlot(receiver))
+	}
+
+	for _, arg := range v.stack[len(v.stack) - arity:] {
+		locals = append(locals, arg.Copy())
+	}
+
+	v.stack = v.stack[:len(v.stack) - arity]
+	return locals
+}
+
 func (v *VM) call(callee value.Value, arity int) InterpretResult {
 	if !isClosure(callee) && !isNativeFunction(callee) && !isRecord(callee) && !isBoundMethod(callee) {
 		v.error(fmt.Sprintf("Can only call functions or records. (called '%s', of type '%s')", callee.String(), callee.Type()))
@@ -208,19 +265,19 @@ func (v *VM) call(callee value.Value, arity int) InterpretResult {
 				v.error(fmt.Sprintf("Expected %d arguments, but got %d instead.", function.Fn.Arity, arity))
 				return STATUS_INCORRECT_ARITY
 			}
+
+			if status := v.checkLimits(); status != STATUS_OK {
+				return status
+			}
 		
 			v.callStack = append(v.callStack, CallFrame{
-				function: &function,
+				function: function,
 				oldIp: v.ip,
+				callIp: v.oldIp,
+				chunk: v.currentChunk,
+				locals: v.argumentLocals(arity, nil),
 			})
 		
-			args := v.getArguments(arity)
-		
-			// insert the arguments into the locals array
-			for i := len(args) - 1; i >= 0; i-- {
-				v.callStack[len(v.callStack) - 1].locals = append(v.callStack[len(v.callStack) - 1].locals, args[i])
-			}
-		
 			v.ip = 0
 			v.currentChunk = &function.Fn.Chunk
 		
@@ -233,12 +290,24 @@ func (v *VM) call(callee value.Value, arity int) InterpretResult {
 				return STATUS_INCORRECT_ARITY
 			}
 
+			// The compiler rejects the natives that aren't granted, but the host can still pass them around.
+			if !v.sandbox.Allows(function.Capability) {
+				v.error(value.MissingCapability(function.Name, function.Capability))
+				return STATUS_CAPABILITY_DENIED
+			}
+
 			args := v.getArguments(arity)
 
 			util.Reverse(args)
 			v.pop() // The function.
 
-			result := function.Fn(args)
+			result, err := function.Fn(args)
+
+			if err != nil {
+				v.error(err.Error())
+				return STATUS_NATIVE_ERROR
+			}
+
 			v.push(result)
 		}
 
@@ -268,20 +337,19 @@ func (v *VM) call(callee value.Value, arity int) InterpretResult {
 				v.error(fmt.Sprintf("Expected %d arguments, but got %d instead.", function.Method.Fn.Arity, arity))
 				return STATUS_INCORRECT_ARITY
 			}
+
+			if status := v.checkLimits(); status != STATUS_OK {
+				return status
+			}
 		
 			v.callStack = append(v.callStack, CallFrame{
-				function: &function.Method,
+				function: function.Method,
 				oldIp: v.ip,
+				callIp: v.oldIp,
+				chunk: v.currentChunk,
+				locals: v.argumentLocals(arity, function.Receiver),
 			})
 		
-			args := v.getArguments(arity)
-			v.callStack[len(v.callStack) - 1].locals = append(v.callStack[len(v.callStack) - 1].locals, function.Receiver)
-
-			// insert the arguments into the locals array
-			for i := len(args) - 1; i >= 0; i-- {
-				v.callStack[len(v.callStack) - 1].locals = append(v.callStack[len(v.callStack) - 1].locals, args[i])
-			}
-		
 			v.ip = 0
 			v.currentChunk = &function.Method.Fn.Chunk
 		
@@ -295,13 +363,28 @@ func (v *VM) call(callee value.Value, arity int) InterpretResult {
 	return STATUS_OK
 }
 
+// Iterators are pushed as pointers, so they can be advanced in place through the Iterator interface.
 func (v *VM) makeIterator(iterable value.Value) (value.Value, InterpretResult) {
 	switch it := iterable.(type) {
-		case value.ValueRange:
-			return value.NewRangeIterator(it), STATUS_OK
+		case value.ValueRange: {
+			iterator := value.NewRangeIterator(it)
+			return &iterator, STATUS_OK
+		}
 		
-		case value.ValueString:
-			return value.NewStrBytesIterator(it.Value), STATUS_OK
+		case value.ValueString: {
+			iterator := value.NewStrBytesIterator(it.Value)
+			return &iterator, STATUS_OK
+		}
+
+		case value.ValueList: {
+			iterator := value.NewListIterator(it)
+			return &iterator, STATUS_OK
+		}
+
+		case value.ValueMap: {
+			iterator := value.NewMapIterator(it)
+			return &iterator, STATUS_OK
+		}
 
 		default: {
 			v.error(fmt.Sprintf("Expected iterable, got '%s', of type '%s'.", iterable.String(), iterable.Type()))
@@ -310,38 +393,142 @@ func (v *VM) makeIterator(iterable value.Value) (value.Value, InterpretResult) {
 	}
 }
 
-func (v *VM) getPropertyValue(obj value.Value, index int) (value.Value, InterpretResult) {
+func (v *VM) peekIterator() (value.Iterator, InterpretResult) {
+	iterator := v.peek(0)
+	it, ok := iterator.(value.Iterator)
+
+	if !ok {
+		v.error(fmt.Sprintf("Expected iterator, got '%s', of type '%s'.", iterator.String(), iterator.Type()))
+		return nil, STATUS_TYPE_ERROR
+	}
+
+	return it, STATUS_OK
+}
+
+// Unwinds the call stack and the value stack to the innermost 'try' block, and jumps to its 'catch' block,
+// with the error on top of the stack. Returns false if there's no 'try' block to catch the error.
+func (v *VM) catchError(status InterpretResult) bool {
+	if len(v.handlers) == 0 {
+		return false
+	}
+
+	handler := util.PopList(&v.handlers)
+
+	// Close the upvalues of the frames that are being discarded.
+	for i := len(v.callStack) - 1; i >= handler.frameCount; i-- {
+		v.closeUpvalues(i)
+	}
+
+	v.callStack = v.callStack[:handler.frameCount]
+
+	// And the upvalues of the locals declared inside the 'try' block.
+	frame := &v.callStack[len(v.callStack) - 1]
+
+	for i := handler.localsCount; i < len(frame.locals); i++ {
+		v.closeUpvalue(len(v.callStack) - 1, i)
+	}
+
+	frame.locals = frame.locals[:handler.localsCount]
+	v.stack = v.stack[:handler.stackSize]
+
+	v.currentChunk = handler.chunk
+	v.ip = handler.catchIp
+
+	v.push(value.ValueError{
+		Message: v.errorMessage,
+		Kind: status.String(),
+		Position: v.errorMetadata.Position,
+	})
+
+	v.hadError = false
+	return true
+}
+
+// Finds the slot of a property of an instance, in the inline cache of the instruction,
+// or in the record, updating the cache. Returns false if the property doesn't exist.
+func (v *VM) findSlot(instance value.ValueInstance, index, cache int) (int, bool) {
+	entry := &v.currentChunk.Caches[cache]
+
+	if entry.Shape == instance.Record.Shape {
+		return entry.Slot, true
+	}
+
+	slot, ok := instance.Record.Shape.Slots[v.currentChunk.Constants[index].(value.ValueString).Value]
+
+	if ok {
+		entry.Shape = instance.Record.Shape
+		entry.Slot = slot
+	}
+
+	return slot, ok
+}
+
+func (v *VM) getPropertyValue(obj value.Value, index, cache int) (value.Value, InterpretResult) {
+	// The instances are the hot path, the name is only needed for the other values, and the errors.
+	if instance, ok := obj.(value.ValueInstance); ok {
+		if slot, ok := v.findSlot(instance, index, cache); ok {
+			return instance.GetSlot(slot), STATUS_OK
+		}
+	}
+
 	nameValue := v.currentChunk.Constants[index]
 	name := nameValue.(value.ValueString).Value
 	
 	switch instance := obj.(type) {
 		case value.ValueInstance: {
+			v.error(fmt.Sprintf("Property '%s' doesn't exist in the object '%s', of type '%s'.", name, obj.String(), obj.Type()))
+			return nil, STATUS_PROPERTY_DOESNT_EXIST
+		}
+
+        case value.ValueRange: {
 			property, ok := instance.GetProperty(name)
 
 			if !ok {
-				v.error(fmt.Sprintf("Property '%s' doesn't exist in the object '%s', of type '%s'.", name, obj.String(), obj.Type()))
+				v.error(fmt.Sprintf("Property '%s' doesn't exist in the range '%s'.", name, obj.String()))
 				return nil, STATUS_PROPERTY_DOESNT_EXIST
 			}
 
 			return property, STATUS_OK
-		}
+        }
 
-        case value.ValueRange: {
+        case value.ValueString: {
 			property, ok := instance.GetProperty(name)
 
 			if !ok {
-				v.error(fmt.Sprintf("Property '%s' doesn't exist in the range '%s'.", name, obj.String()))
+				v.error(fmt.Sprintf("Property '%s' doesn't exist in the string '%s'.", name, obj.String()))
 				return nil, STATUS_PROPERTY_DOESNT_EXIST
 			}
 
 			return property, STATUS_OK
         }
 
-        case value.ValueString: {
+        case value.ValueMap: {
 			property, ok := instance.GetProperty(name)
 
 			if !ok {
-				v.error(fmt.Sprintf("Property '%s' doesn't exist in the string '%s'.", name, obj.String()))
+				v.error(fmt.Sprintf("Property '%s' doesn't exist in the map '%s'.", name, obj.String()))
+				return nil, STATUS_PROPERTY_DOESNT_EXIST
+			}
+
+			return property, STATUS_OK
+        }
+
+        case value.ValueError: {
+			property, ok := instance.GetProperty(name)
+
+			if !ok {
+				v.error(fmt.Sprintf("Property '%s' doesn't exist in the error '%s'.", name, obj.String()))
+				return nil, STATUS_PROPERTY_DOESNT_EXIST
+			}
+
+			return property, STATUS_OK
+        }
+
+        case value.ValueList: {
+			property, ok := instance.GetProperty(name)
+
+			if !ok {
+				v.error(fmt.Sprintf("Property '%s' doesn't exist in the list '%s'.", name, obj.String()))
 				return nil, STATUS_PROPERTY_DOESNT_EXIST
 			}
 
@@ -356,8 +543,8 @@ func (v *VM) getPropertyValue(obj value.Value, index int) (value.Value, Interpre
 	}
 }
 
-func (v *VM) getProperty(obj value.Value, index int) InterpretResult {
-	property, status := v.getPropertyValue(obj, index)
+func (v *VM) getProperty(obj value.Value, index, cache int) InterpretResult {
+	property, status := v.getPropertyValue(obj, index, cache)
 
 	if status != STATUS_OK {
 		return status
@@ -367,21 +554,22 @@ func (v *VM) getProperty(obj value.Value, index int) InterpretResult {
 	return STATUS_OK
 }
 
-func (v *VM) setProperty(obj value.Value, index int, val value.Value) InterpretResult {
+func (v *VM) setProperty(obj value.Value, index, cache int, val value.Value) InterpretResult {
+	// Only the fields can be changed, not the methods.
+	if instance, ok := obj.(value.ValueInstance); ok {
+		if slot, ok := v.findSlot(instance, index, cache); ok && instance.SetSlot(slot, val) {
+			v.push(val)
+			return STATUS_OK
+		}
+	}
+
 	nameValue := v.currentChunk.Constants[index]
 	name := nameValue.(value.ValueString).Value
 	
 	switch instance := obj.(type) {
 		case value.ValueInstance: {
-			ok := instance.SetProperty(name, val)
-
-			if !ok {
-				v.error(fmt.Sprintf("Property '%s' doesn't exist in the object '%s', of type '%s'.", name, obj.String(), obj.Type()))
-				return STATUS_PROPERTY_DOESNT_EXIST
-			}
-
-			v.push(val)
-			return STATUS_OK
+			v.error(fmt.Sprintf("Property '%s' doesn't exist in the object '%s', of type '%s'.", name, obj.String(), obj.Type()))
+			return STATUS_PROPERTY_DOESNT_EXIST
 		}
 
         case value.ValueRange: {
@@ -414,61 +602,141 @@ func (v *VM) setProperty(obj value.Value, index int, val value.Value) InterpretR
 	}
 }
 
+func (v *VM) getIndex(obj value.Value, index value.Value) InterpretResult {
+	switch collection := obj.(type) {
+		case value.ValueList: {
+			element, ok := collection.GetIndex(index)
+
+			if !ok {
+				v.error(fmt.Sprintf("Index '%s' is out of bounds or isn't an integer. The list has %d elements.", index.String(), len(*collection.Elements)))
+				return STATUS_OUT_OF_BOUNDS
+			}
+
+			v.push(element)
+			return STATUS_OK
+		}
+
+		case value.ValueMap: {
+			if !value.IsHashable(index) {
+				v.error(fmt.Sprintf("Map keys must be strings, numbers or bools. (key: '%s', of type '%s')", index.String(), index.Type()))
+				return STATUS_TYPE_ERROR
+			}
+
+			val, ok := collection.Get(index)
+
+			if !ok {
+				v.error(fmt.Sprintf("Key '%s' doesn't exist in the map.", index.String()))
+				return STATUS_KEY_DOESNT_EXIST
+			}
+
+			v.push(val)
+			return STATUS_OK
+		}
+
+		default: {
+			v.error(fmt.Sprintf("The object '%s' cannot be indexed. Its type is '%s'.", obj.String(), obj.Type()))
+			return STATUS_TYPE_ERROR
+		}
+	}
+}
+
+func (v *VM) setIndex(obj value.Value, index value.Value, val value.Value) InterpretResult {
+	switch collection := obj.(type) {
+		case value.ValueList: {
+			if !collection.SetIndex(index, val) {
+				v.error(fmt.Sprintf("Index '%s' is out of bounds or isn't an integer. The list has %d elements.", index.String(), len(*collection.Elements)))
+				return STATUS_OUT_OF_BOUNDS
+			}
+
+			v.push(val)
+			return STATUS_OK
+		}
+
+		case value.ValueMap: {
+			if !collection.Set(index, val) {
+				v.error(fmt.Sprintf("Map keys must be strings, numbers or bools. (key: '%s', of type '%s')", index.String(), index.Type()))
+				return STATUS_TYPE_ERROR
+			}
+
+			v.push(val)
+			return STATUS_OK
+		}
+
+		default: {
+			v.error(fmt.Sprintf("The object '%s' cannot be indexed. Its type is '%s'.", obj.String(), obj.Type()))
+			return STATUS_TYPE_ERROR
+		}
+	}
+}
+
+// 'entries' has the keys and values interleaved.
+func (v *VM) makeMap(entries []value.Value) InterpretResult {
+	m := value.NewValueMap()
+
+	for i := 0; i < len(entries); i += 2 {
+		if !m.Set(entries[i], entries[i + 1]) {
+			v.error(fmt.Sprintf("Map keys must be strings, numbers or bools. (key: '%s', of type '%s')", entries[i].String(), entries[i].Type()))
+			return STATUS_TYPE_ERROR
+		}
+	}
+
+	v.push(m)
+	return STATUS_OK
+}
+
+// Works on the slots, so the numbers aren't boxed.
 func (v *VM) binaryNum(operator byte) InterpretResult {
-	right := v.pop()
+	right := v.popSlot()
 
 	if v.stackIsEmpty() {
 		v.error("Not enough stack items to perform a binary operation")
 		return STATUS_STACK_EMPTY
 	}
 
-	left := v.pop()
+	left := v.popSlot()
 
-	if !isNumber(left) || !isNumber(right) {
+	if !left.IsNumber() || !right.IsNumber() {
 		v.error(
 			fmt.Sprintf(
 				"Operands must be numbers when performing arithmetic. (left: '%s', right: '%s')",
-				left.String(),
-				right.String(),
+				left.Value().String(),
+				right.Value().String(),
 			),
 		)
 		return STATUS_TYPE_ERROR
 	}
 
-	leftNum := left.(value.ValueNumber)
-	rightNum := right.(value.ValueNumber)
-
 	switch operator {
-		case compiler.OP_ADD: v.push(value.ValueNumber{ Value: leftNum.Value + rightNum.Value })
-		case compiler.OP_SUB: v.push(value.ValueNumber{ Value: leftNum.Value - rightNum.Value })
-		case compiler.OP_MUL: v.push(value.ValueNumber{ Value: leftNum.Value * rightNum.Value })
+		case compiler.OP_ADD: v.pushNumber(left.Num + right.Num)
+		case compiler.OP_SUB: v.pushNumber(left.Num - right.Num)
+		case compiler.OP_MUL: v.pushNumber(left.Num * right.Num)
 		case compiler.OP_DIV: {
-			if rightNum.Value == 0 {
+			if right.Num == 0 {
 				v.error(
 					fmt.Sprintf(
 						"Cannot divide by zero. (left: '%s', right: '%s')",
-						left.String(),
-						right.String(),
+						left.Value().String(),
+						right.Value().String(),
 					),
 				)
 				return STATUS_DIV_ZERO
 			}
 
-			v.push(value.ValueNumber{ Value: leftNum.Value / rightNum.Value })
+			v.pushNumber(left.Num / right.Num)
 		}
 		case compiler.OP_MOD: {
-			if rightNum.Value == 0 {
+			if right.Num == 0 {
 				v.error(
 					fmt.Sprintf(
 						"Cannot divide by zero. (left: '%s', right: '%s')",
-						left.String(),
-						right.String(),
+						left.Value().String(),
+						right.Value().String(),
 					),
 				)
 				return STATUS_DIV_ZERO
 			}
 
-			v.push(value.ValueNumber{ Value: math.Mod(leftNum.Value, rightNum.Value) })
+			v.pushNumber(math.Mod(left.Num, right.Num))
 		}
 	}
 
@@ -476,41 +744,38 @@ func (v *VM) binaryNum(operator byte) InterpretResult {
 }
 
 func (v *VM) binaryComparison(operator byte) InterpretResult {
-	right := v.pop()
+	right := v.popSlot()
 
 	if v.stackIsEmpty() {
 		v.error("Not enough stack items to perform a binary operation")
 		return STATUS_STACK_EMPTY
 	}
 
-	left := v.pop()
+	left := v.popSlot()
 
-	if !isNumber(left) || !isNumber(right) {
+	if !left.IsNumber() || !right.IsNumber() {
 		v.error(
 			fmt.Sprintf(
 				"Operands must be numbers when comparing. (left: '%s' (type '%s'), right: '%s' (type '%s'))",
-				left.String(),
-				left.Type(),
+				left.Value().String(),
+				left.Value().Type(),
 
-				right.String(),
-				right.Type(),
+				right.Value().String(),
+				right.Value().Type(),
 			),
 		)
 		return STATUS_TYPE_ERROR
 	}
 
-	leftNum := left.(value.ValueNumber)
-	rightNum := right.(value.ValueNumber)
-
 	switch operator {
 		case compiler.OP_GREATER:
-			v.push(value.ValueBool{ Value: leftNum.Value > rightNum.Value })
+			v.push(value.ValueBool{ Value: left.Num > right.Num })
 		case compiler.OP_GREATER_EQUAL:
-			v.push(value.ValueBool{ Value: leftNum.Value >= rightNum.Value })
+			v.push(value.ValueBool{ Value: left.Num >= right.Num })
 		case compiler.OP_LESS:
-			v.push(value.ValueBool{ Value: leftNum.Value < rightNum.Value })
+			v.push(value.ValueBool{ Value: left.Num < right.Num })
 		case compiler.OP_LESS_EQUAL:
-			v.push(value.ValueBool{ Value: leftNum.Value <= rightNum.Value })
+			v.push(value.ValueBool{ Value: left.Num <= right.Num })
 	}
 
 	return STATUS_OK
@@ -614,15 +879,28 @@ func (v *VM) isAtEnd() bool {
 
 // ---
 
+// The stack keeps slots, 'push', 'pop' and 'peek' box and unbox the numbers for the code that works with values.
 func (v *VM) push(f value.Value) {
-	v.stack = append(v.stack, f)
+	v.stack = append(v.stack, value.ToSlot(f))
+}
+
+func (v *VM) pushSlot(s value.Slot) {
+	v.stack = append(v.stack, s)
+}
+
+func (v *VM) pushNumber(n float64) {
+	v.stack = append(v.stack, value.NumberSlot(n))
 }
 
 // can have errors
 func (v *VM) pop() value.Value {
+	return v.popSlot().Value()
+}
+
+func (v *VM) popSlot() value.Slot {
 	if v.stackIsEmpty() {
 		v.error("Performed a pop operation on an empty stack")
-		return nil
+		return value.Slot{ Ref: value.ValueNil{} }
 	}
 
 	return util.PopList(&v.stack)
@@ -643,20 +921,24 @@ func (v *VM) popFrame() CallFrame {
 }
 
 func (v *VM) peek(offset int) value.Value {
+	return v.peekSlot(offset).Value()
+}
+
+func (v *VM) peekSlot(offset int) value.Slot {
 	pos := len(v.stack) - 1 - offset
 	if pos < 0 || pos > len(v.stack) - 1 {
 		v.error("Peek position out of bounds")
-		return nil
+		return value.Slot{ Ref: value.ValueNil{} }
 	}
 
 	return v.stack[pos]
 }
 
-func (v *VM) popVar() value.Value {
+func (v *VM) popVar() value.Slot {
 	return v.popnVar(1)
 }
 
-func (v *VM) popnVar(amount int) value.Value {
+func (v *VM) popnVar(amount int) value.Slot {
 	lastIndex := len(v.callStack[len(v.callStack)-1].locals) - amount
 	topElement := v.callStack[len(v.callStack)-1].locals[lastIndex]
 
@@ -664,39 +946,76 @@ func (v *VM) popnVar(amount int) value.Value {
 	return topElement
 }
 
+// Records the error, which is printed later by 'printError' if it's not caught.
 func (v *VM) error(message string) {
-	metadata := v.currentChunk.Metadata[v.oldIp]
+	if v.hadError {
+		return
+	}
+
+	v.errorMessage = message
+	v.errorMetadata = value.ChunkMetadata{}
+
+	// Calls from the host don't come from an instruction.
+	if v.oldIp < len(v.currentChunk.Metadata) {
+		v.errorMetadata = v.currentChunk.Metadata[v.oldIp]
+	}
+	v.hadError = true
+}
+
+// The most calls printed in the trace of an error.
+const traceLength = 20
 
-	fmt.Printf("[-] Runtime error: %s\n", message)
-	fmt.Printf(" | %s [-] %s (%d, %d)\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))), v.fileData.Name, metadata.Position.Line + 1, metadata.Position.Col + 1)
-	fmt.Printf(" |  %d | %s\n", metadata.Position.Line + 1, v.fileData.Lines[metadata.Position.Line])
-	fmt.Printf(" | %s  | %s%s\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))), strings.Repeat(" ", metadata.Position.Col), strings.Repeat("^", metadata.Length))
-	fmt.Printf(" | %s [-]\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))))
-	fmt.Println("[-]")
+func (v *VM) printError() {
+	metadata := v.errorMetadata
+
+	// The position may belong to an imported module.
+	fileData := metadata.File
+
+	if fileData == nil {
+		fileData = v.fileData
+	}
+
+	fmt.Fprintf(v.streams.Stderr, "[-] Runtime error: %s\n", v.errorMessage)
+	fmt.Fprintf(v.streams.Stderr, " | %s [-] %s (%d, %d)\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))), fileData.Name, metadata.Position.Line + 1, metadata.Position.Col + 1)
+
+	// Programs loaded from bytecode may not have their source.
+	if metadata.Position.Line >= 0 && metadata.Position.Line < len(fileData.Lines) {
+		fmt.Fprintf(v.streams.Stderr, " |  %d | %s\n", metadata.Position.Line + 1, fileData.Lines[metadata.Position.Line])
+		fmt.Fprintf(v.streams.Stderr, " | %s  | %s%s\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))), strings.Repeat(" ", metadata.Position.Col), strings.Repeat("^", metadata.Length))
+	}
+
+	fmt.Fprintf(v.streams.Stderr, " | %s [-]\n", strings.Repeat(" ", len(strconv.Itoa(metadata.Position.Line + 1))))
+	fmt.Fprintln(v.streams.Stderr, "[-]")
 
 	if len(v.callStack) > 0 {
 		for i := len(v.callStack) - 1; i >= 0; i-- {
-			posChunk := v.topLevel
+			// Deep recursions only show the innermost and the outermost calls.
+			if len(v.callStack) > traceLength && i == len(v.callStack) - 1 - traceLength / 2 {
+				omitted := len(v.callStack) - traceLength / 2 * 2
+				fmt.Fprintf(v.streams.Stderr, " | ... %d more calls\n", omitted)
+				i -= omitted - 1
+				continue
+			}
+
 			frame := v.callStack[i]
+			posChunk := frame.chunk
+			name := "<anonymous>"
 
-			if i > 0 {
-				posChunk = v.callStack[i - 1].function.Fn.Chunk
+			if frame.function.Fn.Name != nil {
+				name = *frame.function.Fn.Name
 			}
 
-			pos := posChunk.Metadata[frame.oldIp - 1].Position
-			name := frame.function.Fn.Name
-
-			if name == nil {
-				fmt.Printf(" | in <anonymous> (%d, %d)\n", pos.Line + 1, pos.Col + 1)
+			// The calls from the host don't come from an instruction.
+			if frame.callIp < len(posChunk.Metadata) {
+				pos := posChunk.Metadata[frame.callIp].Position
+				fmt.Fprintf(v.streams.Stderr, " | in %s (%d, %d)\n", name, pos.Line + 1, pos.Col + 1)
 			} else {
-				fmt.Printf(" | in %s (%d, %d)\n", *name, pos.Line + 1, pos.Col + 1)
+				fmt.Fprintf(v.streams.Stderr, " | in %s\n", name)
 			}
 		}
 
-		fmt.Print("[-]\n\n")
+		fmt.Fprint(v.streams.Stderr, "[-]\n\n")
 	} else {
-		fmt.Println()
+		fmt.Fprintln(v.streams.Stderr)
 	}
-	
-	v.hadError = true
 }
@@ -1,7 +1,10 @@
 package vm
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"time"
 	"vm-go/compiler"
 	"vm-go/util"
 	"vm-go/value"
@@ -18,48 +21,132 @@ const (
 	STATUS_INCORRECT_ARITY
 	STATUS_PROPERTY_DOESNT_EXIST
     STATUS_UNREACHABLE_RANGE
+	STATUS_KEY_DOESNT_EXIST
+	STATUS_NATIVE_ERROR
+	STATUS_STACK_OVERFLOW
+	STATUS_INSTRUCTION_LIMIT
+	STATUS_TIMEOUT
+	STATUS_CANCELLED
+	STATUS_CAPABILITY_DENIED
+	STATUS_STOPPED
 )
 
+// The name of the error kind, as seen by 'catch' blocks.
+func (r InterpretResult) String() string {
+	switch r {
+		case STATUS_OK: return "ok"
+		case STATUS_STACK_EMPTY: return "stack empty"
+		case STATUS_OUT_OF_BOUNDS: return "out of bounds"
+		case STATUS_DIV_ZERO: return "division by zero"
+		case STATUS_TYPE_ERROR: return "type error"
+		case STATUS_INCORRECT_ARITY: return "incorrect arity"
+		case STATUS_PROPERTY_DOESNT_EXIST: return "property doesn't exist"
+		case STATUS_UNREACHABLE_RANGE: return "unreachable range"
+		case STATUS_KEY_DOESNT_EXIST: return "key doesn't exist"
+		case STATUS_NATIVE_ERROR: return "native error"
+		case STATUS_STACK_OVERFLOW: return "stack overflow"
+		case STATUS_INSTRUCTION_LIMIT: return "instruction limit"
+		case STATUS_TIMEOUT: return "timeout"
+		case STATUS_CANCELLED: return "cancelled"
+		case STATUS_CAPABILITY_DENIED: return "capability denied"
+		case STATUS_STOPPED: return "stopped"
+
+		default: return "unknown"
+	}
+}
+
 type VM struct {
 	currentChunk *value.Chunk
 	topLevel     value.Chunk
 
-	stack     []value.Value
-	globals   []value.Value
+	stack     []value.Slot
+	globals   []value.Slot
 	callStack []CallFrame
 	openUpvalues  []*value.Upvalue // can be a linked list also, and it owns them.
+	handlers  []ErrorHandler
  
 	ip    int
 	oldIp int
 
 	hadError bool
+	errorMessage string
+	errorMetadata value.ChunkMetadata
+
+	limits   Limits
+	sandbox  *value.Sandbox
+	deadline time.Time
+	context  context.Context
+
+	// The instructions run by 'Run', and when the limits are checked again.
+	executed  int
+	nextCheck int
+
+	hooks []Hook
+
 	fileData *util.FileData
+	streams *util.Streams
 }
 
-func NewVM(chunk value.Chunk, fileData *util.FileData) *VM {
+func NewVM(chunk value.Chunk, fileData *util.FileData, registry *value.Registry, streams *util.Streams) *VM {
 	vm := VM{
-		currentChunk: &chunk,
 		topLevel: chunk,
 
-		stack:     []value.Value{},
-		globals:   []value.Value{},
+		stack:     []value.Slot{},
+		globals:   []value.Slot{},
 		callStack: []CallFrame{},
 		openUpvalues:  []*value.Upvalue{},
+		handlers:  []ErrorHandler{},
 
 		ip:        0,
 		oldIp:     0,
 
 		hadError:  false,
+		limits: DefaultLimits,
+		sandbox: registry.Sandbox(),
 		fileData: fileData,
+		streams: streams,
 	}
 
-	vm.includeNativeFns()
+	// The globals of the registry come first, as the compiler expects.
+	for _, global := range registry.Values() {
+		vm.globals = append(vm.globals, value.ToSlot(value.CopyValue(global)))
+	}
+
+	vm.currentChunk = &vm.topLevel
+
 	return &vm
 }
 
 func (v *VM) Run() InterpretResult {
+	v.executed = 0
+	v.nextCheck = 0
+
+	for {
+		status := v.run()
+
+		if !v.hadError {
+			return status
+		}
+
+		// The script can't catch the end of its budget, or it could keep running.
+		if status.stopsExecution() || !v.catchError(status) {
+			v.printError()
+			return status
+		}
+	}
+}
+
+func (v *VM) run() InterpretResult {
 	for !v.isAtEnd() && !v.hadError {
 		v.oldIp = v.ip
+		v.executed++
+
+		if v.executed >= v.nextCheck {
+			if status := v.checkExecution(); status != STATUS_OK {
+				return status
+			}
+		}
+
  		i := v.nextByte()
 
 		switch i {
@@ -106,14 +193,21 @@ func (v *VM) Run() InterpretResult {
 
 				// the record is at the top of the stack now.
 				record := v.pop().(value.ValueRecord)
-				record.Methods = methods
+				record.SetMethods(methods)
 
 				v.push(record)
 			}
 
 			// TODO: add a separated opcode for concatenating strings when typechecking is added
 			case compiler.OP_ADD: {
-				if !typesEqual(v.peek(0), v.peek(1)) {
+				// The numbers are the hot path, and they're added without being boxed.
+				if v.peekSlot(0).IsNumber() && v.peekSlot(1).IsNumber() {
+					status := v.binaryNum(i)
+
+					if status != STATUS_OK {
+						return status
+					}
+				} else if !typesEqual(v.peek(0), v.peek(1)) {
 					v.error(
 						fmt.Sprintf(
 							"Operands types must be equal when adding/concatenating. (left: '%s' (type '%s'), right: '%s' (type '%s'))",
@@ -125,18 +219,11 @@ func (v *VM) Run() InterpretResult {
 						),
 					)
 					return STATUS_TYPE_ERROR
-				}
 
 				// if one operand is string, the other should be too
-				if isString(v.peek(0)) {
+				} else if isString(v.peek(0)) {
 					status := v.concatenateStrs()
 
-					if status != STATUS_OK {
-						return status
-					}
-				} else if isNumber(v.peek(0)) {
-					status := v.binaryNum(i)
-
 					if status != STATUS_OK {
 						return status
 					}
@@ -155,6 +242,19 @@ func (v *VM) Run() InterpretResult {
 				}
 			}
 
+			case compiler.OP_CONCAT: {
+				parts := v.getArguments(v.getInt())
+				util.Reverse(parts)
+
+				builder := strings.Builder{}
+
+				for _, part := range parts {
+					builder.WriteString(part.String())
+				}
+
+				v.push(value.ValueString{ Value: builder.String() })
+			}
+
 			case compiler.OP_SUB, compiler.OP_MUL, compiler.OP_DIV, compiler.OP_MOD: {
 				status := v.binaryNum(i)
 
@@ -164,38 +264,39 @@ func (v *VM) Run() InterpretResult {
 			}
 
 			case compiler.OP_DEF_LOCAL:
-				v.callStack[len(v.callStack)-1].locals = append(v.callStack[len(v.callStack)-1].locals, value.CopyValue(v.pop()))
+				v.callStack[len(v.callStack)-1].locals = append(v.callStack[len(v.callStack)-1].locals, v.popSlot().Copy())
 
 			case compiler.OP_GET_LOCAL:
-				v.push(v.callStack[len(v.callStack)-1].locals[v.getInt()])
+				v.pushSlot(v.callStack[len(v.callStack)-1].locals[v.getInt()])
 
 			case compiler.OP_SET_LOCAL:
-				v.callStack[len(v.callStack)-1].locals[v.getInt()] = value.CopyValue(v.peek(0))
+				v.callStack[len(v.callStack)-1].locals[v.getInt()] = v.peekSlot(0).Copy()
 
 			case compiler.OP_GET_UPVALUE: {
 				slot := v.getInt()
-				v.push(v.getUpvalueValue(v.callStack[len(v.callStack)-1].function.Upvalues[slot]))
+				v.pushSlot(v.getUpvalueValue(v.callStack[len(v.callStack)-1].function.Upvalues[slot]))
 			}
 
 			case compiler.OP_SET_UPVALUE: {
 				slot := v.getInt()
-				v.setUpvalueValue(v.callStack[len(v.callStack)-1].function.Upvalues[slot], value.CopyValue(v.peek(0)))
+				v.setUpvalueValue(v.callStack[len(v.callStack)-1].function.Upvalues[slot], v.peekSlot(0).Copy())
 			}
 
 			case compiler.OP_DEF_GLOBAL:
-				v.globals = append(v.globals, value.CopyValue(v.pop()))
+				v.globals = append(v.globals, v.popSlot().Copy())
 
 			case compiler.OP_GET_GLOBAL:
-				v.push(v.globals[v.getInt()])
+				v.pushSlot(v.globals[v.getInt()])
 
 			case compiler.OP_SET_GLOBAL:
-				v.globals[v.getInt()] = value.CopyValue(v.peek(0))
+				v.globals[v.getInt()] = v.peekSlot(0).Copy()
 
 			case compiler.OP_GET_PROPERTY: {
 				obj := v.pop()
 				index := v.getInt()
+				cache := v.getInt()
 				
-				res := v.getProperty(obj, index)
+				res := v.getProperty(obj, index, cache)
 
 				if res != STATUS_OK {
 					return res
@@ -206,10 +307,34 @@ func (v *VM) Run() InterpretResult {
 				val := v.pop()
 				obj := v.pop()
 				index := v.getInt()
+				cache := v.getInt()
 				
                 // the range properties aren't being changed maybe it's because
                 // the data is copied instead of being referenced
-				res := v.setProperty(obj, index, val)
+				res := v.setProperty(obj, index, cache, val)
+
+				if res != STATUS_OK {
+					return res
+				}
+			}
+
+			case compiler.OP_GET_INDEX: {
+				index := v.pop()
+				obj := v.pop()
+
+				res := v.getIndex(obj, index)
+
+				if res != STATUS_OK {
+					return res
+				}
+			}
+
+			case compiler.OP_SET_INDEX: {
+				val := v.pop()
+				index := v.pop()
+				obj := v.pop()
+
+				res := v.setIndex(obj, index, val)
 
 				if res != STATUS_OK {
 					return res
@@ -273,26 +398,15 @@ func (v *VM) Run() InterpretResult {
 			}
             
             case compiler.OP_JUMP_HAS_NO_NEXT: {
-                iterator := v.peek(0)
+                it, status := v.peekIterator()
                 amount := v.getInt()
 
-                switch it := iterator.(type) {
-                    case value.RangeIterator: {
-                        if !it.HasNext() {
-                            v.ip += amount
-                        }
-                    }
-                    
-					case value.StrBytesIterator: {
-                        if !it.HasNext() {
-                            v.ip += amount
-                        }
-                    }
-
-                    default: {
-                        v.error(fmt.Sprintf("Expected iterator, got '%s', of type '%s'.", iterator.String(), iterator.Type()))
-                        return STATUS_TYPE_ERROR
-                    }
+                if status != STATUS_OK {
+                    return status
+                }
+
+                if !it.HasNext() {
+                    v.ip += amount
                 }
             }
 
@@ -300,6 +414,14 @@ func (v *VM) Run() InterpretResult {
 				v.ip -= v.getInt()
 
 			case compiler.OP_EQUAL: {
+				if v.peekSlot(0).IsNumber() && v.peekSlot(1).IsNumber() {
+					b := v.popSlot()
+					a := v.popSlot()
+
+					v.push(value.ValueBool{ Value: a.Num == b.Num })
+					break
+				}
+
 				b := v.pop()
 				a := v.pop()
 
@@ -321,6 +443,14 @@ func (v *VM) Run() InterpretResult {
 			}
 
 			case compiler.OP_NOT_EQUAL: {
+				if v.peekSlot(0).IsNumber() && v.peekSlot(1).IsNumber() {
+					b := v.popSlot()
+					a := v.popSlot()
+
+					v.push(value.ValueBool{ Value: a.Num != b.Num })
+					break
+				}
+
 				b := v.pop()
 				a := v.pop()
 
@@ -370,15 +500,14 @@ func (v *VM) Run() InterpretResult {
 			}
 
 			case compiler.OP_NEGATE: {
-				op := v.pop()
+				op := v.popSlot()
 
-				if !isNumber(op) {
-					v.error(fmt.Sprintf("Given expression ('%s') type is not 'num' to perform a number negation. Its type is '%s'.", op.String(), op.Type()))
+				if !op.IsNumber() {
+					v.error(fmt.Sprintf("Given expression ('%s') type is not 'num' to perform a number negation. Its type is '%s'.", op.Ref.String(), op.Ref.Type()))
 					return STATUS_TYPE_ERROR
 				}
 
-				opNum := op.(value.ValueNumber)
-				v.push(value.ValueNumber{ Value: -opNum.Value })
+				v.pushNumber(-op.Num)
 			}
 
             case compiler.OP_MAKE_RANGE: {
@@ -417,40 +546,48 @@ func (v *VM) Run() InterpretResult {
             }
 
             case compiler.OP_GET_NEXT: {
-                iterator := v.peek(0)
-
-                switch it := iterator.(type) {
-                    case value.RangeIterator:
-                        v.push(it.GetNext())
-                    
-					case value.StrBytesIterator:
-                        v.push(it.GetNext())
-
-                    default: {
-                        v.error(fmt.Sprintf("Expected iterator, got '%s', of type '%s'.", iterator.String(), iterator.Type()))
-                        return STATUS_TYPE_ERROR
-                    }
+                it, status := v.peekIterator()
+
+                if status != STATUS_OK {
+                    return status
+                }
+
+                // The ranges are the common case, and their numbers don't need to be boxed.
+                if rg, ok := it.(*value.RangeIterator); ok {
+                    v.pushNumber(rg.Count)
+                } else {
+                    v.push(it.GetNext())
                 }
             }
             
+            // The iterator is a pointer, so it's advanced in place.
             case compiler.OP_ADVANCE: {
-                iterator := v.pop()
-
-                switch it := iterator.(type) {
-                    case value.RangeIterator: {
-                        it.Advance()
-						v.push(it)
-                    }
-                    
-					case value.StrBytesIterator: {
-                        it.Advance()
-						v.push(it)
-                    }
-
-                    default: {
-                        v.error(fmt.Sprintf("Expected iterator, got '%s', of type '%s'.", iterator.String(), iterator.Type()))
-                        return STATUS_TYPE_ERROR
-                    }
+                it, status := v.peekIterator()
+
+                if status != STATUS_OK {
+                    return status
+                }
+
+                it.Advance()
+            }
+
+            case compiler.OP_MAKE_LIST: {
+                count := v.getInt()
+                elements := v.getArguments(count)
+
+                util.Reverse(elements)
+                v.push(value.NewValueList(elements))
+            }
+
+            case compiler.OP_MAKE_MAP: {
+                count := v.getInt()
+                entries := v.getArguments(count * 2)
+                util.Reverse(entries)
+
+                status := v.makeMap(entries)
+
+                if status != STATUS_OK {
+                    return status
                 }
             }
 
@@ -466,9 +603,10 @@ func (v *VM) Run() InterpretResult {
 			case compiler.OP_CALL_PROPERTY: {
 				index := v.getInt()
 				arity := v.getInt()
+				cache := v.getInt()
 
 				obj := v.peek(arity)
-				property, res := v.getPropertyValue(obj, index)
+				property, res := v.getPropertyValue(obj, index, cache)
 
 				if res != STATUS_OK {
 					return res
@@ -484,18 +622,37 @@ func (v *VM) Run() InterpretResult {
 			case compiler.OP_RETURN: {
 				v.closeUpvalues(len(v.callStack) - 1)
 				frame := v.popFrame()
-				var chunk value.Chunk
 
-				if len(v.callStack) == 0 {
-					chunk = v.topLevel
-				} else {
-					chunk = v.callStack[len(v.callStack) - 1].function.Fn.Chunk
+				v.ip = frame.oldIp
+				v.currentChunk = frame.chunk
+
+				// Discard the 'try' blocks of the returning function.
+				for len(v.handlers) > 0 && v.handlers[len(v.handlers) - 1].frameCount > len(v.callStack) {
+					util.PopList(&v.handlers)
 				}
+			}
 
-				v.ip = frame.oldIp
-				v.currentChunk = &chunk
+			case compiler.OP_PUSH_HANDLER: {
+				offset := v.getInt()
+				localsCount := 0
+
+				if len(v.callStack) > 0 {
+					localsCount = len(v.callStack[len(v.callStack) - 1].locals)
+				}
+
+				v.handlers = append(v.handlers, ErrorHandler{
+					catchIp: v.ip + offset,
+					chunk: v.currentChunk,
+
+					frameCount: len(v.callStack),
+					stackSize: len(v.stack),
+					localsCount: localsCount,
+				})
 			}
 
+			case compiler.OP_POP_HANDLER:
+				util.PopList(&v.handlers)
+
 			case compiler.OP_PUSH_TRUE: v.push(value.ValueBool{ Value: true })
 			case compiler.OP_PUSH_FALSE: v.push(value.ValueBool{ Value: false })
 
@@ -505,6 +662,7 @@ func (v *VM) Run() InterpretResult {
 			case compiler.OP_ASSERT_BOOL: {
 				if !isBool(v.peek(0)) {
 					v.error(fmt.Sprintf("Given expression ('%s') type is not 'bool'. Its type is '%s'.", v.peek(0).String(),  v.peek(0).Type()))
+					return STATUS_TYPE_ERROR
 				}
 			}
 
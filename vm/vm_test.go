@@ -0,0 +1,272 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"vm-go/checker"
+	"vm-go/compiler"
+	"vm-go/lexer"
+	"vm-go/parser"
+	"vm-go/util"
+	"vm-go/value"
+)
+
+// The programs of the benchmarks, which don't print anything, so only the VM is measured.
+var benchmarks = map[string]string{
+	// Numbers created and stored in locals on every iteration.
+	"Arithmetic": `fn main() {
+    var sum = 0;
+
+    for var i = 0; i < 20000; i = i + 1 {
+        sum = sum + i * 2 % 7;
+    }
+}`,
+
+	"Fibonacci": `fn fib(n) {
+    if n < 2 {
+        return n;
+    }
+
+    return fib(n - 1) + fib(n - 2);
+}
+
+fn main() {
+    fib(18);
+}`,
+
+	// Functions and closures stored in locals and passed as arguments.
+	"Closures": `fn apply(f, x) {
+    return f(x);
+}
+
+fn main() {
+    var offset = 1;
+    var total = 0;
+
+    for var i = 0; i < 5000; i = i + 1 {
+        var add = (x) -> x + offset;
+        total = apply(add, total);
+    }
+}`,
+
+	"Records": `record Point(x, y) {
+    fn sum() {
+        return self.x + self.y;
+    }
+}
+
+fn main() {
+    var p = Point(0, 0);
+    var total = 0;
+
+    for var i = 0; i < 5000; i = i + 1 {
+        p.x = p.x + 1;
+        p.y = i;
+        total = total + p.sum();
+    }
+}`,
+
+	"Strings": `fn main() {
+    var s = "";
+
+    for var i = 0; i < 2000; i = i + 1 {
+        var t = s;
+        s = "x";
+        s = t + "y";
+    }
+}`,
+}
+
+// Each frame of the recursion leaves a value in the stack, so it can overflow either limit first.
+func TestLimits(t *testing.T) {
+	source := `fn recurse(n) {
+    return 1 + recurse(n + 1);
+}
+
+fn main() {
+    recurse(0);
+}`
+
+	cases := []struct {
+		limits  Limits
+		message string
+	}{
+		{ Limits{ MaxCallDepth: 50 }, "nested more than 50 times" },
+		{ Limits{ MaxCallDepth: 1000, MaxStackSize: 50 }, "holds more than 50 values" },
+	}
+
+	for _, c := range cases {
+		stderr := strings.Builder{}
+		streams := util.NewStreams(io.Discard, &stderr, strings.NewReader(""))
+		fileData := util.FileData{ Name: "limits.vm", Path: "limits.vm", Lines: strings.Split(source, "\n") }
+		registry := DefaultRegistry(streams)
+
+		vm := NewVM(compile(t, source, &fileData, registry, streams), &fileData, registry, streams)
+		vm.SetLimits(c.limits)
+
+		if status := vm.Run(); status != STATUS_STACK_OVERFLOW {
+			t.Errorf("%+v: expected a stack overflow, got '%s'", c.limits, status)
+		}
+
+		if !strings.Contains(stderr.String(), c.message) {
+			t.Errorf("%+v: expected the error to say '%s', got:\n%s", c.limits, c.message, stderr.String())
+		}
+
+		// The trace is cut in the middle.
+		if !strings.Contains(stderr.String(), "more calls") {
+			t.Errorf("%+v: expected a truncated trace, got:\n%s", c.limits, stderr.String())
+		}
+	}
+}
+
+// An endless loop is stopped by each limit, and 'try' can't catch it.
+func TestExecutionLimits(t *testing.T) {
+	source := `fn main() {
+    try {
+        loop {}
+    } catch {
+        println("caught");
+    }
+}`
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name   string
+		setup  func(vm *VM)
+		status InterpretResult
+	}{
+		{ "budget", func(vm *VM) { vm.SetLimits(Limits{ MaxInstructions: 5000 }) }, STATUS_INSTRUCTION_LIMIT },
+		{ "deadline", func(vm *VM) { vm.SetDeadline(time.Now().Add(10 * time.Millisecond)) }, STATUS_TIMEOUT },
+		{ "context", func(vm *VM) { vm.SetContext(cancelled) }, STATUS_CANCELLED },
+	}
+
+	for _, c := range cases {
+		stdout := strings.Builder{}
+		streams := util.NewStreams(&stdout, io.Discard, strings.NewReader(""))
+		fileData := util.FileData{ Name: "loop.vm", Path: "loop.vm", Lines: strings.Split(source, "\n") }
+		registry := DefaultRegistry(streams)
+
+		vm := NewVM(compile(t, source, &fileData, registry, streams), &fileData, registry, streams)
+		c.setup(vm)
+
+		if status := vm.Run(); status != c.status {
+			t.Errorf("%s: expected '%s', got '%s'", c.name, c.status, status)
+		}
+
+		if stdout.Len() > 0 {
+			t.Errorf("%s: the error was caught", c.name)
+		}
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+
+	source := fmt.Sprintf(`fn main() {
+    writeFile("%[1]s/a.txt", "data");
+    println(readFile("%[1]s/a.txt"));
+
+    try {
+        readFile("%[2]s/b.txt");
+    } catch e {
+        println(e.kind);
+    }
+}`, dir, outside)
+
+	run := func(sandbox *value.Sandbox, compileWith *value.Sandbox) (string, string, InterpretResult, bool) {
+		stdout := strings.Builder{}
+		stderr := strings.Builder{}
+		streams := util.NewStreams(&stdout, &stderr, strings.NewReader(""))
+		fileData := util.FileData{ Name: "sandbox.vm", Path: "sandbox.vm", Lines: strings.Split(source, "\n") }
+		registry := DefaultRegistry(streams)
+
+		registry.SetSandbox(compileWith)
+		chunk, ok := tryCompile(source, &fileData, registry, streams)
+
+		if !ok {
+			return stdout.String(), stderr.String(), STATUS_OK, false
+		}
+
+		registry.SetSandbox(sandbox)
+		status := NewVM(chunk, &fileData, registry, streams).Run()
+
+		return stdout.String(), stderr.String(), status, true
+	}
+
+	granted := value.NewSandbox(value.CapabilityIO)
+
+	if err := granted.AllowDir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the allowed directory can be read.
+	if stdout, stderr, status, _ := run(granted, granted); status != STATUS_OK || stdout != "data\nnative error\n" {
+		t.Errorf("expected the file to be read, got '%s' (%s):\n%s", stdout, status, stderr)
+	}
+
+	// Referring to a native that isn't granted is a compile error.
+	ioOnly := value.NewSandbox(value.CapabilityIO)
+
+	if _, stderr, _, compiled := run(ioOnly, ioOnly); compiled || !strings.Contains(stderr, "'writeFile' needs the 'fs' capability") {
+		t.Errorf("expected a compile error naming the capability, got:\n%s", stderr)
+	}
+
+	// And calling it is a runtime error, when the program was compiled without the sandbox.
+	if _, stderr, status, _ := run(ioOnly, nil); status != STATUS_CAPABILITY_DENIED || !strings.Contains(stderr, "'writeFile' needs the 'fs' capability") {
+		t.Errorf("expected a runtime error naming the capability, got '%s':\n%s", status, stderr)
+	}
+}
+
+func BenchmarkPrograms(b *testing.B) {
+	for name, source := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			streams := util.NewStreams(io.Discard, io.Discard, strings.NewReader(""))
+			fileData := util.FileData{ Name: "bench.vm", Path: "bench.vm", Lines: strings.Split(source, "\n") }
+			registry := DefaultRegistry(streams)
+			chunk := compile(b, source, &fileData, registry, streams)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for range b.N {
+				if status := NewVM(chunk, &fileData, registry, streams).Run(); status != STATUS_OK {
+					b.Fatalf("the program failed: %s", status)
+				}
+			}
+		})
+	}
+}
+
+func compile(t testing.TB, source string, fileData *util.FileData, registry *value.Registry, streams *util.Streams) value.Chunk {
+	chunk, ok := tryCompile(source, fileData, registry, streams)
+
+	if !ok {
+		t.Fatal("the program has errors")
+	}
+
+	return chunk
+}
+
+func tryCompile(source string, fileData *util.FileData, registry *value.Registry, streams *util.Streams) (value.Chunk, bool) {
+	tokens, hadError := lexer.NewLexer(source, fileData, streams).Lex()
+
+	if hadError {
+		return value.Chunk{}, false
+	}
+
+	ast, hadError := parser.NewParser(tokens, fileData, streams).Parse()
+
+	if hadError || checker.NewChecker(ast, fileData, registry, streams).Check() {
+		return value.Chunk{}, false
+	}
+
+	chunk, hadError := compiler.NewCompiler(ast, fileData, registry, streams).Compile()
+	return chunk, !hadError
+}